@@ -0,0 +1,76 @@
+// rotatekey 离线轮换数据目录的加密密钥，数据目录不能处于打开状态
+//
+//	rotatekey -dir /tmp/bitcask-go -old-key-file old.key -old-key-id 1 -new-key-file new.key -new-key-id 2
+//
+// 密钥文件中保存十六进制编码的 AES 密钥，不指定旧密钥表示数据目录没有加密，不指定新密钥表示解密为明文
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	bitcask "github.com/tClown11/kv-storage/db"
+	"github.com/tClown11/kv-storage/fio"
+)
+
+func main() {
+	dir := flag.String("dir", "", "database dir path")
+	oldKeyFile := flag.String("old-key-file", "", "file containing the hex encoded current key, empty if the dir is not encrypted")
+	oldKeyID := flag.Uint("old-key-id", 0, "id of the current key")
+	newKeyFile := flag.String("new-key-file", "", "file containing the hex encoded new key, empty to decrypt the dir")
+	newKeyID := flag.Uint("new-key-id", 0, "id of the new key")
+	flag.Parse()
+
+	if *dir == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	oldKeys, err := loadKeyProvider(*oldKeyFile, uint32(*oldKeyID))
+	if err != nil {
+		fatal(err)
+	}
+	newKeys, err := loadKeyProvider(*newKeyFile, uint32(*newKeyID))
+	if err != nil {
+		fatal(err)
+	}
+	if oldKeys != nil && newKeys != nil && *oldKeyID == *newKeyID {
+		fatal(fmt.Errorf("the new key id must be different from the old key id"))
+	}
+
+	opts := bitcask.DefaultOptions
+	opts.DirPath = *dir
+	if oldKeys != nil {
+		opts.KeyProvider = oldKeys
+	}
+	var keys fio.KeyProvider
+	if newKeys != nil {
+		keys = newKeys
+	}
+	if err := bitcask.RotateKeys(opts, keys); err != nil {
+		fatal(err)
+	}
+}
+
+// loadKeyProvider 从文件中读取十六进制编码的密钥
+func loadKeyProvider(fileName string, id uint32) (*fio.StaticKeyProvider, error) {
+	if fileName == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key file %s: %w", fileName, err)
+	}
+	return fio.NewStaticKeyProvider(id, key)
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "rotatekey:", err)
+	os.Exit(1)
+}
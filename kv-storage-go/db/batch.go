@@ -5,6 +5,7 @@ import (
 	"sync/atomic"
 
 	"github.com/tClown11/kv-storage/errs"
+	"github.com/tClown11/kv-storage/index"
 	"github.com/tClown11/kv-storage/structure"
 )
 
@@ -32,8 +33,8 @@ func (db *DB) NewWriteBatch(opts WriteBatchOptions) *Writebatch {
 
 // Put 批量写入数据
 func (wb *Writebatch) Put(key []byte, value []byte) error {
-	if len(key) == 0 {
-		return errs.ErrKeyIsEmpty
+	if err := wb.db.checkKey(key); err != nil {
+		return err
 	}
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
@@ -53,7 +54,10 @@ func (wb *Writebatch) Delete(key []byte) error {
 	defer wb.mu.Unlock()
 
 	// 数据不存在则直接返回
-	logRecordPos := wb.db.index.Get(key)
+	logRecordPos, err := index.GetWithError(wb.db.index, key)
+	if err != nil {
+		return err
+	}
 	if logRecordPos == nil {
 		if wb.pendingWrites[string(key)] != nil {
 			delete(wb.pendingWrites, string(key))
@@ -77,7 +81,15 @@ func (wb *Writebatch) Commit() error {
 		return errs.ErrExceedMaxBatchNum
 	}
 
+	var size int
+	for _, record := range wb.pendingWrites {
+		size += len(record.Key) + len(record.Value)
+	}
+	wb.db.writeLimiter.Wait(size)
+
 	// 加锁保证事务提交串行化
+	wb.db.writeMu.Lock()
+	defer wb.db.writeMu.Unlock()
 	wb.db.mu.Lock()
 	defer wb.db.mu.Unlock()
 
@@ -117,15 +129,23 @@ func (wb *Writebatch) Commit() error {
 	// 更新内存索引
 	for _, record := range wb.pendingWrites {
 		pos := positions[string(record.Key)]
-		var oldPos *structure.LogRecordPos
+		var (
+			oldPos *structure.LogRecordPos
+			err    error
+		)
 		if record.Type == structure.LogRecordNormal {
-			oldPos = wb.db.index.Put(record.Key, pos)
+			oldPos, err = index.PutWithError(wb.db.index, record.Key, pos)
+			wb.db.putSecondary(record.Key, record.Value)
 		}
 		if record.Type == structure.LogRecordDeleted {
-			oldPos, _ = wb.db.index.Delete(record.Key)
+			oldPos, _, err = index.DeleteWithError(wb.db.index, record.Key)
+			wb.db.deleteSecondary(record.Key)
+		}
+		if err != nil {
+			return err
 		}
 		if oldPos != nil {
-			wb.db.reclaimSize += int64(oldPos.Size)
+			atomic.AddInt64(&wb.db.reclaimSize, int64(oldPos.Size))
 		}
 	}
 
@@ -0,0 +1,272 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/tClown11/kv-storage/index"
+	"github.com/tClown11/kv-storage/structure"
+)
+
+const (
+	checkpointHeaderKey = "index.checkpoint"
+
+	// 写检查点文件时的缓冲区大小
+	checkpointBufferSize = 1024 * 1024
+)
+
+var errCheckpointCorrupted = errors.New("index checkpoint file is corrupted")
+
+// checkpointHeader 检查点文件的头部信息
+type checkpointHeader struct {
+	fid         uint32 // 检查点覆盖到的数据文件 id
+	offset      int64  // 检查点覆盖到的数据文件偏移
+	seqNo       uint64 // 事务序列号
+	reclaimSize int64  // 检查点之前的无效数据量
+}
+
+func (h *checkpointHeader) encode() []byte {
+	buf := make([]byte, binary.MaxVarintLen32+binary.MaxVarintLen64*3)
+	var index = 0
+	index += binary.PutUvarint(buf[index:], uint64(h.fid))
+	index += binary.PutVarint(buf[index:], h.offset)
+	index += binary.PutUvarint(buf[index:], h.seqNo)
+	index += binary.PutVarint(buf[index:], h.reclaimSize)
+	return buf[:index]
+}
+
+func decodeCheckpointHeader(buf []byte) (*checkpointHeader, error) {
+	var index = 0
+	fid, n := binary.Uvarint(buf[index:])
+	if n <= 0 {
+		return nil, errCheckpointCorrupted
+	}
+	index += n
+	offset, n := binary.Varint(buf[index:])
+	if n <= 0 {
+		return nil, errCheckpointCorrupted
+	}
+	index += n
+	seqNo, n := binary.Uvarint(buf[index:])
+	if n <= 0 {
+		return nil, errCheckpointCorrupted
+	}
+	index += n
+	reclaimSize, n := binary.Varint(buf[index:])
+	if n <= 0 {
+		return nil, errCheckpointCorrupted
+	}
+	return &checkpointHeader{fid: uint32(fid), offset: offset, seqNo: seqNo, reclaimSize: reclaimSize}, nil
+}
+
+// CheckpointIndex 将内存索引的快照写入检查点文件，下次启动时只需要加载检查点之后写入的数据
+// Close 时会自动写入检查点，持久化索引不需要检查点文件，KeyHash 索引不写入检查点
+func (db *DB) CheckpointIndex() error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.checkpointIndex()
+}
+
+// checkpointIndex 写入索引检查点，调用方需要持有 db.writeMu 和 db.mu
+func (db *DB) checkpointIndex() error {
+	if db.activeFile == nil {
+		return nil
+	}
+	if _, ok := db.index.(index.Persistent); ok {
+		return nil
+	}
+	// KeyHash 索引中没有 key，写入检查点需要扫描全部的数据文件，与重新加载索引的代价相当
+	if _, ok := db.index.(*index.KeyHashIndex); ok {
+		return nil
+	}
+
+	// 检查点覆盖的数据必须已经持久化
+	if err := db.activeFile.Sync(); err != nil {
+		return err
+	}
+
+	// 先写入临时文件，完成之后再重命名，避免覆盖掉有效的检查点
+	tempFileName := filepath.Join(db.options.DirPath, structure.IndexCheckpointTempFileName)
+	if err := db.fs.Remove(tempFileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	checkpointFile, err := structure.OpenIndexCheckpointTempFile(db.fs, db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	defer checkpointFile.Close()
+
+	header := &checkpointHeader{
+		fid:         db.activeFile.FileID,
+		offset:      db.activeFile.WriteOff,
+		seqNo:       db.seqNo,
+		reclaimSize: atomic.LoadInt64(&db.reclaimSize),
+	}
+	buf := make([]byte, 0, checkpointBufferSize)
+	writeRecord := func(record *structure.LogRecord) error {
+		encRecord, _ := record.EncodeLogRecord()
+		buf = append(buf, encRecord...)
+		if len(buf) < checkpointBufferSize {
+			return nil
+		}
+		err := checkpointFile.Write(buf)
+		buf = buf[:0]
+		return err
+	}
+
+	if err := writeRecord(&structure.LogRecord{Key: []byte(checkpointHeaderKey), Value: header.encode()}); err != nil {
+		return err
+	}
+	var count uint64
+	iterator := db.index.Iterator(false)
+	defer iterator.Close()
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		record := &structure.LogRecord{
+			Key:   iterator.Key(),
+			Value: structure.EncodeLogRecordPos(iterator.Value()),
+		}
+		if err := writeRecord(record); err != nil {
+			return err
+		}
+		count++
+	}
+	// 最后记录索引的数量，用于校验检查点文件是否完整，用户的 key 不会为空，以空 key 作为区分
+	countBuf := binary.AppendUvarint(nil, count)
+	if err := writeRecord(&structure.LogRecord{Value: countBuf}); err != nil {
+		return err
+	}
+	if len(buf) > 0 {
+		if err := checkpointFile.Write(buf); err != nil {
+			return err
+		}
+	}
+	if err := checkpointFile.Sync(); err != nil {
+		return err
+	}
+	return db.fs.Rename(tempFileName, filepath.Join(db.options.DirPath, structure.IndexCheckpointFileName))
+}
+
+// loadIndexFromCheckpointFile 从检查点文件中加载索引，返回检查点覆盖到的位置
+// 检查点文件不存在、已经过期或者损坏时返回 nil，由调用方加载全部的索引
+func (db *DB) loadIndexFromCheckpointFile() (*structure.LogRecordPos, error) {
+	if _, ok := db.index.(index.Persistent); ok {
+		return nil, nil
+	}
+	fileName := filepath.Join(db.options.DirPath, structure.IndexCheckpointFileName)
+	if _, err := db.fs.Stat(fileName); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	checkpointFile, err := structure.OpenIndexCheckpointFile(db.fs, db.options.DirPath)
+	if err != nil {
+		return nil, err
+	}
+	defer checkpointFile.Close()
+
+	record, offset, err := checkpointFile.ReadLogRecord(0)
+	if err != nil || string(record.Key) != checkpointHeaderKey {
+		return nil, nil
+	}
+	header, err := decodeCheckpointHeader(record.Value)
+	if err != nil {
+		return nil, nil
+	}
+	valid, err := db.checkpointValid(header)
+	if err != nil || !valid {
+		return nil, err
+	}
+
+	var count uint64
+	scanner := checkpointFile.NewScanner(offset)
+	for {
+		if !scanner.Next() {
+			// 检查点文件损坏或者不完整，丢弃已经加载的索引
+			return nil, db.resetIndex()
+		}
+		record := scanner.Record()
+		if len(record.Key) == 0 {
+			if expected, n := binary.Uvarint(record.Value); n <= 0 || expected != count {
+				return nil, db.resetIndex()
+			}
+			break
+		}
+		if _, err := index.PutWithError(db.index, record.Key, structure.DecodeLogRecordPos(record.Value)); err != nil {
+			return nil, err
+		}
+		count++
+	}
+
+	db.seqNo = header.seqNo
+	db.reclaimSize = header.reclaimSize
+	db.checkpointFile = true
+	return &structure.LogRecordPos{Fid: header.fid, Offset: header.offset}, nil
+}
+
+// resetIndex 丢弃内存索引中的数据，重新创建一个空的索引
+func (db *DB) resetIndex() error {
+	// 配置项中指定的索引实例无法重新创建，只能清空
+	if db.options.Indexer != nil {
+		if persistent, ok := db.index.(index.Persistent); ok {
+			return persistent.Reset()
+		}
+		var keys [][]byte
+		iterator := db.index.Iterator(false)
+		for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+			keys = append(keys, iterator.Key())
+		}
+		iterator.Close()
+		for _, key := range keys {
+			db.index.Delete(key)
+		}
+		return nil
+	}
+
+	if err := db.index.Close(); err != nil {
+		return err
+	}
+	indexer, err := db.newIndexer()
+	if err != nil {
+		return err
+	}
+	db.index = indexer
+	return nil
+}
+
+// checkpointValid 检查点对应的数据文件必须存在，且之后没有发生过 merge
+func (db *DB) checkpointValid(header *checkpointHeader) (bool, error) {
+	if db.mergeLoaded {
+		return false, nil
+	}
+
+	var storageFile *structure.StorageFile
+	if db.activeFile != nil && db.activeFile.FileID == header.fid {
+		storageFile = db.activeFile
+	} else {
+		storageFile = db.olderFiles[header.fid]
+	}
+	if storageFile == nil {
+		return false, nil
+	}
+	size, err := storageFile.IoManager.Size()
+	if err != nil {
+		return false, err
+	}
+	if header.offset > size {
+		return false, nil
+	}
+
+	mergeFinFileName := filepath.Join(db.options.DirPath, structure.MergeFinishedfileName)
+	if _, err := db.fs.Stat(mergeFinFileName); err == nil {
+		nonMergeFileID, err := db.getNonMergeFileID(db.options.DirPath)
+		if err != nil {
+			return false, err
+		}
+		return header.fid >= nonMergeFileID, nil
+	}
+	return true, nil
+}
@@ -0,0 +1,214 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tClown11/kv-storage/errs"
+	"github.com/tClown11/kv-storage/index"
+	"github.com/tClown11/kv-storage/structure"
+	"github.com/tClown11/kv-storage/utils"
+)
+
+// 模拟进程崩溃，不写入检查点直接释放资源
+func crashDB(db *DB) {
+	_ = db.activeFile.Close()
+	for _, of := range db.olderFiles {
+		_ = of.Close()
+	}
+	_ = db.index.Close()
+	_ = db.fileLock.Unlock()
+}
+
+func TestDB_CheckpointIndex_Close(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-checkpoint-close")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestValue(20))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// 正常关闭时写入检查点，重启后从检查点加载
+	reclaimSize := db.reclaimSize
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.True(t, db2.checkpointFile)
+	assert.Equal(t, reclaimSize, db2.reclaimSize)
+	assert.Equal(t, 900, len(db2.ListKeys()))
+	_, err = db2.Get(utils.GetTestKey(1))
+	assert.Equal(t, errs.ErrKeyNotFound, err)
+	val, err := db2.Get(utils.GetTestKey(500))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+}
+
+func TestDB_CheckpointIndex_Crash(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-checkpoint-crash")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestValue(20))
+		assert.Nil(t, err)
+	}
+	err = db.CheckpointIndex()
+	assert.Nil(t, err)
+
+	// 检查点之后的修改需要从数据文件中加载
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 1000; i < 1100; i++ {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), utils.GetTestValue(20)))
+	}
+	assert.Nil(t, wb.Commit())
+	seqNo := db.seqNo
+	crashDB(db)
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.True(t, db2.checkpointFile)
+	assert.Equal(t, seqNo, db2.seqNo)
+	assert.Equal(t, 1000, len(db2.ListKeys()))
+	_, err = db2.Get(utils.GetTestKey(1))
+	assert.Equal(t, errs.ErrKeyNotFound, err)
+	val, err := db2.Get(utils.GetTestKey(1050))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+	crashDB(db2)
+
+	// 检查点文件损坏时重新加载全部的数据文件
+	checkpointFileName := filepath.Join(dir, structure.IndexCheckpointFileName)
+	data, err := os.ReadFile(checkpointFileName)
+	assert.Nil(t, err)
+	data[len(data)/2] ^= 0xff
+	assert.Nil(t, os.WriteFile(checkpointFileName, data, 0644))
+
+	db3, err := Open(opts)
+	defer destroyDB(db3)
+	assert.Nil(t, err)
+	assert.False(t, db3.checkpointFile)
+	assert.Equal(t, 1000, len(db3.ListKeys()))
+	_, err = db3.Get(utils.GetTestKey(1))
+	assert.Equal(t, errs.ErrKeyNotFound, err)
+}
+
+// slowPutIndex 更新索引之前等待一段时间，扩大写入数据文件与更新索引之间的间隔
+type slowPutIndex struct {
+	*index.Btree
+}
+
+func (si slowPutIndex) Put(key []byte, pos *structure.LogRecordPos) *structure.LogRecordPos {
+	time.Sleep(time.Millisecond)
+	return si.Btree.Put(key, pos)
+}
+
+// 写入的同时写入检查点，崩溃之后已经返回成功的写入不会丢失
+func TestDB_CheckpointIndex_ConcurrentPut(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-checkpoint-concurrent-put")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.Indexer = slowPutIndex{Btree: index.NewBtree(0, nil)}
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	value := utils.GetTestValue(20)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := g * 50; i < (g+1)*50; i++ {
+				assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+			}
+		}(g)
+	}
+	putDone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(putDone)
+	}()
+	// 写入完成之后不再写入检查点，最后一次检查点与写入同时进行
+	for checkpointing := true; checkpointing; {
+		select {
+		case <-putDone:
+			checkpointing = false
+		default:
+			assert.Nil(t, db.CheckpointIndex())
+		}
+	}
+	crashDB(db)
+
+	opts.Indexer = nil
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.True(t, db2.checkpointFile)
+	assert.Equal(t, 200, len(db2.ListKeys()))
+	for i := 0; i < 200; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+}
+
+func TestDB_CheckpointIndex_Merge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-checkpoint-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestValue(20))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// merge 之后的检查点指向旧的数据文件，重启时 merge 生效，检查点失效
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.False(t, db2.checkpointFile)
+	assert.Equal(t, 500, len(db2.ListKeys()))
+	val, err := db2.Get(utils.GetTestKey(999))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+}
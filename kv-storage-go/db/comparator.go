@@ -0,0 +1,62 @@
+package db
+
+import (
+	"path/filepath"
+
+	"github.com/tClown11/kv-storage/errs"
+	"github.com/tClown11/kv-storage/fio"
+	"github.com/tClown11/kv-storage/index"
+	"github.com/tClown11/kv-storage/structure"
+)
+
+// checkComparator 校验比较器与数据目录中记录的是否一致，第一次打开时记录比较器的名称
+// 没有记录比较器的数据目录认为使用的是默认的字节序
+func checkComparator(fs fio.FileSystem, options Options) error {
+	name := index.OrDefault(options.Comparator).Name()
+
+	fileName := filepath.Join(options.DirPath, structure.ComparatorFileName)
+	if _, err := fs.Stat(fileName); err == nil {
+		file, err := structure.OpenComparatorFile(fs, options.DirPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		record, _, err := file.ReadLogRecord(0)
+		if err != nil {
+			return err
+		}
+		if string(record.Value) != name {
+			return errs.ErrComparatorMismatch
+		}
+		return nil
+	}
+
+	if !index.IsBytewise(options.Comparator) {
+		entries, err := fs.ReadDir(options.DirPath)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.Name() != fileLockName {
+				return errs.ErrComparatorMismatch
+			}
+		}
+	}
+
+	file, err := structure.OpenComparatorFile(fs, options.DirPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	record := &structure.LogRecord{
+		Key:   []byte(comparatorKey),
+		Value: []byte(name),
+	}
+	encRecord, _ := record.EncodeLogRecord()
+	if err := file.Write(encRecord); err != nil {
+		return err
+	}
+	return file.Sync()
+}
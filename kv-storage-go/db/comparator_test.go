@@ -0,0 +1,109 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tClown11/kv-storage/errs"
+	"github.com/tClown11/kv-storage/index"
+	"github.com/tClown11/kv-storage/utils"
+)
+
+// caseInsensitiveComparator 忽略大小写比较，相同时再按照字节序比较
+type caseInsensitiveComparator struct{}
+
+func (caseInsensitiveComparator) Compare(a, b []byte) int {
+	if c := bytes.Compare(bytes.ToLower(a), bytes.ToLower(b)); c != 0 {
+		return c
+	}
+	return bytes.Compare(a, b)
+}
+
+func (caseInsensitiveComparator) Name() string { return "test.CaseInsensitiveComparator" }
+
+func TestDB_Comparator(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "test_comparator")
+	opts.DirPath = dir
+	opts.Comparator = caseInsensitiveComparator{}
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for _, key := range []string{"b", "A", "a", "B", "ab", "Ac", "c"} {
+		err = db.Put([]byte(key), utils.GetTestValue(10))
+		assert.Nil(t, err)
+	}
+
+	var keys []string
+	for _, key := range db.ListKeys() {
+		keys = append(keys, string(key))
+	}
+	assert.Equal(t, []string{"A", "a", "ab", "Ac", "B", "b", "c"}, keys)
+
+	collect := func(opts IteratorOptions) []string {
+		iter := db.NewIterator(opts)
+		defer iter.Close()
+		var keys []string
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		return keys
+	}
+	assert.Equal(t, []string{"ab", "Ac", "B"}, collect(IteratorOptions{LowerBound: []byte("aa"), UpperBound: []byte("b")}))
+	assert.Equal(t, []string{"b", "B", "Ac", "ab"}, collect(IteratorOptions{LowerBound: []byte("aa"), UpperBound: []byte("c"), Reverse: true}))
+	// 前缀仍然按照字节匹配
+	assert.Equal(t, []string{"a", "ab"}, collect(IteratorOptions{Prefix: []byte("a")}))
+	assert.Equal(t, []string{"Ac", "A"}, collect(IteratorOptions{Prefix: []byte("A"), Reverse: true}))
+
+	iter := db.NewIterator(IteratorOptions{})
+	iter.Seek([]byte("aB"))
+	assert.Equal(t, []byte("ab"), iter.Key())
+	iter.Close()
+
+	assert.Equal(t, 3, db.Count(IteratorOptions{LowerBound: []byte("aa"), UpperBound: []byte("b")}))
+	assert.Equal(t, 2, db.Count(IteratorOptions{Prefix: []byte("a")}))
+	assert.Equal(t, 2, db.ApproximateCount(IteratorOptions{Prefix: []byte("a")}))
+
+	// 使用不同的比较器重新打开
+	err = db.Close()
+	assert.Nil(t, err)
+	opts.Comparator = index.BytewiseComparator
+	_, err = Open(opts)
+	assert.Equal(t, errs.ErrComparatorMismatch, err)
+
+	opts.Comparator = caseInsensitiveComparator{}
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 7, len(db.ListKeys()))
+	destroyDB(db)
+}
+
+func TestDB_Comparator_ExistingDir(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "test_comparator_existing")
+	opts.DirPath = dir
+	opts.IndexType = index.BPTree
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		err = db.Put([]byte(fmt.Sprintf("key-%03d", i)), utils.GetTestValue(10))
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	opts.Comparator = caseInsensitiveComparator{}
+	_, err = Open(opts)
+	assert.Equal(t, errs.ErrComparatorMismatch, err)
+
+	// 默认比较器可以为空
+	opts.Comparator = nil
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(db.ListKeys()))
+	destroyDB(db)
+}
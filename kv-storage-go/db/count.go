@@ -0,0 +1,150 @@
+package db
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+
+	"github.com/tClown11/kv-storage/index"
+)
+
+const (
+	// 近似计数时采样的 key 数量
+	countSketchSamples = 1024
+
+	// 索引中的 key 数量变化超过 1/10 时重新采样
+	countSketchDriftDen = 10
+)
+
+// countSketch 按照固定间隔从索引中采样的有序 key，用于估算范围内的 key 数量
+type countSketch struct {
+	mu     sync.Mutex
+	keys   [][]byte // 采样得到的 key，有序
+	stride int      // 每隔多少个 key 采样一次
+	size   int      // 采样时索引中的 key 数量
+}
+
+// Count 统计指定范围内的 key 数量，范围与迭代器的配置项相同
+// 索引支持范围计数时直接由索引统计，否则遍历范围内的所有 key
+func (db *DB) Count(opts IteratorOptions) int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.filterPrefix(opts) {
+		return db.countLinear(opts)
+	}
+	lower, upper := db.iteratorBounds(opts)
+	if counter, ok := db.index.(index.RangeCounter); ok {
+		return counter.CountRange(lower, upper)
+	}
+	if lower == nil && upper == nil {
+		return db.index.Size()
+	}
+	return db.countLinear(opts)
+}
+
+// ApproximateCount 估算指定范围内的 key 数量，开销很小但结果不精确，适合用于监控展示
+func (db *DB) ApproximateCount(opts IteratorOptions) int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var prefix []byte
+	if db.filterPrefix(opts) {
+		prefix = opts.Prefix
+	}
+	lower, upper := db.iteratorBounds(opts)
+	if counter, ok := db.index.(index.RangeCounter); ok && prefix == nil {
+		return counter.CountRange(lower, upper)
+	}
+	size := db.index.Size()
+	if lower == nil && upper == nil && prefix == nil {
+		return size
+	}
+
+	sketch := db.countSketch
+	sketch.mu.Lock()
+	defer sketch.mu.Unlock()
+	if sketch.keys == nil || abs(size-sketch.size)*countSketchDriftDen > sketch.size {
+		sketch.rebuild(db.index, size)
+	}
+	return sketch.estimate(index.OrDefault(db.options.Comparator), lower, upper, prefix, size)
+}
+
+// countLinear 遍历范围内的所有 key 进行计数
+func (db *DB) countLinear(opts IteratorOptions) int {
+	opts.Reverse = false
+	iter := db.indexIterator(opts)
+	defer iter.Close()
+
+	var count int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		count++
+	}
+	return count
+}
+
+// rebuild 重新从索引中采样
+func (cs *countSketch) rebuild(indexer index.Indexer, size int) {
+	stride := size / countSketchSamples
+	if stride < 1 {
+		stride = 1
+	}
+	keys := make([][]byte, 0, size/stride+1)
+
+	iter := indexer.Iterator(false)
+	defer iter.Close()
+	var i int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		if i%stride == 0 {
+			keys = append(keys, append([]byte(nil), iter.Key()...))
+		}
+		i++
+	}
+
+	cs.keys = keys
+	cs.stride = stride
+	cs.size = i
+}
+
+// estimate 根据采样的 key 估算 [lower, upper) 范围内包含 prefix 的 key 数量，并按照当前的 key 数量进行缩放
+func (cs *countSketch) estimate(cmp index.Comparator, lower, upper, prefix []byte, size int) int {
+	lo, hi := 0, len(cs.keys)
+	if lower != nil {
+		lo = sort.Search(len(cs.keys), func(i int) bool {
+			return cmp.Compare(cs.keys[i], lower) >= 0
+		})
+	}
+	if upper != nil {
+		hi = sort.Search(len(cs.keys), func(i int) bool {
+			return cmp.Compare(cs.keys[i], upper) >= 0
+		})
+	}
+	if hi <= lo || cs.size == 0 {
+		return 0
+	}
+
+	samples := hi - lo
+	if prefix != nil {
+		samples = 0
+		for _, key := range cs.keys[lo:hi] {
+			if bytes.HasPrefix(key, prefix) {
+				samples++
+			}
+		}
+	}
+	count := samples * cs.stride
+	if size != cs.size {
+		count = int(int64(count) * int64(size) / int64(cs.size))
+	}
+	if count > size {
+		count = size
+	}
+	return count
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,120 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tClown11/kv-storage/index"
+	"github.com/tClown11/kv-storage/utils"
+)
+
+func TestDB_Count(t *testing.T) {
+	for _, indexType := range []index.IndexType{index.BTree, index.ART} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "test_count")
+		opts.DirPath = dir
+		opts.IndexType = indexType
+		db, err := Open(opts)
+		assert.Nil(t, err)
+		assert.NotNil(t, db)
+
+		assert.Equal(t, 0, db.Count(IteratorOptions{}))
+		for _, key := range []string{"a", "b", "ba", "bb", "b\xff", "c", "d"} {
+			err = db.Put([]byte(key), utils.GetTestValue(10))
+			assert.Nil(t, err)
+		}
+		err = db.Delete([]byte("d"))
+		assert.Nil(t, err)
+
+		assert.Equal(t, 6, db.Count(IteratorOptions{}))
+		assert.Equal(t, 4, db.Count(IteratorOptions{Prefix: []byte("b")}))
+		assert.Equal(t, 3, db.Count(IteratorOptions{LowerBound: []byte("ba"), UpperBound: []byte("c")}))
+		assert.Equal(t, 2, db.Count(IteratorOptions{Prefix: []byte("b"), LowerBound: []byte("bb")}))
+		assert.Equal(t, 1, db.Count(IteratorOptions{UpperBound: []byte("b"), Reverse: true}))
+		assert.Equal(t, 0, db.Count(IteratorOptions{Prefix: []byte("e")}))
+		assert.Equal(t, 0, db.Count(IteratorOptions{LowerBound: []byte("c"), UpperBound: []byte("a")}))
+
+		destroyDB(db)
+	}
+}
+
+func TestDB_ApproximateCount(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "test_approximate_count")
+	opts.DirPath = dir
+	// 跳表索引不支持范围计数，通过采样估算
+	opts.IndexType = index.SkipList
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	assert.Equal(t, 0, db.ApproximateCount(IteratorOptions{Prefix: []byte("key-1")}))
+
+	for i := 0; i < 20000; i++ {
+		err = db.Put([]byte(fmt.Sprintf("key-%05d", i)), utils.GetTestValue(10))
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 20000, db.ApproximateCount(IteratorOptions{}))
+	assert.InDelta(t, 10000, db.ApproximateCount(IteratorOptions{Prefix: []byte("key-1")}), 100)
+	assert.InDelta(t, 5000, db.ApproximateCount(IteratorOptions{LowerBound: []byte("key-05"), UpperBound: []byte("key-10")}), 100)
+	assert.Equal(t, 0, db.ApproximateCount(IteratorOptions{Prefix: []byte("other")}))
+
+	// key 数量变化较大时重新采样
+	for i := 0; i < 10000; i++ {
+		err = db.Delete([]byte(fmt.Sprintf("key-%05d", i)))
+		assert.Nil(t, err)
+	}
+	assert.InDelta(t, 0, db.ApproximateCount(IteratorOptions{Prefix: []byte("key-0")}), 100)
+	assert.InDelta(t, 10000, db.ApproximateCount(IteratorOptions{Prefix: []byte("key-1")}), 100)
+
+	// 索引支持范围计数时返回精确值
+	for _, indexType := range []index.IndexType{index.BTree, index.ART} {
+		opts.DirPath, _ = os.MkdirTemp("", "test_approximate_count_exact")
+		opts.IndexType = indexType
+		exactDB, err := Open(opts)
+		assert.Nil(t, err)
+		for i := 0; i < 100; i++ {
+			err = exactDB.Put([]byte(fmt.Sprintf("key-%03d", i)), utils.GetTestValue(10))
+			assert.Nil(t, err)
+		}
+		assert.Equal(t, 10, exactDB.ApproximateCount(IteratorOptions{Prefix: []byte("key-01")}))
+		destroyDB(exactDB)
+	}
+}
+
+// iteratorCountingIndex 记录创建迭代器的次数
+type iteratorCountingIndex struct {
+	*index.Btree
+	iterators int
+}
+
+func (ci *iteratorCountingIndex) Iterator(reverse bool) index.Iterator {
+	ci.iterators++
+	return ci.Btree.Iterator(reverse)
+}
+
+// 默认的 BTree 索引直接统计范围内的 key 数量，不会遍历索引
+func TestDB_Count_RangeCounter(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "test_count_range_counter")
+	opts.DirPath = dir
+	counting := &iteratorCountingIndex{Btree: index.NewBtree(0, nil)}
+	opts.Indexer = counting
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err = db.Put([]byte(fmt.Sprintf("key-%03d", i)), utils.GetTestValue(10))
+		assert.Nil(t, err)
+	}
+	iterators := counting.iterators
+	assert.Equal(t, 100, db.Count(IteratorOptions{LowerBound: []byte("key-100"), UpperBound: []byte("key-200")}))
+	assert.Equal(t, 100, db.Count(IteratorOptions{Prefix: []byte("key-1")}))
+	assert.Equal(t, 100, db.ApproximateCount(IteratorOptions{UpperBound: []byte("key-100"), Reverse: true}))
+	assert.Equal(t, iterators, counting.iterators)
+}
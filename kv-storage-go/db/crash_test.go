@@ -0,0 +1,269 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tClown11/kv-storage/errs"
+	"github.com/tClown11/kv-storage/fio"
+	"github.com/tClown11/kv-storage/structure"
+	"github.com/tClown11/kv-storage/utils"
+)
+
+// crashOptions 使用故障注入文件系统的配置项，数据分布在多个文件中
+func crashOptions(ffs *fio.FaultFileSystem) Options {
+	opts := DefaultOptions
+	opts.DirPath = "/bitcask-go-crash"
+	opts.DataFileSize = 8 * 1024
+	opts.FileSystem = ffs
+	return opts
+}
+
+// putKeys 写入 [from, to) 范围的 key，返回写入成功的数据
+func putKeys(t *testing.T, db *DB, from, to int) map[string][]byte {
+	acked := make(map[string][]byte)
+	for i := from; i < to; i++ {
+		key, value := utils.GetTestKey(i), utils.GetTestValue(64)
+		if err := db.Put(key, value); err == nil {
+			acked[string(key)] = value
+		}
+	}
+	return acked
+}
+
+// assertKeys 校验数据库中的数据与写入成功的数据一致
+func assertKeys(t *testing.T, db *DB, acked map[string][]byte) {
+	for key, value := range acked {
+		val, err := db.Get([]byte(key))
+		assert.Nil(t, err, key)
+		assert.Equal(t, value, val, key)
+	}
+}
+
+func TestCrash_PowerLoss(t *testing.T) {
+	modes := map[string]fio.CrashMode{
+		"DropUnsynced": fio.CrashDropUnsynced,
+		"KeepPartial":  fio.CrashKeepPartial,
+		"TornUnsynced": fio.CrashTornUnsynced,
+	}
+	for name, mode := range modes {
+		t.Run(name, func(t *testing.T) {
+			ffs := fio.NewFaultFileSystem()
+			opts := crashOptions(ffs)
+			db, err := Open(opts)
+			assert.Nil(t, err)
+
+			// 正常关闭过一次，之后从索引检查点开始加载
+			acked := putKeys(t, db, 0, 100)
+			assert.Nil(t, db.Close())
+			db, err = Open(opts)
+			assert.Nil(t, err)
+
+			for k, v := range putKeys(t, db, 100, 200) {
+				acked[k] = v
+			}
+			assert.Nil(t, db.Sync())
+			unsynced := putKeys(t, db, 200, 300)
+			ffs.PowerLoss(mode)
+
+			db, err = Open(opts)
+			assert.Nil(t, err)
+			assertKeys(t, db, acked)
+			// 没有持久化的数据要么完整保留，要么从某个位置开始全部丢失
+			var lost bool
+			for i := 200; i < 300; i++ {
+				val, err := db.Get(utils.GetTestKey(i))
+				if err == errs.ErrKeyNotFound {
+					lost = true
+					continue
+				}
+				assert.Nil(t, err)
+				assert.False(t, lost, "key %d survived after a lost key", i)
+				assert.Equal(t, unsynced[string(utils.GetTestKey(i))], val)
+				acked[string(utils.GetTestKey(i))] = val
+			}
+
+			// 末尾没有完整写入的数据已经被截断，之后的写入可以正常加载
+			for k, v := range putKeys(t, db, 300, 400) {
+				acked[k] = v
+			}
+			assert.Nil(t, db.Close())
+			db, err = Open(opts)
+			assert.Nil(t, err)
+			defer destroyDB(db)
+			assertKeys(t, db, acked)
+		})
+	}
+}
+
+func TestCrash_WriteFault(t *testing.T) {
+	faults := map[string]fio.FaultType{
+		"ShortWrite": fio.FaultShortWrite,
+		"WriteError": fio.FaultWriteError,
+	}
+	for name, faultType := range faults {
+		t.Run(name, func(t *testing.T) {
+			ffs := fio.NewFaultFileSystem()
+			opts := crashOptions(ffs)
+			opts.SyncWrites = true
+			db, err := Open(opts)
+			assert.Nil(t, err)
+
+			ffs.Inject(fio.Fault{Type: faultType, Pattern: "*" + structure.StorageFileNameSuffix, After: 50})
+			acked := putKeys(t, db, 0, 100)
+			assert.Equal(t, 99, len(acked))
+			_, err = db.Get(utils.GetTestKey(50))
+			assert.Equal(t, errs.ErrKeyNotFound, err)
+			// 写入失败之后的数据位置仍然正确
+			assertKeys(t, db, acked)
+
+			ffs.PowerLoss(fio.CrashDropUnsynced)
+			db, err = Open(opts)
+			assert.Nil(t, err)
+			defer destroyDB(db)
+			assertKeys(t, db, acked)
+			_, err = db.Get(utils.GetTestKey(50))
+			assert.Equal(t, errs.ErrKeyNotFound, err)
+		})
+	}
+}
+
+func TestCrash_SyncError(t *testing.T) {
+	ffs := fio.NewFaultFileSystem()
+	opts := crashOptions(ffs)
+	opts.SyncWrites = true
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	ffs.Inject(fio.Fault{Type: fio.FaultSyncError, Pattern: "*" + structure.StorageFileNameSuffix, After: 50})
+	acked := putKeys(t, db, 0, 100)
+	assert.Equal(t, 99, len(acked))
+
+	ffs.PowerLoss(fio.CrashDropUnsynced)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	assertKeys(t, db, acked)
+}
+
+func TestCrash_BeforeIndexUpdate(t *testing.T) {
+	ffs := fio.NewFaultFileSystem()
+	opts := crashOptions(ffs)
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	acked := putKeys(t, db, 0, 100)
+
+	// 数据已经写入文件，还没有更新索引时崩溃
+	db.mu.Lock()
+	_, err = db.appendLogRecord(&structure.LogRecord{
+		Key:   structure.EncodeKeyWithSeq([]byte("crash-key"), nonTransactionSeqNo),
+		Value: []byte("crash-value"),
+		Type:  structure.LogRecordNormal,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, db.activeFile.Sync())
+	db.mu.Unlock()
+	ffs.PowerLoss(fio.CrashDropUnsynced)
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	assertKeys(t, db, acked)
+	val, err := db.Get([]byte("crash-key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("crash-value"), val)
+}
+
+func TestCrash_WriteBatch(t *testing.T) {
+	modes := map[string]fio.CrashMode{
+		"DropUnsynced": fio.CrashDropUnsynced,
+		"KeepPartial":  fio.CrashKeepPartial,
+		"TornUnsynced": fio.CrashTornUnsynced,
+	}
+	for name, mode := range modes {
+		t.Run(name, func(t *testing.T) {
+			ffs := fio.NewFaultFileSystem()
+			opts := crashOptions(ffs)
+			opts.DataFileSize = 64 * 1024 * 1024
+			db, err := Open(opts)
+			assert.Nil(t, err)
+			acked := putKeys(t, db, 0, 100)
+			assert.Nil(t, db.Sync())
+
+			// 事务提交时没有持久化，掉电之后事务中的数据要么全部可见，要么全部不可见
+			wb := db.NewWriteBatch(WriteBatchOptions{MaxBatchNum: 1000, SyncWrites: false})
+			for i := 100; i < 200; i++ {
+				assert.Nil(t, wb.Put(utils.GetTestKey(i), utils.GetTestValue(64)))
+			}
+			assert.Nil(t, wb.Delete(utils.GetTestKey(0)))
+			assert.Nil(t, wb.Commit())
+			ffs.PowerLoss(mode)
+
+			db, err = Open(opts)
+			assert.Nil(t, err)
+			defer destroyDB(db)
+			assertKeys(t, db, acked)
+			for i := 100; i < 200; i++ {
+				_, err := db.Get(utils.GetTestKey(i))
+				assert.Equal(t, errs.ErrKeyNotFound, err)
+			}
+		})
+	}
+}
+
+func TestCrash_LoadMergeFiles(t *testing.T) {
+	// 准备一个已经完成 merge、还没有加载 merge 结果的数据目录
+	prepare := func(t *testing.T) (*fio.FaultFileSystem, Options, map[string][]byte) {
+		ffs := fio.NewFaultFileSystem()
+		opts := crashOptions(ffs)
+		opts.DataFileMergeRatio = 0
+		db, err := Open(opts)
+		assert.Nil(t, err)
+
+		acked := putKeys(t, db, 0, 1000)
+		for i := 0; i < 1000; i += 2 {
+			assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+			delete(acked, string(utils.GetTestKey(i)))
+		}
+		assert.Nil(t, db.Merge())
+		assert.Nil(t, db.Close())
+		return ffs, opts, acked
+	}
+
+	ffs, opts, _ := prepare(t)
+	entries, err := ffs.ReadDir(opts.DirPath + mergeDirName)
+	assert.Nil(t, err)
+	// 文件锁、比较器文件以及临时实例关闭时写入的序列号、索引检查点文件不会被移动
+	renames := 0
+	for _, entry := range entries {
+		switch entry.Name() {
+		case fileLockName, structure.ComparatorFileName, structure.SeqNoFileName, structure.IndexCheckpointFileName:
+		default:
+			renames++
+		}
+	}
+	assert.True(t, renames > 3)
+
+	// 第 n 次重命名时崩溃，重启之后 merge 的结果仍然可以加载
+	for n := 0; n < renames; n++ {
+		t.Run(fmt.Sprintf("Rename%d", n), func(t *testing.T) {
+			ffs, opts, acked := prepare(t)
+			ffs.Inject(fio.Fault{Type: fio.FaultRenameError, After: n})
+			_, err := Open(opts)
+			assert.ErrorIs(t, err, fio.ErrInjectedFault)
+			ffs.ClearFaults()
+			ffs.PowerLoss(fio.CrashDropUnsynced)
+
+			db, err := Open(opts)
+			assert.Nil(t, err)
+			defer destroyDB(db)
+			assert.Equal(t, len(acked), len(db.ListKeys()))
+			assertKeys(t, db, acked)
+			for i := 0; i < 1000; i += 2 {
+				_, err := db.Get(utils.GetTestKey(i))
+				assert.Equal(t, errs.ErrKeyNotFound, err)
+			}
+		})
+	}
+}
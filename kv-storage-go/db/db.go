@@ -3,39 +3,70 @@ package db
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"sync"
+	"sync/atomic"
 
-	"github.com/gofrs/flock"
 	"github.com/tClown11/kv-storage/errs"
 	"github.com/tClown11/kv-storage/fio"
 	"github.com/tClown11/kv-storage/index"
 	"github.com/tClown11/kv-storage/structure"
-	"github.com/tClown11/kv-storage/utils"
 )
 
 const (
-	seqNoKey     = "seq.no"
-	fileLockName = "flock"
+	seqNoKey       = "seq.no"
+	reclaimSizeKey = "reclaim.size"
+	comparatorKey  = "comparator"
+	fileLockName   = "flock"
+
+	// backupCopySize 备份时每次读取的数据大小
+	backupCopySize = 64 * 1024
 )
 
 // DB bitcask 存储引擎
 type DB struct {
-	options         Options
-	mu              *sync.RWMutex
-	fileIDs         []int                             // 文件 id ，只用在加载索引的时候
-	activeFile      *structure.StorageFile            // 当前活跃数据文件，可以用于写入
-	olderFiles      map[uint32]*structure.StorageFile // 旧数据文件，只用于读
-	index           index.Indexer                     // 内存索引
-	seqNo           uint64                            // 事务序列号，全局递增
-	isMerging       bool                              // 是否正在 merge
-	seqNoFileExists bool                              // 存储事务序列号的文件是否存在
-	isInitial       bool                              // 是否是第一次初始化此数据目录
-	fileLock        *flock.Flock                      // 文件锁保证多进程之间的互斥
-	bytesWrite      uint                              // 累计写了多少个字节
-	reclaimSize     int64                             // 表示有多少数据是无效的
+	options          Options
+	mu               *sync.RWMutex
+	writeMu          *sync.Mutex                       // 写入锁，在 db.mu 之前获取，保证索引的更新顺序与数据文件中的写入顺序一致
+	fileIDs          []int                             // 文件 id ，只用在加载索引的时候
+	activeFile       *structure.StorageFile            // 当前活跃数据文件，可以用于写入
+	olderFiles       map[uint32]*structure.StorageFile // 旧数据文件，只用于读
+	files            atomic.Pointer[storageFiles]      // 数据文件的快照，读取数据时使用，不需要持有 db.mu
+	fileCache        *fio.FileCache                    // 限制旧数据文件同时打开的数量，为空表示不限制
+	index            index.Indexer                     // 内存索引
+	seqNo            uint64                            // 事务序列号，全局递增
+	isMerging        bool                              // 是否正在 merge
+	seqNoFileExists  bool                              // 存储事务序列号的文件是否存在
+	isInitial        bool                              // 是否是第一次初始化此数据目录
+	privateMemFS     bool                              // 是否使用打开时新建的内存文件系统，关闭之后无法再次打开，merge 的结果在当前实例中加载
+	mergeSeq         atomic.Uint64                     // 在当前实例中加载 merge 结果的序号，为奇数时表示正在加载
+	fs               fio.FileSystem                    // 数据目录所在的文件系统
+	fileLock         fio.FileLock                      // 文件锁保证多进程之间的互斥
+	bytesWrite       uint                              // 累计写了多少个字节
+	reclaimSize      int64                             // 表示有多少数据是无效的，写入时在锁外更新，需要原子操作
+	mergeLoaded      bool                              // 本次启动是否加载了 merge 完成的数据文件
+	fromCheckpoint   bool                              // 索引是否从持久化索引的检查点开始加载
+	checkpointFile   bool                              // 索引是否从索引检查点文件开始加载
+	countSketch      *countSketch                      // 近似计数使用的 key 采样
+	secondaryIndexes map[string]*secondaryIndex        // 二级索引
+
+	// IO 限速，限速为 0 时不限速，可以在运行时修改
+	writeLimiter       *fio.RateLimiter // 前台写入，在获取写入锁之前等待，不会阻塞读取
+	mergeReadLimiter   *fio.RateLimiter // merge 读取数据文件
+	mergeWriteLimiter  *fio.RateLimiter // merge 写入新的数据文件和 hint 文件
+	backupReadLimiter  *fio.RateLimiter // 备份读取数据目录
+	backupWriteLimiter *fio.RateLimiter // 备份写入备份目录
+	fileWriteLimiter   *fio.RateLimiter // 写入数据文件的限速，只用于 merge 使用的临时实例
+}
+
+// storageFiles 活跃文件和旧数据文件的快照，创建之后不再修改，文件变化时整体替换
+type storageFiles struct {
+	active *structure.StorageFile
+	older  map[uint32]*structure.StorageFile
 }
 
 // Stat 存储引擎统计信息
@@ -44,40 +75,83 @@ type Stat struct {
 	DataFileNum     uint  // 数据文件的数量
 	ReclaimableSize int64 // 可以进行 merge 回收的数据量，字节为单位
 	DiskSize        int64 // 数据目录所占磁盘空间大小
+	IndexSize       int64 // 索引所占内存大小的估算值，索引不支持统计时为 0
+}
+
+func newDB(options Options, fs fio.FileSystem) (*DB, error) {
+	db := &DB{
+		options:          options,
+		fs:               fs,
+		mu:               new(sync.RWMutex),
+		writeMu:          new(sync.Mutex),
+		olderFiles:       make(map[uint32]*structure.StorageFile),
+		countSketch:      new(countSketch),
+		secondaryIndexes: make(map[string]*secondaryIndex),
+
+		writeLimiter:       fio.NewRateLimiter(options.WriteBytesPerSec),
+		mergeReadLimiter:   fio.NewRateLimiter(options.MergeReadBytesPerSec),
+		mergeWriteLimiter:  fio.NewRateLimiter(options.MergeWriteBytesPerSec),
+		backupReadLimiter:  fio.NewRateLimiter(options.BackupReadBytesPerSec),
+		backupWriteLimiter: fio.NewRateLimiter(options.BackupWriteBytesPerSec),
+	}
+	db.files.Store(&storageFiles{})
+	if options.MaxOpenFiles > 0 {
+		db.fileCache = fio.NewFileCache(options.MaxOpenFiles)
+	}
+	indexer, err := db.newIndexer()
+	if err != nil {
+		return nil, err
+	}
+	db.index = indexer
+	return db, nil
 }
 
-func newDB(options Options) *DB {
-	return &DB{
-		options:    options,
-		mu:         new(sync.RWMutex),
-		olderFiles: make(map[uint32]*structure.StorageFile),
-		index: index.NewIndexer(&index.IndexOpts{
-			Type:    options.IndexType,
-			DirPath: options.DirPath,
-			Sync:    options.SyncWrites,
-		}),
+// newIndexer 根据配置项创建索引，配置项中指定了索引实例时直接使用
+func (db *DB) newIndexer() (index.Indexer, error) {
+	if db.options.Indexer != nil {
+		return db.options.Indexer, nil
 	}
+	return index.NewIndexer(&index.IndexOpts{
+		Type:        db.options.IndexType,
+		DirPath:     db.options.DirPath,
+		Sync:        db.options.SyncWrites,
+		Shards:      db.options.IndexShards,
+		Comparator:  db.options.Comparator,
+		RecordKey:   db.recordKey,
+		ScanRecords: db.scanRecords,
+	})
 }
 
 // Open 打开 bitcask 存储引擎实例
 func Open(options Options) (*DB, error) {
+	privateMemFS := options.FileSystem == nil && options.InMemory
+	if options.FileSystem == nil {
+		options.FileSystem = fio.OS
+		if options.InMemory {
+			options.FileSystem = fio.NewMemFileSystem()
+		}
+	}
+
 	// 对用户传入的配置项进行校验
 	if err := checkOptions(options); err != nil {
 		return nil, err
 	}
+	fs := options.FileSystem
+	if options.KeyProvider != nil {
+		fs = fio.NewEncryptedFileSystem(fs, options.KeyProvider)
+	}
 
 	var isInitial bool
 	// 判断数据目录是否存在，如果不存在的话，则创建这个目录
-	if _, err := os.Stat(options.DirPath); os.IsNotExist(err) {
+	if _, err := fs.Stat(options.DirPath); os.IsNotExist(err) {
 		isInitial = true
-		if err := os.MkdirAll(options.DirPath, os.ModePerm); err != nil {
+		if err := fs.MkdirAll(options.DirPath); err != nil {
 			return nil, err
 		}
 	}
 
 	// 判断当前数据目录是否正在使用
-	fileLock := flock.New(filepath.Join(options.DirPath, fileLockName))
-	hold, err := fileLock.TryLock()
+	fileLock, hold, err := fs.TryLock(filepath.Join(options.DirPath, fileLockName))
 	if err != nil {
 		return nil, err
 	}
@@ -85,7 +159,7 @@ func Open(options Options) (*DB, error) {
 		return nil, errs.ErrDatabaseIsUsing
 	}
 
-	entries, err := os.ReadDir(options.DirPath)
+	entries, err := fs.ReadDir(options.DirPath)
 	if err != nil {
 		return nil, err
 	}
@@ -93,9 +167,20 @@ func Open(options Options) (*DB, error) {
 		isInitial = true
 	}
 
+	// 校验并记录 key 的比较器
+	if err := checkComparator(fs, options); err != nil {
+		_ = fileLock.Unlock()
+		return nil, err
+	}
+
 	// 初始化 DB 实例结构体
-	db := newDB(options)
+	db, err := newDB(options, fs)
+	if err != nil {
+		_ = fileLock.Unlock()
+		return nil, err
+	}
 	db.isInitial = isInitial
+	db.privateMemFS = privateMemFS
 	db.fileLock = fileLock
 
 	// 加载 merge 数据目录
@@ -110,13 +195,28 @@ func Open(options Options) (*DB, error) {
 
 	// 索引加载
 
-	// 从 hint 索引文件中加载索引
-	if err := db.loadIndexFromHintFile(); err != nil {
+	// 持久化索引中已经包含了检查点之前的数据，只需要从检查点开始加载
+	checkpoint, err := db.loadIndexCheckpoint()
+	if err != nil {
 		return nil, err
 	}
 
+	// 从索引检查点文件中加载索引
+	if checkpoint == nil {
+		if checkpoint, err = db.loadIndexFromCheckpointFile(); err != nil {
+			return nil, err
+		}
+	}
+
+	// 从 hint 索引文件中加载索引
+	if checkpoint == nil {
+		if err := db.loadIndexFromHintFile(); err != nil {
+			return nil, err
+		}
+	}
+
 	// 从数据文件中加载索引
-	if err := db.loadIndexFromStorageFiles(); err != nil {
+	if err := db.loadIndexFromStorageFiles(checkpoint); err != nil {
 		return nil, err
 	}
 
@@ -124,28 +224,31 @@ func Open(options Options) (*DB, error) {
 	if err := db.loadSeqNo(); err != nil {
 		return nil, err
 	}
-	if db.activeFile != nil {
-		size, err := db.activeFile.IoManager.Size()
-		if err != nil {
+
+	// 重置 IO 类型为配置项中的类型
+	if db.options.MMapAtStartup {
+		if err := db.resetIoType(); err != nil {
 			return nil, err
 		}
-		db.activeFile.WriteOff = size
 	}
 
-	// // 重置 IO 类型为标准文件 IO
-	// if db.options.MMapAtStartup {
-	// 	if err := db.resetIoType(); err != nil {
-	// 		return nil, err
-	// 	}
-	// }
+	// 丢弃活跃文件末尾崩溃时没有完整写入的数据以及预分配的空间，之后重新预分配
+	if db.activeFile != nil {
+		if err := db.truncateActiveFile(); err != nil {
+			return nil, err
+		}
+		if err := db.preallocateActiveFile(); err != nil {
+			return nil, err
+		}
+	}
 
 	return db, nil
 }
 
 func (db *DB) Put(key []byte, value []byte) error {
 	// 判断 key 是否有效
-	if len(key) == 0 {
-		return errs.ErrKeyIsEmpty
+	if err := db.checkKey(key); err != nil {
+		return err
 	}
 
 	log_record := &structure.LogRecord{
@@ -154,15 +257,27 @@ func (db *DB) Put(key []byte, value []byte) error {
 		Type:  structure.LogRecordNormal,
 	}
 
+	db.writeLimiter.Wait(len(log_record.Key) + len(log_record.Value))
+
+	// 写入锁覆盖追加写入和索引的更新，更新索引时已经释放 db.mu，不会阻塞读取
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
 	// 追加写入到当前活跃的数据文件中
-	pos, err := db.appendLogRecordLock(log_record)
+	pos, err := db.appendLogRecordLock(log_record, func() {
+		db.putSecondary(key, value)
+	})
 	if err != nil {
 		return err
 	}
 
 	// 更新内存索引
-	if oldPos := db.index.Put(key, pos); oldPos != nil {
-		db.reclaimSize += int64(oldPos.Size)
+	oldPos, err := index.PutWithError(db.index, key, pos)
+	if err != nil {
+		return err
+	}
+	if oldPos != nil {
+		atomic.AddInt64(&db.reclaimSize, int64(oldPos.Size))
 	}
 	return nil
 }
@@ -174,8 +289,14 @@ func (db *DB) Delete(key []byte) error {
 		return errs.ErrKeyIsEmpty
 	}
 
+	db.writeLimiter.Wait(len(key))
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
 	// 检查 key 是否存在，如果不存在则返回
-	if pos := db.index.Get(key); pos == nil {
+	if pos, err := index.GetWithError(db.index, key); err != nil {
+		return err
+	} else if pos == nil {
 		return errs.ErrKeyNotFound
 	}
 
@@ -185,34 +306,51 @@ func (db *DB) Delete(key []byte) error {
 		Type: structure.LogRecordDeleted,
 	}
 	// 写入到数据文件中
-	_, err := db.appendLogRecordLock(logRecord)
+	_, err := db.appendLogRecordLock(logRecord, func() {
+		db.deleteSecondary(key)
+	})
 	if err != nil {
 		return err
 	}
 
 	// 从内存索引中删除对应的 key
-	oldPos, ok := db.index.Delete(key)
+	oldPos, ok, err := index.DeleteWithError(db.index, key)
+	if err != nil {
+		return err
+	}
 	if !ok {
 		return errs.ErrIndexUpdateFailed
 	}
 	if oldPos != nil {
-		db.reclaimSize += int64(oldPos.Size)
+		atomic.AddInt64(&db.reclaimSize, int64(oldPos.Size))
 	}
 	return nil
 }
 
 // Get 根据 key 读取数据
+// 索引支持并发读写，数据文件从快照中获取，读取时不需要持有 db.mu，不会被写入阻塞
 func (db *DB) Get(key []byte) ([]byte, error) {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
-
 	// 判断 key 的有效性
 	if len(key) == 0 {
 		return nil, errs.ErrKeyIsEmpty
 	}
 
+	for {
+		seq := db.waitMergeApplied()
+		value, err := db.get(key)
+		// 读取期间在当前实例中加载了 merge 的结果，位置信息和数据文件可能不一致，需要重新读取
+		if db.mergeSeq.Load() == seq {
+			return value, err
+		}
+	}
+}
+
+func (db *DB) get(key []byte) ([]byte, error) {
 	// 从内存数据结构中取出 key 对应的索引信息
-	logRecordPos := db.index.Get(key)
+	logRecordPos, err := index.GetWithError(db.index, key)
+	if err != nil {
+		return nil, err
+	}
 
 	// 如果 key 不在内存索引中，说明 key 不存在
 	if logRecordPos == nil {
@@ -221,6 +359,19 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 	return db.getValueByPosition(logRecordPos)
 }
 
+// waitMergeApplied 等待正在当前实例中加载的 merge 结果加载完成，返回加载的序号
+func (db *DB) waitMergeApplied() uint64 {
+	for {
+		seq := db.mergeSeq.Load()
+		if seq%2 == 0 {
+			return seq
+		}
+		// 加载期间一直持有 db.mu
+		db.mu.RLock()
+		db.mu.RUnlock()
+	}
+}
+
 // Stat 返回数据库的相关统计信息
 func (db *DB) Stat() *Stat {
 	db.mu.RLock()
@@ -231,23 +382,121 @@ func (db *DB) Stat() *Stat {
 		dataFiles += 1
 	}
 
-	dirSize, err := utils.DirSize(db.options.DirPath)
+	dirSize, err := fio.DirSize(db.fs, db.options.DirPath)
 	if err != nil {
 		panic(fmt.Sprintf("failed to get dir size : %v", err))
 	}
+	var indexSize int64
+	if sizer, ok := db.index.(index.MemSizer); ok {
+		indexSize = sizer.MemSize()
+	}
 	return &Stat{
 		KeyNum:          uint(db.index.Size()),
 		DataFileNum:     dataFiles,
-		ReclaimableSize: db.reclaimSize,
+		ReclaimableSize: atomic.LoadInt64(&db.reclaimSize),
 		DiskSize:        dirSize,
+		IndexSize:       indexSize,
 	}
 }
 
 // Backup 备份数据库，将数据文件拷贝到新的目录中
+// 在锁内记录每个文件需要拷贝的大小，之后在锁外按照备份的限速拷贝，拷贝过程中新写入的数据不包含在备份中
 func (db *DB) Backup(dir string) error {
 	db.mu.RLock()
-	defer db.mu.RUnlock()
-	return utils.CopyDir(db.options.DirPath, dir, []string{fileLockName})
+	// 带写缓冲的 IO 需要先将缓冲区中的数据写入文件
+	if db.activeFile != nil {
+		if err := db.activeFile.Sync(); err != nil {
+			db.mu.RUnlock()
+			return err
+		}
+	}
+	files, err := db.backupFiles()
+	db.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	if err := fio.OS.MkdirAll(dir); err != nil {
+		return err
+	}
+	buf := make([]byte, backupCopySize)
+	for name, size := range files {
+		if err := db.backupFile(name, dir, size, buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backupFiles 获取需要备份的文件以及拷贝的大小
+func (db *DB) backupFiles() (map[string]int64, error) {
+	entries, err := db.options.FileSystem.ReadDir(db.options.DirPath)
+	if err != nil {
+		return nil, err
+	}
+	// B+ 树索引文件和索引检查点文件在运行过程中会被修改，不进行拷贝，打开备份时会重新构建
+	exclude := map[string]bool{
+		fileLockName:                          true,
+		index.BPTreeIndexFileName:             true,
+		structure.IndexCheckpointFileName:     true,
+		structure.IndexCheckpointTempFileName: true,
+	}
+	files := make(map[string]int64)
+	for _, entry := range entries {
+		if entry.IsDir() || exclude[entry.Name()] {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		files[entry.Name()] = info.Size()
+	}
+	// 预分配过的活跃文件只拷贝已经写入的部分，加密的文件大小与写入的位置不同，拷贝整个文件
+	if db.activeFile != nil && db.options.KeyProvider == nil {
+		name := filepath.Base(structure.GetStorageFileName(db.options.DirPath, db.activeFile.FileID))
+		files[name] = min(files[name], db.activeFile.WriteOff)
+	}
+	return files, nil
+}
+
+// backupFile 拷贝文件的前 size 个字节到备份目录中，加密的文件直接拷贝密文
+func (db *DB) backupFile(name, dir string, size int64, buf []byte) error {
+	srcFile, err := db.options.FileSystem.OpenFile(filepath.Join(db.options.DirPath, name), fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	src := fio.NewThrottledIOManager(srcFile, db.backupReadLimiter, nil)
+	defer src.Close()
+
+	dstPath := filepath.Join(dir, name)
+	if err := fio.OS.Remove(dstPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	dstFile, err := fio.OS.OpenFile(dstPath, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	dst := fio.NewThrottledIOManager(dstFile, nil, db.backupWriteLimiter)
+	defer dst.Close()
+
+	for offset := int64(0); offset < size; {
+		n, err := src.Read(buf[:min(int64(len(buf)), size-offset)], offset)
+		if n > 0 {
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return err
+			}
+			offset += int64(n)
+		}
+		// 文件在记录大小之后被截断
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return dst.Sync()
 }
 
 // Close 关闭数据库
@@ -267,12 +516,15 @@ func (db *DB) Close() error {
 	if db.activeFile == nil {
 		return nil
 	}
+	// 持有写入锁，检查点记录的位置之前的数据都已经更新到索引中
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
 	// 事务处理
 
-	seqNoFile, err := structure.OpenSeqNoFile(db.options.DirPath)
+	seqNoFile, err := structure.OpenSeqNoFile(db.fs, db.options.DirPath)
 	if err != nil {
 		return err
 	}
@@ -284,11 +536,36 @@ func (db *DB) Close() error {
 	if err := seqNoFile.Write(encRecord); err != nil {
 		return err
 	}
+	// 从检查点加载索引时不会扫描全部的数据文件，需要保存无效数据量
+	reclaimRecord := &structure.LogRecord{
+		Key:   []byte(reclaimSizeKey),
+		Value: []byte(strconv.FormatInt(atomic.LoadInt64(&db.reclaimSize), 10)),
+	}
+	encRecord, _ = reclaimRecord.EncodeLogRecord()
+	if err := seqNoFile.Write(encRecord); err != nil {
+		return err
+	}
 	if err := seqNoFile.Sync(); err != nil {
 		return err
 	}
 
+	// 持久化索引落盘，并记录已经索引到的数据位置
+	if persistent, ok := db.index.(index.Persistent); ok {
+		if err := persistent.Checkpoint(&structure.LogRecordPos{
+			Fid:    db.activeFile.FileID,
+			Offset: db.activeFile.WriteOff,
+		}); err != nil {
+			return err
+		}
+	} else if err := db.checkpointIndex(); err != nil {
+		// 内存索引写入检查点文件，下次启动时不需要重新扫描全部的数据文件
+		return err
+	}
+
 	//	关闭当前活跃文件
+	if err := db.trimActiveFile(); err != nil {
+		return err
+	}
 	if err := db.activeFile.Close(); err != nil {
 		return err
 	}
@@ -314,13 +591,14 @@ func (db *DB) Sync() error {
 
 // ListKeys 获取数据库中所有的 key
 func (db *DB) ListKeys() [][]byte {
-	iterator := db.index.Iterator(false)
+	iterator, _ := db.stableIndexIterator(func() index.Iterator {
+		return db.index.Iterator(false)
+	})
 	defer iterator.Close()
-	keys := make([][]byte, db.index.Size())
-	var idx int
+	// 创建迭代器之后索引可能被并发修改，数量只作为预分配的大小
+	keys := make([][]byte, 0, db.index.Size())
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-		keys[idx] = iterator.Key()
-		idx++
+		keys = append(keys, iterator.Key())
 	}
 	return keys
 }
@@ -345,10 +623,15 @@ func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
 	return nil
 }
 
-func (db *DB) appendLogRecordLock(logRecord *structure.LogRecord) (*structure.LogRecordPos, error) {
+// appendLogRecordLock 加锁追加写数据，afterAppend 在写入成功之后、释放锁之前调用，用于更新需要与写入顺序一致的二级索引
+func (db *DB) appendLogRecordLock(logRecord *structure.LogRecord, afterAppend func()) (*structure.LogRecordPos, error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	return db.appendLogRecord(logRecord)
+	pos, err := db.appendLogRecord(logRecord)
+	if err == nil && afterAppend != nil {
+		afterAppend()
+	}
+	return pos, err
 }
 
 // appendLogRecord 追加写数据到活跃文件中
@@ -369,10 +652,7 @@ func (db *DB) appendLogRecord(logRecord *structure.LogRecord) (*structure.LogRec
 			return nil, err
 		}
 
-		// 当前活跃文件转换为旧的数据文件
-		db.olderFiles[db.activeFile.FileID] = db.activeFile
-
-		// 打开新的数据文件
+		// 当前活跃文件转换为旧的数据文件，并打开新的数据文件
 		if err := db.setActiveDataFile(); err != nil {
 			return nil, err
 		}
@@ -412,27 +692,231 @@ func (db *DB) appendLogRecord(logRecord *structure.LogRecord) (*structure.LogRec
 // 设置当前活跃文件
 func (db *DB) setActiveDataFile() error {
 	var initialFileID uint32 = 0
+	var closeIO fio.IOManager
 	if db.activeFile != nil {
 		initialFileID = db.activeFile.FileID + 1
+		if err := db.trimActiveFile(); err != nil {
+			return err
+		}
+		// 写满的活跃文件转换为旧的数据文件，读取时可能还在使用快照中的活跃文件，不能直接修改，创建新的实例
+		// 限制了打开文件的数量时由打开文件的缓存重新打开，原来的文件在新的快照发布之后再关闭，
+		// 使用旧快照的读取出错时会使用新的快照重试
+		ioManager := db.activeFile.IoManager
+		if db.fileCache != nil {
+			closeIO = ioManager
+			ioManager = db.cachedIOManager(db.activeFile.FileID, db.options.IOType, nil)
+		}
+		olderFile := structure.NewStorageFile(db.activeFile.FileID, ioManager)
+		olderFile.WriteOff = db.activeFile.WriteOff
+		db.olderFiles[olderFile.FileID] = olderFile
 	}
 
 	// 打开新的数据文件
-	dataFile, err := structure.OpenStorageFile(db.options.DirPath, initialFileID, fio.StandardFIO)
+	dataFile, err := db.openStorageFile(initialFileID, db.options.IOType)
 	if err != nil {
 		return err
 	}
 	db.activeFile = dataFile
+	db.publishFiles()
+	if closeIO != nil {
+		if err := closeIO.Close(); err != nil {
+			return err
+		}
+	}
+	return db.preallocateActiveFile()
+}
+
+// publishFiles 活跃文件或者旧数据文件变化之后发布新的快照，调用方需要持有 db.mu
+func (db *DB) publishFiles() {
+	older := make(map[uint32]*structure.StorageFile, len(db.olderFiles))
+	for fid, file := range db.olderFiles {
+		older[fid] = file
+	}
+	db.files.Store(&storageFiles{active: db.activeFile, older: older})
+}
+
+// openStorageFile 以 ioType 打开数据文件，带写缓冲的 IO 使用配置项中的缓冲区大小和刷盘间隔
+func (db *DB) openStorageFile(fileID uint32, ioType fio.FileIOType) (*structure.StorageFile, error) {
+	ioManager, err := db.newIOManager(fileID, ioType)
+	if err != nil {
+		return nil, err
+	}
+	if db.fileWriteLimiter != nil {
+		ioManager = fio.NewThrottledIOManager(ioManager, nil, db.fileWriteLimiter)
+	}
+	return structure.NewStorageFile(fileID, ioManager), nil
+}
+
+// cachedIOManager 将旧数据文件交给打开文件的缓存管理，被关闭之后以 ioType 重新打开
+// inner 为已经打开的文件，为空时在第一次读取时打开，没有限制打开文件数量时直接返回 inner
+func (db *DB) cachedIOManager(fileID uint32, ioType fio.FileIOType, inner fio.IOManager) fio.IOManager {
+	if db.fileCache == nil {
+		return inner
+	}
+	return db.fileCache.Wrap(inner, func() (fio.IOManager, error) {
+		return db.newIOManager(fileID, ioType)
+	})
+}
+
+func (db *DB) newIOManager(fileID uint32, ioType fio.FileIOType) (fio.IOManager, error) {
+	fileName := structure.GetStorageFileName(db.options.DirPath, fileID)
+	if _, ok := db.options.FileSystem.(fio.OSFileSystem); ok && ioType == fio.BufferedIO {
+		ioManager, err := fio.NewBufferedIOManager(fileName, db.options.WriteBufferSize, db.options.WriteFlushInterval)
+		if err != nil || db.options.KeyProvider == nil {
+			return ioManager, err
+		}
+		encIOManager, err := fio.NewEncryptedIOManager(ioManager, db.options.KeyProvider)
+		if err != nil {
+			_ = ioManager.Close()
+			return nil, err
+		}
+		return encIOManager, nil
+	}
+	return db.fs.OpenFile(fileName, ioType)
+}
+
+// truncateActiveFile 将活跃文件截断到加载索引时解析到的有效数据的末尾，之后的写入从这里开始
+func (db *DB) truncateActiveFile() error {
+	size, err := db.activeFile.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	if size <= db.activeFile.WriteOff {
+		db.activeFile.WriteOff = size
+		return nil
+	}
+	return db.activeFile.Truncate(db.activeFile.WriteOff)
+}
+
+// preallocateActiveFile 为活跃文件预分配 DataFileSize 大小的磁盘空间，IO 类型不支持预分配时忽略
+func (db *DB) preallocateActiveFile() error {
+	if !db.options.PreallocateDataFiles {
+		return nil
+	}
+	if preallocator, ok := db.activeFile.IoManager.(fio.Preallocator); ok {
+		return preallocator.Preallocate(db.options.DataFileSize)
+	}
+	return nil
+}
+
+// trimActiveFile 将预分配过的活跃文件截断到实际写入的大小，文件不再写入之前调用
+func (db *DB) trimActiveFile() error {
+	if !db.options.PreallocateDataFiles {
+		return nil
+	}
+	size, err := db.activeFile.IoManager.Size()
+	if err != nil || size <= db.activeFile.WriteOff {
+		return err
+	}
+	return db.activeFile.Truncate(db.activeFile.WriteOff)
+}
+
+// resetIoType 将数据文件的 IO 类型设置为配置项中的类型
+func (db *DB) resetIoType() error {
+	if db.activeFile == nil {
+		return nil
+	}
+	ioManager, err := db.newIOManager(db.activeFile.FileID, db.options.IOType)
+	if err != nil {
+		return err
+	}
+	if err := db.activeFile.SetIOManager(ioManager); err != nil {
+		return err
+	}
+	for _, dataFile := range db.olderFiles {
+		// 限制了打开文件的数量时，旧数据文件在读取时才重新打开
+		var ioManager fio.IOManager
+		if db.fileCache != nil {
+			ioManager = db.cachedIOManager(dataFile.FileID, db.options.IOType, nil)
+		} else if ioManager, err = db.newIOManager(dataFile.FileID, db.options.IOType); err != nil {
+			return err
+		}
+		if err := dataFile.SetIOManager(ioManager); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 // 根据索引信息获取对应的 value
 func (db *DB) getValueByPosition(logRecordPos *structure.LogRecordPos) ([]byte, error) {
+	logRecord, err := db.readLogRecord(logRecordPos)
+	if err != nil {
+		return nil, err
+	}
+
+	if logRecord.Type == structure.LogRecordDeleted {
+		return nil, errs.ErrKeyNotFound
+	}
+	return logRecord.Value, nil
+}
+
+// recordKey 读取位置信息对应的实际 key，供 KeyHash 索引使用，从数据文件的快照中读取，不需要持有 db.mu
+func (db *DB) recordKey(logRecordPos *structure.LogRecordPos) ([]byte, error) {
+	logRecord, err := db.readLogRecord(logRecordPos)
+	if err != nil {
+		return nil, err
+	}
+	key, _ := structure.ParseKeyAndSeqFromLogRecordKey(logRecord.Key)
+	return key, nil
+}
+
+// scanRecords 按照文件 id 的顺序扫描数据文件中所有数据的 key 和位置信息，供 KeyHash 索引遍历使用
+// 每个文件扫描之前重新获取快照，活跃文件转换为旧的数据文件之后使用新的实例
+func (db *DB) scanRecords(fn func(key []byte, pos *structure.LogRecordPos) bool) error {
+	files := db.files.Load()
+	fileIDs := make([]uint32, 0, len(files.older)+1)
+	for fid := range files.older {
+		fileIDs = append(fileIDs, fid)
+	}
+	if files.active != nil {
+		fileIDs = append(fileIDs, files.active.FileID)
+	}
+	sort.Slice(fileIDs, func(i, j int) bool { return fileIDs[i] < fileIDs[j] })
+
+	for _, fid := range fileIDs {
+		files = db.files.Load()
+		storageFile := files.older[fid]
+		if files.active != nil && files.active.FileID == fid {
+			storageFile = files.active
+		}
+		if storageFile == nil {
+			return errs.ErrDataFileNotFound
+		}
+		scanner := storageFile.NewScanner(0)
+		for scanner.Next() {
+			key, _ := structure.ParseKeyAndSeqFromLogRecordKey(scanner.Record().Key)
+			pos := &structure.LogRecordPos{Fid: fid, Offset: scanner.Offset(), Size: uint32(scanner.Size())}
+			if !fn(key, pos) {
+				return nil
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readLogRecord 根据位置信息读取数据
+// 读取过程中活跃文件可能转换为旧的数据文件并被打开文件的缓存关闭，快照已经变化时使用新的快照重试
+func (db *DB) readLogRecord(logRecordPos *structure.LogRecordPos) (*structure.LogRecord, error) {
+	for {
+		files := db.files.Load()
+		logRecord, err := files.readLogRecord(logRecordPos)
+		if err == nil || db.files.Load() == files {
+			return logRecord, err
+		}
+	}
+}
+
+func (files *storageFiles) readLogRecord(logRecordPos *structure.LogRecordPos) (*structure.LogRecord, error) {
 	// 根据文件 id 找到对应的数据文件
 	var storageFile *structure.StorageFile
-	if db.activeFile.FileID == logRecordPos.Fid {
-		storageFile = db.activeFile
+	if files.active != nil && files.active.FileID == logRecordPos.Fid {
+		storageFile = files.active
 	} else {
-		storageFile = db.olderFiles[logRecordPos.Fid]
+		storageFile = files.older[logRecordPos.Fid]
 	}
 
 	// 数据文件不存在
@@ -440,16 +924,20 @@ func (db *DB) getValueByPosition(logRecordPos *structure.LogRecordPos) ([]byte,
 		return nil, errs.ErrDataFileNotFound
 	}
 
-	// 根据便宜读取对应的数据
+	// 根据偏移读取对应的数据
 	logRecord, _, err := storageFile.ReadLogRecord(logRecordPos.Offset)
-	if err != nil {
-		return nil, err
-	}
+	return logRecord, err
+}
 
-	if logRecord.Type == structure.LogRecordDeleted {
-		return nil, errs.ErrKeyNotFound
+// checkKey 校验写入的 key，索引限制了 key 的长度时同时校验长度
+func (db *DB) checkKey(key []byte) error {
+	if len(key) == 0 {
+		return errs.ErrKeyIsEmpty
 	}
-	return logRecord.Value, nil
+	if limiter, ok := db.index.(index.KeySizeLimiter); ok && len(key) > limiter.MaxKeySize() {
+		return errs.ErrKeyTooLarge
+	}
+	return nil
 }
 
 func checkOptions(options Options) error {
@@ -464,27 +952,77 @@ func checkOptions(options Options) error {
 	if options.DataFileMergeRatio < 0 || options.DataFileMergeRatio > 1 {
 		return errors.New("invalid merge ratio, must between 0 and 1")
 	}
+
+	if options.IndexShards < 0 {
+		return errors.New("index shards must not be negative")
+	}
+
+	if options.MaxOpenFiles < 0 {
+		return errors.New("max open files must not be negative")
+	}
+
+	if options.IndexLoadConcurrency < 0 {
+		return errors.New("index load concurrency must not be negative")
+	}
+
+	if options.IOType != fio.StandardFIO && options.IOType != fio.DirectIO && options.IOType != fio.BufferedIO {
+		return errors.New("data files only support standard, direct or buffered io")
+	}
+
+	if options.WriteBufferSize < 0 || options.WriteFlushInterval < 0 {
+		return errors.New("write buffer size and flush interval must not be negative")
+	}
+
+	if options.WriteBytesPerSec < 0 || options.MergeReadBytesPerSec < 0 || options.MergeWriteBytesPerSec < 0 ||
+		options.BackupReadBytesPerSec < 0 || options.BackupWriteBytesPerSec < 0 {
+		return errs.ErrNegativeRateLimit
+	}
+
+	// B+ 树索引直接读写磁盘上的索引文件
+	if _, ok := options.FileSystem.(fio.OSFileSystem); !ok && options.IndexType == index.BPTree && options.Indexer == nil {
+		return errors.New("b+ tree index only supports the os file system")
+	}
+
+	// B+ 树索引文件不经过加密的文件 IO，无法加密
+	if options.KeyProvider != nil && options.IndexType == index.BPTree && options.Indexer == nil {
+		return errors.New("b+ tree index does not support encryption")
+	}
 	return nil
 }
 
 func (db *DB) loadSeqNo() error {
 	fileName := filepath.Join(db.options.DirPath, structure.SeqNoFileName)
-	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+	if _, err := db.fs.Stat(fileName); os.IsNotExist(err) {
 		return nil
 	}
 
-	seqNoFile, err := structure.OpenSeqNoFile(db.options.DirPath)
+	seqNoFile, err := structure.OpenSeqNoFile(db.fs, db.options.DirPath)
 	if err != nil {
 		return err
 	}
 
-	record, _, err := seqNoFile.ReadLogRecord(0)
+	record, size, err := seqNoFile.ReadLogRecord(0)
 	seqNo, err := strconv.ParseUint(string(record.Value), 10, 64)
 	if err != nil {
 		return err
 	}
 
+	// 索引从检查点开始加载时，检查点之前的无效数据量从文件中恢复
+	if db.fromCheckpoint {
+		record, _, err := seqNoFile.ReadLogRecord(size)
+		if err == nil && string(record.Key) == reclaimSizeKey {
+			reclaimSize, err := strconv.ParseInt(string(record.Value), 10, 64)
+			if err != nil {
+				return err
+			}
+			db.reclaimSize += reclaimSize
+		}
+	}
+
 	db.seqNo = seqNo
 	db.seqNoFileExists = true
-	return os.Remove(fileName)
+	if err := seqNoFile.Close(); err != nil {
+		return err
+	}
+	return db.fs.Remove(fileName)
 }
@@ -1,11 +1,19 @@
 package db
 
 import (
+	"bytes"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/tClown11/kv-storage/errs"
+	"github.com/tClown11/kv-storage/fio"
+	"github.com/tClown11/kv-storage/index"
+	"github.com/tClown11/kv-storage/structure"
 	"github.com/tClown11/kv-storage/utils"
 )
 
@@ -161,6 +169,147 @@ func TestDB_Get(t *testing.T) {
 	assert.Equal(t, errs.ErrKeyNotFound, err)
 }
 
+func TestDB_GetDuringPut(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-get-during-put")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.MaxOpenFiles = 2
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+
+	// 持续写入，活跃文件不断转换为旧的数据文件
+	putDone := make(chan struct{})
+	go func() {
+		defer close(putDone)
+		for i := 0; i < 3000; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(100+i%1000), utils.GetTestValue(128)))
+		}
+	}()
+
+	getAll := func() {
+		for i := 0; i < 100; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.NotNil(t, val)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		getAll()
+		var count int
+		iter := db.NewIterator(IteratorOptions{Prefix: []byte("storage-kv-key-0000000")})
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			_, err := iter.Value()
+			assert.Nil(t, err)
+			count++
+		}
+		iter.Close()
+		assert.Equal(t, 100, count)
+	}
+
+	// 写入持有 db.mu 时读取仍然可以完成
+	db.mu.Lock()
+	getDone := make(chan struct{})
+	go func() {
+		defer close(getDone)
+		getAll()
+	}()
+	select {
+	case <-getDone:
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "get is blocked by the write lock")
+	}
+	db.mu.Unlock()
+	<-getDone
+
+	// 写入完成之前一直读取
+	for {
+		getAll()
+		select {
+		case <-putDone:
+			assert.True(t, len(db.olderFiles) > 1)
+			return
+		default:
+		}
+	}
+}
+
+// orderCheckingIndex 更新索引之前等待长短不一的时间，并检查同一个 key 的位置是否按照写入顺序更新
+type orderCheckingIndex struct {
+	*index.Btree
+	mu         sync.Mutex
+	last       *structure.LogRecordPos
+	outOfOrder int
+}
+
+func (oi *orderCheckingIndex) Put(key []byte, pos *structure.LogRecordPos) *structure.LogRecordPos {
+	time.Sleep(time.Duration(pos.Offset%2) * 50 * time.Microsecond)
+	oi.mu.Lock()
+	defer oi.mu.Unlock()
+	if oi.last != nil && (pos.Fid < oi.last.Fid || pos.Fid == oi.last.Fid && pos.Offset < oi.last.Offset) {
+		oi.outOfOrder++
+	}
+	oi.last = pos
+	return oi.Btree.Put(key, pos)
+}
+
+// 并发写入同一个 key，索引的更新顺序与数据文件中的写入顺序一致
+func TestDB_ConcurrentPutSameKey(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-concurrent-put-same-key")
+	opts.DirPath = dir
+	checking := &orderCheckingIndex{Btree: index.NewBtree(0, nil)}
+	opts.Indexer = checking
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.CreateSecondaryIndex("value", func(key, value []byte) [][]byte {
+		return [][]byte{value}
+	}))
+
+	key := utils.GetTestKey(1)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				assert.Nil(t, db.Put(key, []byte(fmt.Sprintf("value-%d-%d", g, i))))
+			}
+		}(g)
+	}
+	wg.Wait()
+	assert.Equal(t, 0, checking.outOfOrder)
+
+	// 二级索引与主索引一致
+	val, err := db.Get(key)
+	assert.Nil(t, err)
+	keys, err := db.LookupBy("value", val)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{key}, keys)
+	for g := 0; g < 4; g++ {
+		keys, err := db.LookupBy("value", []byte(fmt.Sprintf("value-%d-99", g)))
+		assert.Nil(t, err)
+		if !bytes.Equal(val, []byte(fmt.Sprintf("value-%d-99", g))) {
+			assert.Empty(t, keys)
+		}
+	}
+
+	// 不经过检查点，从数据文件重新构建的索引与之前相同
+	crashDB(db)
+	opts.Indexer = nil
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	val2, err := db2.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, val, val2)
+}
+
 func TestDB_Delete(t *testing.T) {
 	opts := DefaultOptions
 	dir, _ := os.MkdirTemp("", "bitcask-go-delete")
@@ -385,6 +534,7 @@ func TestDB_Stat(t *testing.T) {
 
 	stat := db.Stat()
 	assert.NotNil(t, stat)
+	assert.True(t, stat.IndexSize > 0)
 }
 
 func TestDB_Backup(t *testing.T) {
@@ -412,3 +562,752 @@ func TestDB_Backup(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotNil(t, db2)
 }
+
+func TestDB_OpenParallelLoad(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-parallel-load")
+	opts.DirPath = dir
+	opts.DataFileSize = 16 * 1024
+	opts.IndexLoadConcurrency = 1
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 数据分布在多个文件中，后面的文件覆盖和删除前面文件中的数据
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 500; i++ {
+			err := db.Put(utils.GetTestKey(i), utils.GetTestValue(20))
+			assert.Nil(t, err)
+		}
+		for i := round * 100; i < round*100+50; i++ {
+			err := db.Delete(utils.GetTestKey(i))
+			assert.Nil(t, err)
+		}
+		wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+		for i := 600; i < 650; i++ {
+			assert.Nil(t, wb.Put(utils.GetTestKey(i+round), utils.GetTestValue(20)))
+		}
+		assert.Nil(t, wb.Commit())
+	}
+	assert.True(t, len(db.olderFiles) > 4)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 删除检查点文件，重启时从数据文件中重新构建索引
+	assert.Nil(t, os.Remove(filepath.Join(dir, structure.IndexCheckpointFileName)))
+	db1, err := Open(opts)
+	assert.Nil(t, err)
+	keys := db1.ListKeys()
+	reclaimSize, seqNo := db1.reclaimSize, db1.seqNo
+	crashDB(db1)
+
+	opts.IndexLoadConcurrency = 4
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, keys, db2.ListKeys())
+	assert.Equal(t, 502, len(keys))
+	assert.Equal(t, reclaimSize, db2.reclaimSize)
+	assert.Equal(t, seqNo, db2.seqNo)
+	_, err = db2.Get(utils.GetTestKey(200))
+	assert.Equal(t, errs.ErrKeyNotFound, err)
+	val, err := db2.Get(utils.GetTestKey(651))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+
+	opts.IndexLoadConcurrency = -1
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}
+
+func TestDB_ARTIndex(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-art")
+	opts.DirPath = dir
+	opts.IndexType = index.ART
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestValue(20))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// 重启后索引从数据文件中重建
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+
+	keys := db2.ListKeys()
+	assert.Equal(t, 900, len(keys))
+	assert.Equal(t, utils.GetTestKey(100), keys[0])
+
+	_, err = db2.Get(utils.GetTestKey(1))
+	assert.Equal(t, errs.ErrKeyNotFound, err)
+	val, err := db2.Get(utils.GetTestKey(500))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+}
+
+func TestDB_ShardedIndex(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-sharded")
+	opts.DirPath = dir
+	opts.IndexShards = 8
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestValue(20))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+
+	// 各个分片归并之后依然有序
+	keys := db2.ListKeys()
+	assert.Equal(t, 900, len(keys))
+	for i := 1; i < len(keys); i++ {
+		assert.True(t, bytes.Compare(keys[i-1], keys[i]) < 0)
+	}
+
+	_, err = db2.Get(utils.GetTestKey(1))
+	assert.Equal(t, errs.ErrKeyNotFound, err)
+	val, err := db2.Get(utils.GetTestKey(500))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+
+	opts.IndexShards = -1
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}
+
+func TestDB_HashIndex(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-hash")
+	opts.DirPath = dir
+	opts.IndexType = index.Hash
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestValue(20))
+		assert.Nil(t, err)
+	}
+	err = db.Delete(utils.GetTestKey(0))
+	assert.Nil(t, err)
+
+	// 哈希索引遍历时同样是有序的
+	keys := db.ListKeys()
+	assert.Equal(t, 99, len(keys))
+	assert.Equal(t, utils.GetTestKey(1), keys[0])
+
+	var count int
+	err = db.Fold(func(key []byte, value []byte) bool {
+		count++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 99, count)
+
+	iter := db.NewIterator(IteratorOptions{Prefix: []byte("storage-kv-key-00000005"), Reverse: true})
+	defer iter.Close()
+	var prefixed [][]byte
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		prefixed = append(prefixed, iter.Key())
+	}
+	assert.Equal(t, 10, len(prefixed))
+	assert.Equal(t, utils.GetTestKey(59), prefixed[0])
+
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 99, len(db2.ListKeys()))
+	_, err = db2.Get(utils.GetTestKey(200))
+	assert.Equal(t, errs.ErrKeyNotFound, err)
+}
+
+func TestDB_SkiplistIndex(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-skiplist")
+	opts.DirPath = dir
+	opts.IndexType = index.SkipList
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 500; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestValue(20))
+		assert.Nil(t, err)
+	}
+
+	// 遍历过程中继续写入和删除数据
+	iter := db.NewIterator(DefaultIteratorOptions)
+	defer iter.Close()
+	var count int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		if count == 0 {
+			for i := 0; i < 100; i++ {
+				assert.Nil(t, db.Delete(utils.GetTestKey(i+400)))
+				assert.Nil(t, db.Put(utils.GetTestKey(i+500), utils.GetTestValue(20)))
+			}
+		}
+		count++
+	}
+	assert.Equal(t, 500, count)
+
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 500, len(db2.ListKeys()))
+	_, err = db2.Get(utils.GetTestKey(450))
+	assert.Equal(t, errs.ErrKeyNotFound, err)
+}
+
+func TestDB_CompactIndex(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-compact")
+	opts.DirPath = dir
+	opts.IndexType = index.Compact
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestValue(20))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// 紧凑型索引的内存占用小于 BTree 索引
+	stat := db.Stat()
+	assert.True(t, stat.IndexSize > 0)
+	bt := index.NewBtree(0, nil)
+	for _, key := range db.ListKeys() {
+		bt.Put(key, &structure.LogRecordPos{})
+	}
+	assert.True(t, stat.IndexSize < bt.MemSize())
+
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+
+	keys := db2.ListKeys()
+	assert.Equal(t, 900, len(keys))
+	assert.Equal(t, utils.GetTestKey(100), keys[0])
+	val, err := db2.Get(utils.GetTestKey(500))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+}
+
+func TestDB_KeyHashIndex(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-keyhash")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.IndexType = index.KeyHash
+	opts.IndexShards = 4
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 分片之后每个分片遍历时都需要扫描全部的数据文件，KeyHash 索引不分片
+	_, ok := db.index.(*index.KeyHashIndex)
+	assert.True(t, ok)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestValue(20))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	val, err := db.Get(utils.GetTestKey(500))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, errs.ErrKeyNotFound, err)
+
+	// 索引在 db.mu 之外更新，并发写入时读取数据文件中的 key
+	done := make(chan struct{})
+	value := utils.GetTestValue(20)
+	for g := 0; g < 4; g++ {
+		go func(g int) {
+			defer func() { done <- struct{}{} }()
+			for i := 1000 + g; i < 2000; i += 4 {
+				assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+				assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+				_, err := db.Get(utils.GetTestKey(i%900 + 100))
+				assert.Nil(t, err)
+			}
+		}(g)
+	}
+	for g := 0; g < 4; g++ {
+		<-done
+	}
+
+	// 遍历时顺序扫描数据文件
+	keys := db.ListKeys()
+	assert.Equal(t, 900, len(keys))
+	assert.Equal(t, utils.GetTestKey(100), keys[0])
+
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 关闭时不写入索引检查点
+	_, err = os.Stat(filepath.Join(dir, structure.IndexCheckpointFileName))
+	assert.True(t, os.IsNotExist(err))
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 900, len(db2.ListKeys()))
+	val, err = db2.Get(utils.GetTestKey(999))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+	_, err = db2.Get(utils.GetTestKey(99))
+	assert.Equal(t, errs.ErrKeyNotFound, err)
+}
+
+func TestDB_KeyHashIndexReadError(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/keyhash-read-error"
+	opts.IndexType = index.KeyHash
+	ffs := fio.NewFaultFileSystem()
+	opts.FileSystem = ffs
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(20)))
+	}
+
+	// 数据文件失效之后读取 key 出错，返回错误而不是 panic
+	ffs.PowerLoss(fio.CrashDropUnsynced)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.NotNil(t, err)
+	assert.NotEqual(t, errs.ErrKeyNotFound, err)
+	assert.NotNil(t, db.Delete(utils.GetTestKey(1)))
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.NotNil(t, wb.Delete(utils.GetTestKey(1)))
+	assert.Equal(t, 0, len(db.ListKeys()))
+}
+
+func TestDB_BPTreeIndex(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-bptree")
+	opts.DirPath = dir
+	opts.IndexType = index.BPTree
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestValue(20))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.Put(make([]byte, index.BPTreeMaxKeySize+1), utils.GetTestValue(20))
+	assert.Equal(t, errs.ErrKeyTooLarge, err)
+
+	// 正常关闭后重启，只需要从检查点开始加载
+	reclaimSize := db.reclaimSize
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.True(t, db2.fromCheckpoint)
+	assert.Equal(t, 900, len(db2.ListKeys()))
+	assert.Equal(t, reclaimSize, db2.reclaimSize)
+
+	for i := 1000; i < 1100; i++ {
+		err := db2.Put(utils.GetTestKey(i), utils.GetTestValue(20))
+		assert.Nil(t, err)
+	}
+
+	// 模拟进程崩溃，没有写入检查点，重启后重新构建索引
+	_ = db2.activeFile.Close()
+	_ = db2.index.Close()
+	_ = db2.fileLock.Unlock()
+
+	db3, err := Open(opts)
+	defer destroyDB(db3)
+	assert.Nil(t, err)
+	assert.False(t, db3.fromCheckpoint)
+	assert.Equal(t, 1000, len(db3.ListKeys()))
+	_, err = db3.Get(utils.GetTestKey(1))
+	assert.Equal(t, errs.ErrKeyNotFound, err)
+	val, err := db3.Get(utils.GetTestKey(1050))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+}
+
+func TestDB_BPTreeIndexKeyTooLarge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-bptree-key-too-large")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	err = db.Put(make([]byte, index.BPTreeMaxKeySize+1), utils.GetTestValue(20))
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+
+	// 其他索引类型写入的 key 超过了 B+ 树索引支持的长度，打开时返回错误
+	opts.IndexType = index.BPTree
+	_, err = Open(opts)
+	assert.Equal(t, errs.ErrKeyTooLarge, err)
+}
+
+func TestDB_CustomIndexer(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-custom-indexer")
+	opts.DirPath = dir
+	opts.Indexer = index.NewSkiplist(nil)
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	assert.Same(t, opts.Indexer, db.index)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestValue(20))
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 100, opts.Indexer.Size())
+
+	// merge 不会使用配置项中的索引实例
+	opts.DataFileMergeRatio = 0
+	db.options.DataFileMergeRatio = 0
+	err = db.Merge()
+	assert.Nil(t, err)
+	assert.Equal(t, 100, opts.Indexer.Size())
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 重新打开时使用新的索引实例
+	opts.Indexer = index.NewSkiplist(nil)
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, opts.Indexer.Size())
+}
+
+func TestDB_CustomIndexerKeySize(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-custom-indexer-key-size")
+	opts.DirPath = dir
+	bpt, err := index.NewBPlusTree(dir, false, nil)
+	assert.Nil(t, err)
+	opts.Indexer = bpt
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 配置项中的索引限制了 key 的长度
+	err = db.Put(make([]byte, index.BPTreeMaxKeySize+1), utils.GetTestValue(20))
+	assert.Equal(t, errs.ErrKeyTooLarge, err)
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	err = wb.Put(make([]byte, index.BPTreeMaxKeySize+1), utils.GetTestValue(20))
+	assert.Equal(t, errs.ErrKeyTooLarge, err)
+	err = db.Put(make([]byte, index.BPTreeMaxKeySize), utils.GetTestValue(20))
+	assert.Nil(t, err)
+}
+
+func TestDB_UnsupportedIndexType(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-unsupported-index")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.IndexType = 99
+	_, err := Open(opts)
+	assert.ErrorIs(t, err, errs.ErrIndexTypeUnsupported)
+
+	// 打开失败时释放文件锁
+	opts.IndexType = index.BTree
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+}
+
+func TestDB_OpenMMap(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-mmap")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestValue(20))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, db.Close())
+	// 删除索引检查点文件，重启时扫描全部的数据文件
+	err = os.Remove(filepath.Join(dir, structure.IndexCheckpointFileName))
+	assert.Nil(t, err)
+
+	// 使用 mmap 加载索引，加载完成后切换回标准文件 IO
+	opts.MMapAtStartup = true
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.Equal(t, 1500, len(db.ListKeys()))
+	assert.IsType(t, &fio.FileIO{}, db.activeFile.IoManager)
+	for _, dataFile := range db.olderFiles {
+		assert.IsType(t, &fio.FileIO{}, dataFile.IoManager)
+	}
+
+	value, err := db.Get(utils.GetTestKey(1000))
+	assert.Nil(t, err)
+	assert.NotNil(t, value)
+	err = db.Put(utils.GetTestKey(3000), utils.GetTestValue(20))
+	assert.Nil(t, err)
+	value, err = db.Get(utils.GetTestKey(3000))
+	assert.Nil(t, err)
+	assert.NotNil(t, value)
+}
+
+func TestDB_DirectIO(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-direct-io")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.IOType = fio.DirectIO
+	db, err := Open(opts)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Skipf("direct io is not supported: %v", err)
+	}
+	for i := 0; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestValue(20))
+		assert.Nil(t, err)
+	}
+	assert.IsType(t, &fio.DirectFileIO{}, db.activeFile.IoManager)
+	value, err := db.Get(utils.GetTestKey(1999))
+	assert.Nil(t, err)
+	assert.NotNil(t, value)
+	assert.Nil(t, db.Close())
+
+	// 重启后扫描数据文件重建索引
+	err = os.Remove(filepath.Join(dir, structure.IndexCheckpointFileName))
+	assert.Nil(t, err)
+	opts.MMapAtStartup = true
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.Equal(t, 2000, len(db.ListKeys()))
+	assert.IsType(t, &fio.DirectFileIO{}, db.activeFile.IoManager)
+	err = db.Put(utils.GetTestKey(3000), utils.GetTestValue(20))
+	assert.Nil(t, err)
+	value, err = db.Get(utils.GetTestKey(3000))
+	assert.Nil(t, err)
+	assert.NotNil(t, value)
+
+	opts.IOType = fio.MemoryMap
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}
+
+func TestDB_BufferedIO(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-buffered-io")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.IOType = fio.BufferedIO
+	opts.WriteBufferSize = 4 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	// 写入之后立即读取缓冲区中的数据
+	for i := 0; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestValue(20))
+		assert.Nil(t, err)
+		value, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.NotNil(t, value)
+	}
+	assert.IsType(t, &fio.BufferedFileIO{}, db.activeFile.IoManager)
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 2000; i < 2100; i++ {
+		err := wb.Put(utils.GetTestKey(i), utils.GetTestValue(20))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, wb.Commit())
+	assert.Nil(t, db.Close())
+
+	err = os.Remove(filepath.Join(dir, structure.IndexCheckpointFileName))
+	assert.Nil(t, err)
+	opts.WriteFlushInterval = 10 * time.Millisecond
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.Equal(t, 2100, len(db.ListKeys()))
+
+	opts.WriteBufferSize = -1
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}
+
+func TestDB_PreallocateDataFiles(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-preallocate")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.PreallocateDataFiles = true
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+	// 活跃文件预分配了全部的空间，写满的文件截断到实际的大小
+	size, err := db.activeFile.IoManager.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, opts.DataFileSize, size)
+	assert.True(t, db.activeFile.WriteOff < size)
+	for _, file := range db.olderFiles {
+		size, err := file.IoManager.Size()
+		assert.Nil(t, err)
+		assert.Equal(t, file.WriteOff, size)
+	}
+
+	// 没有正常关闭，重启之后从最后一条有效的记录继续写入
+	activeFileID, writeOff := db.activeFile.FileID, db.activeFile.WriteOff
+	assert.Nil(t, db.activeFile.Sync())
+	assert.Nil(t, db.fileLock.Unlock())
+	for _, file := range db.olderFiles {
+		assert.Nil(t, file.Close())
+	}
+	assert.Nil(t, db.activeFile.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, activeFileID, db.activeFile.FileID)
+	assert.Equal(t, writeOff, db.activeFile.WriteOff)
+	assert.Equal(t, 1000, len(db.ListKeys()))
+	for i := 1000; i < 1100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+	assert.Nil(t, db.Close())
+
+	// 正常关闭时截断到实际的大小
+	info, err := os.Stat(structure.GetStorageFileName(dir, activeFileID))
+	assert.Nil(t, err)
+	assert.True(t, info.Size() < opts.DataFileSize)
+
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.Equal(t, 1100, len(db.ListKeys()))
+	val, err := db.Get(utils.GetTestKey(1099))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+}
+
+func TestDB_MaxOpenFiles(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-max-open-files")
+	opts.DirPath = dir
+	opts.DataFileSize = 16 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.MaxOpenFiles = 2
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+	assert.True(t, len(db.olderFiles) > 10)
+	assert.True(t, db.fileCache.Len() <= 2)
+
+	// 并发读取被关闭的文件时重新打开，同时进行 merge
+	mergeDone := make(chan error)
+	go func() {
+		mergeDone <- db.Merge()
+	}()
+	done := make(chan struct{})
+	for g := 0; g < 4; g++ {
+		go func(g int) {
+			defer func() { done <- struct{}{} }()
+			for i := g; i < 2000; i += 4 {
+				val, err := db.Get(utils.GetTestKey(i))
+				assert.Nil(t, err)
+				assert.NotNil(t, val)
+			}
+		}(g)
+	}
+	var count int
+	iter := db.NewIterator(DefaultIteratorOptions)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		_, err := iter.Value()
+		assert.Nil(t, err)
+		count++
+	}
+	iter.Close()
+	assert.Equal(t, 2000, count)
+	for g := 0; g < 4; g++ {
+		<-done
+	}
+	assert.Nil(t, <-mergeDone)
+	assert.True(t, db.fileCache.Len() <= 2)
+	assert.Nil(t, db.Close())
+
+	// 重启之后加载 merge 的结果，旧数据文件在读取时才打开
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.True(t, db.fileCache.Len() <= 2)
+	for i := 0; i < 2000; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+	}
+	assert.True(t, db.fileCache.Len() <= 2)
+
+	opts.MaxOpenFiles = -1
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,161 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tClown11/kv-storage/errs"
+	"github.com/tClown11/kv-storage/fio"
+	"github.com/tClown11/kv-storage/index"
+)
+
+// rotateTempFileSuffix 轮换密钥时重新加密的临时文件后缀
+const rotateTempFileSuffix = ".rotate"
+
+// rotateCopySize 重新加密时每次读取的数据大小
+const rotateCopySize = 4096
+
+// RotateKeys 离线轮换数据目录的密钥，使用 newKeys 重新加密数据目录和 merge 目录中的所有文件
+// options.KeyProvider 为旧的密钥，为空表示数据目录没有加密；newKeys 为空表示解密为明文。
+// 数据目录不能处于打开状态。每个文件写入临时文件后通过重命名替换，轮换过程中崩溃时使用相同的参数重新执行即可，
+// 已经轮换过的文件会使用新的密钥解密，因此新旧密钥的 id 不能相同
+func RotateKeys(options Options, newKeys fio.KeyProvider) error {
+	if options.FileSystem == nil {
+		options.FileSystem = fio.OS
+	}
+	fs := options.FileSystem
+	if _, err := fs.Stat(options.DirPath); err != nil {
+		return err
+	}
+
+	fileLock, hold, err := fs.TryLock(filepath.Join(options.DirPath, fileLockName))
+	if err != nil {
+		return err
+	}
+	if !hold {
+		return errs.ErrDatabaseIsUsing
+	}
+	defer fileLock.Unlock()
+
+	readKeys := &rotateKeyProvider{oldKeys: options.KeyProvider, newKeys: newKeys}
+	for _, dirPath := range []string{options.DirPath, mergeDirPath(options.DirPath)} {
+		if _, err := fs.Stat(dirPath); os.IsNotExist(err) {
+			continue
+		}
+		if err := rotateDir(fs, dirPath, readKeys, newKeys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rotateDir 重新加密目录中的所有文件
+func rotateDir(fs fio.FileSystem, dirPath string, readKeys, newKeys fio.KeyProvider) error {
+	entries, err := fs.ReadDir(dirPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || name == fileLockName || name == index.BPTreeIndexFileName {
+			continue
+		}
+		fileName := filepath.Join(dirPath, name)
+		// 上一次轮换崩溃时留下的临时文件
+		if strings.HasSuffix(name, rotateTempFileSuffix) {
+			if err := fs.Remove(fileName); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := rotateFile(fs, fileName, readKeys, newKeys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rotateFile 读取文件的明文，使用 newKeys 写入临时文件之后替换原来的文件
+func rotateFile(fs fio.FileSystem, fileName string, readKeys, newKeys fio.KeyProvider) error {
+	src, err := openRotateSource(fs, fileName, readKeys)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tempFileName := fileName + rotateTempFileSuffix
+	dst, err := fs.OpenFile(tempFileName, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	if newKeys != nil {
+		encDst, err := fio.NewEncryptedIOManager(dst, newKeys)
+		if err != nil {
+			_ = dst.Close()
+			return err
+		}
+		dst = encDst
+	}
+	defer dst.Close()
+
+	size, err := src.Size()
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, rotateCopySize)
+	for offset := int64(0); offset < size; {
+		n, err := src.Read(buf[:min(int64(len(buf)), size-offset)], offset)
+		if err != nil {
+			return err
+		}
+		if _, err := dst.Write(buf[:n]); err != nil {
+			return err
+		}
+		offset += int64(n)
+	}
+	if err := dst.Sync(); err != nil {
+		return err
+	}
+	return fs.Rename(tempFileName, fileName)
+}
+
+// openRotateSource 打开需要轮换的文件，没有加密的文件直接读取
+func openRotateSource(fs fio.FileSystem, fileName string, readKeys fio.KeyProvider) (fio.IOManager, error) {
+	file, err := fs.OpenFile(fileName, fio.StandardFIO)
+	if err != nil {
+		return nil, err
+	}
+	encFile, err := fio.NewEncryptedIOManager(file, readKeys)
+	if errors.Is(err, fio.ErrNotEncrypted) {
+		return file, nil
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return encFile, nil
+}
+
+// rotateKeyProvider 轮换过程中解密使用的密钥，先查找旧的密钥，再查找新的密钥
+type rotateKeyProvider struct {
+	oldKeys fio.KeyProvider
+	newKeys fio.KeyProvider
+}
+
+func (rkp *rotateKeyProvider) CurrentKey() (uint32, []byte, error) {
+	return 0, nil, errors.New("the key provider is only used for decryption")
+}
+
+func (rkp *rotateKeyProvider) Key(id uint32) ([]byte, error) {
+	for _, keys := range []fio.KeyProvider{rkp.oldKeys, rkp.newKeys} {
+		if keys == nil {
+			continue
+		}
+		if key, err := keys.Key(id); err == nil {
+			return key, nil
+		}
+	}
+	return nil, fio.ErrUnknownKey
+}
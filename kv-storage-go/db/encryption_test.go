@@ -0,0 +1,134 @@
+package db
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tClown11/kv-storage/errs"
+	"github.com/tClown11/kv-storage/fio"
+	"github.com/tClown11/kv-storage/utils"
+)
+
+func newTestKeyProvider(t *testing.T, id uint32, b byte) fio.KeyProvider {
+	keys, err := fio.NewStaticKeyProvider(id, bytes.Repeat([]byte{b}, 32))
+	assert.Nil(t, err)
+	return keys
+}
+
+// assertNoPlaintext 校验数据目录中的文件都不包含明文
+func assertNoPlaintext(t *testing.T, dirPath string, plaintext []byte) {
+	err := filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		assert.False(t, bytes.Contains(data, plaintext), path)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestDB_Encryption(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = filepath.Join(t.TempDir(), "bitcask-go-encryption")
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.KeyProvider = newTestKeyProvider(t, 1, 'a')
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	value := []byte("sensitive-value")
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+	}
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	assertNoPlaintext(t, opts.DirPath, value)
+
+	// 重启之后加载 merge 的结果和 hint 索引文件
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 500, len(db.ListKeys()))
+	for i := 500; i < 1000; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+	assert.Nil(t, db.Close())
+	assertNoPlaintext(t, opts.DirPath, value)
+
+	// 没有密钥或者密钥错误时无法打开
+	plainOpts := opts
+	plainOpts.KeyProvider = nil
+	_, err = Open(plainOpts)
+	assert.NotNil(t, err)
+	wrongOpts := opts
+	wrongOpts.KeyProvider = newTestKeyProvider(t, 1, 'b')
+	_, err = Open(wrongOpts)
+	assert.Equal(t, fio.ErrDecryptFailed, err)
+}
+
+func TestDB_Encryption_BufferedIO(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = filepath.Join(t.TempDir(), "bitcask-go-encryption-buffered")
+	opts.IOType = fio.BufferedIO
+	opts.KeyProvider = newTestKeyProvider(t, 1, 'a')
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	value := []byte("sensitive-value")
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+	}
+	assert.Nil(t, db.Close())
+	assertNoPlaintext(t, opts.DirPath, value)
+
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	val, err := db.Get(utils.GetTestKey(99))
+	assert.Nil(t, err)
+	assert.Equal(t, value, val)
+}
+
+func TestDB_RotateKeys(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = filepath.Join(t.TempDir(), "bitcask-go-rotate-keys")
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	value := []byte("sensitive-value")
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+	}
+
+	// 数据目录正在使用时不能轮换
+	assert.Equal(t, errs.ErrDatabaseIsUsing, RotateKeys(opts, newTestKeyProvider(t, 1, 'a')))
+	assert.Nil(t, db.Close())
+
+	// 明文 -> 密钥 1 -> 密钥 2
+	key1, key2 := newTestKeyProvider(t, 1, 'a'), newTestKeyProvider(t, 2, 'b')
+	assert.Nil(t, RotateKeys(opts, key1))
+	assertNoPlaintext(t, opts.DirPath, value)
+	opts.KeyProvider = key1
+	assert.Nil(t, RotateKeys(opts, key2))
+
+	_, err = Open(opts)
+	assert.Equal(t, fio.ErrUnknownKey, err)
+	opts.KeyProvider = key2
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(db.ListKeys()))
+	val, err := db.Get(utils.GetTestKey(999))
+	assert.Nil(t, err)
+	assert.Equal(t, value, val)
+}
@@ -11,34 +11,48 @@ type Iterator struct {
 	indexIter index.Iterator // 索引迭代器
 	db        *DB
 	options   IteratorOptions
+	mergeSeq  uint64 // 创建时加载 merge 结果的序号，之后加载了 merge 的结果时位置信息已经失效
 }
 
 // NewIterator 初始化迭代器
 func (db *DB) NewIterator(opts IteratorOptions) *Iterator {
-	indexIter := db.index.Iterator(opts.Reverse)
+	indexIter, seq := db.stableIndexIterator(func() index.Iterator {
+		return db.indexIterator(opts)
+	})
+
 	return &Iterator{
 		indexIter: indexIter,
 		db:        db,
 		options:   opts,
+		mergeSeq:  seq,
+	}
+}
+
+// stableIndexIterator 创建索引迭代器，创建期间在当前实例中加载了 merge 的结果时重新创建，同时返回加载的序号
+func (db *DB) stableIndexIterator(newIter func() index.Iterator) (index.Iterator, uint64) {
+	for {
+		seq := db.waitMergeApplied()
+		iter := newIter()
+		if db.mergeSeq.Load() == seq {
+			return iter, seq
+		}
+		iter.Close()
 	}
 }
 
 // Rewind 重新回到迭代器的起点
 func (iter *Iterator) Rewind() {
 	iter.indexIter.Rewind()
-	iter.skipToNext()
 }
 
 // Seek 根据传入的 key 查找第一个大于( 或小于 ) 等于的目标 key，根据从这个 key 开始遍历
 func (iter *Iterator) Seek(key []byte) {
 	iter.indexIter.Seek(key)
-	iter.skipToNext()
 }
 
 // Next 跳转到下一个 key
 func (iter *Iterator) Next() {
 	iter.indexIter.Next()
-	iter.skipToNext()
 }
 
 // Valid 判断迭代器是否有效( 即迭代器是否能继续迭代 )
@@ -54,9 +68,12 @@ func (iter *Iterator) Key() []byte {
 // Value 获取当前索引位置指向的 value 数据
 func (iter *Iterator) Value() ([]byte, error) {
 	logRecordPos := iter.indexIter.Value()
-	iter.db.mu.Lock()
-	defer iter.db.mu.Unlock()
-	return iter.db.getValueByPosition(logRecordPos)
+	value, err := iter.db.getValueByPosition(logRecordPos)
+	// 创建之后在当前实例中加载了 merge 的结果，位置信息可能指向被替换的数据文件，按照 key 重新读取
+	if iter.db.mergeSeq.Load() != iter.mergeSeq {
+		return iter.db.Get(iter.Key())
+	}
+	return value, err
 }
 
 // Close 关闭迭代器，释放相应资源
@@ -64,15 +81,99 @@ func (iter *Iterator) Close() {
 	iter.indexIter.Close()
 }
 
-func (iter *Iterator) skipToNext() {
-	prefixLen := len(iter.options.Prefix)
-	if prefixLen == 0 {
-		return
+// indexIterator 根据配置项创建限定了遍历范围的索引迭代器
+func (db *DB) indexIterator(opts IteratorOptions) index.Iterator {
+	indexIter := db.index.Iterator(opts.Reverse)
+
+	// 限定遍历范围，离开范围之后迭代器立即失效
+	lower, upper := db.iteratorBounds(opts)
+	if lower != nil || upper != nil {
+		indexIter = index.NewBoundedIterator(indexIter, lower, upper, opts.Reverse, db.options.Comparator)
+	}
+	if db.filterPrefix(opts) {
+		indexIter = newPrefixIterator(indexIter, opts.Prefix)
+	}
+	return indexIter
+}
+
+// filterPrefix 是否需要逐个过滤前缀
+// 比较器不是字节序时，相同前缀的 key 不一定是连续的，无法转换为遍历范围
+func (db *DB) filterPrefix(opts IteratorOptions) bool {
+	return len(opts.Prefix) > 0 && !index.IsBytewise(db.options.Comparator)
+}
+
+// iteratorBounds 将前缀和上下界合并为一个遍历范围
+func (db *DB) iteratorBounds(opts IteratorOptions) ([]byte, []byte) {
+	if db.filterPrefix(opts) {
+		return opts.LowerBound, opts.UpperBound
+	}
+	return bytewiseBounds(opts)
+}
+
+// bytewiseBounds 按照字节序将前缀和上下界合并为一个遍历范围
+func bytewiseBounds(opts IteratorOptions) ([]byte, []byte) {
+	lower, upper := opts.LowerBound, opts.UpperBound
+	if len(opts.Prefix) == 0 {
+		return lower, upper
 	}
 
-	for ; iter.indexIter.Valid(); iter.indexIter.Next() {
-		key := iter.indexIter.Key()
-		if prefixLen <= len(key) && bytes.Equal(iter.options.Prefix, key[:prefixLen]) {
+	if lower == nil || bytes.Compare(opts.Prefix, lower) > 0 {
+		lower = opts.Prefix
+	}
+	if prefixEnd := prefixUpperBound(opts.Prefix); prefixEnd != nil &&
+		(upper == nil || bytes.Compare(prefixEnd, upper) < 0) {
+		upper = prefixEnd
+	}
+	return lower, upper
+}
+
+// prefixUpperBound 返回大于所有以 prefix 为前缀的 key 的最小值，prefix 全部为 0xff 时没有上界
+func prefixUpperBound(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			upper := make([]byte, i+1)
+			copy(upper, prefix)
+			upper[i]++
+			return upper
+		}
+	}
+	return nil
+}
+
+// prefixIterator 跳过不包含指定前缀的 key
+type prefixIterator struct {
+	index.Iterator
+	prefix []byte
+}
+
+func newPrefixIterator(iter index.Iterator, prefix []byte) *prefixIterator {
+	pi := &prefixIterator{Iterator: iter, prefix: prefix}
+	pi.skipToNext()
+	return pi
+}
+
+// Rewind 重新回到迭代器的起点，即第一个包含前缀的 key
+func (pi *prefixIterator) Rewind() {
+	pi.Iterator.Rewind()
+	pi.skipToNext()
+}
+
+// Seek 根据传入的 key 查找到第一个大于(或小于) 等于且包含前缀的目标 key
+func (pi *prefixIterator) Seek(key []byte) {
+	pi.Iterator.Seek(key)
+	pi.skipToNext()
+}
+
+// Next 跳转到下一个包含前缀的 key
+func (pi *prefixIterator) Next() {
+	pi.Iterator.Next()
+	pi.skipToNext()
+}
+
+// skipToNext 跳过不包含前缀的 key，直到遍历结束
+func (pi *prefixIterator) skipToNext() {
+	for ; pi.Iterator.Valid(); pi.Iterator.Next() {
+		if bytes.HasPrefix(pi.Iterator.Key(), pi.prefix) {
 			break
 		}
 	}
@@ -33,7 +33,7 @@ func destroyDB(db *DB) {
 				_ = of.Close()
 			}
 		}
-		err := os.RemoveAll(db.options.DirPath)
+		err := db.fs.RemoveAll(db.options.DirPath)
 		if err != nil {
 			panic(err)
 		}
@@ -169,3 +169,51 @@ func TestDB_Iterator_Mutil_Values(t *testing.T) {
 	}
 	iter_3.Close()
 }
+
+func TestDB_Iterator_Bounds(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "test_iterator-bounds")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for _, key := range []string{"a", "b", "ba", "bb", "b\xff", "c", "d"} {
+		err = db.Put([]byte(key), utils.GetTestValue(10))
+		assert.Nil(t, err)
+	}
+
+	tests := []struct {
+		opts IteratorOptions
+		keys []string
+	}{
+		{opts: IteratorOptions{Prefix: []byte("b")}, keys: []string{"b", "ba", "bb", "b\xff"}},
+		{opts: IteratorOptions{Prefix: []byte("b"), Reverse: true}, keys: []string{"b\xff", "bb", "ba", "b"}},
+		{opts: IteratorOptions{LowerBound: []byte("ba"), UpperBound: []byte("c")}, keys: []string{"ba", "bb", "b\xff"}},
+		{opts: IteratorOptions{LowerBound: []byte("ba"), UpperBound: []byte("c"), Reverse: true}, keys: []string{"b\xff", "bb", "ba"}},
+		{opts: IteratorOptions{UpperBound: []byte("b"), Reverse: true}, keys: []string{"a"}},
+		{opts: IteratorOptions{Prefix: []byte("b"), LowerBound: []byte("bb")}, keys: []string{"bb", "b\xff"}},
+		{opts: IteratorOptions{Prefix: []byte("b"), UpperBound: []byte("bb"), Reverse: true}, keys: []string{"ba", "b"}},
+		{opts: IteratorOptions{Prefix: []byte("e")}, keys: nil},
+	}
+	for _, tt := range tests {
+		iter := db.NewIterator(tt.opts)
+		var keys []string
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		assert.Equal(t, tt.keys, keys)
+		iter.Close()
+	}
+
+	// 离开范围之后迭代器立即失效
+	iter := db.NewIterator(IteratorOptions{Prefix: []byte("b"), Reverse: true})
+	defer iter.Close()
+	iter.Seek([]byte("bb"))
+	assert.Equal(t, []byte("bb"), iter.Key())
+	iter.Seek([]byte("z"))
+	assert.Equal(t, []byte("b\xff"), iter.Key())
+	iter.Seek([]byte("a"))
+	assert.False(t, iter.Valid())
+}
@@ -0,0 +1,239 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tClown11/kv-storage/errs"
+	"github.com/tClown11/kv-storage/fio"
+	"github.com/tClown11/kv-storage/index"
+	"github.com/tClown11/kv-storage/utils"
+)
+
+func TestDB_InMemory(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = filepath.Join(t.TempDir(), "bitcask-go-in-memory")
+	opts.DataFileSize = 64 * 1024
+	opts.InMemory = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestValue(128))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(0)))
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(1000), utils.GetTestValue(128)))
+	assert.Nil(t, wb.Delete(utils.GetTestKey(1)))
+	assert.Nil(t, wb.Commit())
+
+	_, err = db.Get(utils.GetTestKey(0))
+	assert.Equal(t, errs.ErrKeyNotFound, err)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, errs.ErrKeyNotFound, err)
+	val, err := db.Get(utils.GetTestKey(1000))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+
+	var count int
+	iter := db.NewIterator(DefaultIteratorOptions)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		_, err := iter.Value()
+		assert.Nil(t, err)
+		count++
+	}
+	iter.Close()
+	assert.Equal(t, 999, count)
+
+	stat := db.Stat()
+	assert.True(t, stat.DataFileNum > 1)
+	assert.True(t, stat.DiskSize > 0)
+
+	// 没有访问磁盘
+	_, err = os.Stat(opts.DirPath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+// 没有共享的文件系统时在当前实例中加载 merge 的结果
+func TestDB_InMemory_Merge(t *testing.T) {
+	for _, typ := range []index.IndexType{index.BTree, index.KeyHash} {
+		opts := DefaultOptions
+		opts.DirPath = "/bitcask-go-in-memory-merge"
+		opts.DataFileSize = 32 * 1024
+		opts.DataFileMergeRatio = 0
+		opts.InMemory = true
+		opts.IndexType = typ
+		db, err := Open(opts)
+		assert.Nil(t, err)
+
+		for i := 0; i < 1000; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+		}
+		for i := 0; i < 500; i++ {
+			assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+		}
+		for i := 900; i < 1000; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("new value before merge")))
+		}
+		dataFileNum := db.Stat().DataFileNum
+
+		// merge 之前创建的迭代器在 merge 之后仍然可以读取
+		iter := db.NewIterator(DefaultIteratorOptions)
+
+		// merge 的同时读取
+		done := make(chan struct{})
+		wg := new(sync.WaitGroup)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 500; ; i = 500 + (i+1)%500 {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				val, err := db.Get(utils.GetTestKey(i))
+				assert.Nil(t, err)
+				assert.NotNil(t, val)
+			}
+		}()
+		assert.Nil(t, db.Merge())
+		close(done)
+		wg.Wait()
+
+		stat := db.Stat()
+		assert.True(t, stat.DataFileNum < dataFileNum)
+		assert.Equal(t, int64(0), stat.ReclaimableSize)
+		assert.Equal(t, 500, len(db.ListKeys()))
+		for i := 0; i < 500; i++ {
+			_, err := db.Get(utils.GetTestKey(i))
+			assert.Equal(t, errs.ErrKeyNotFound, err)
+		}
+		for i := 900; i < 1000; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("new value before merge"), val)
+		}
+
+		var count int
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			val, err := iter.Value()
+			assert.Nil(t, err)
+			assert.NotNil(t, val)
+			count++
+		}
+		iter.Close()
+		assert.Equal(t, 500, count)
+
+		// merge 之后继续写入，再次 merge
+		for i := 500; i < 600; i++ {
+			assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+		}
+		for i := 900; i < 1000; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("new value after merge")))
+		}
+		assert.Nil(t, db.Merge())
+		assert.Equal(t, int64(0), db.Stat().ReclaimableSize)
+		assert.Equal(t, 400, len(db.ListKeys()))
+		for i := 600; i < 1000; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			if i >= 900 {
+				assert.Equal(t, []byte("new value after merge"), val)
+			}
+		}
+		destroyDB(db)
+	}
+}
+
+func TestDB_InMemory_Reopen(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/bitcask-go-in-memory-reopen"
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.InMemory = true
+	opts.FileSystem = fio.NewMemFileSystem()
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+
+	// 同一个内存文件系统同时只能打开一次
+	_, err = Open(opts)
+	assert.Equal(t, errs.ErrDatabaseIsUsing, err)
+
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+
+	// 重启之后加载 merge 的结果
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 500, len(db2.ListKeys()))
+	assert.Equal(t, int64(0), db2.Stat().ReclaimableSize)
+	for i := 500; i < 1000; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+	}
+}
+
+func TestDB_InMemory_BackupAndLoad(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/bitcask-go-in-memory-backup"
+	opts.InMemory = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+
+	// 备份到磁盘目录中，使用磁盘上的文件打开
+	backupDir := filepath.Join(t.TempDir(), "backup")
+	assert.Nil(t, db.Backup(backupDir))
+
+	diskOpts := DefaultOptions
+	diskOpts.DirPath = backupDir
+	db2, err := Open(diskOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(db2.ListKeys()))
+	assert.Nil(t, db2.Put(utils.GetTestKey(1000), utils.GetTestValue(128)))
+	assert.Nil(t, db2.Close())
+
+	// 从磁盘目录加载到内存中
+	memFS := fio.NewMemFileSystem()
+	assert.Nil(t, memFS.Load(backupDir, "/loaded"))
+	memOpts := DefaultOptions
+	memOpts.DirPath = "/loaded"
+	memOpts.FileSystem = memFS
+	db3, err := Open(memOpts)
+	defer destroyDB(db3)
+	assert.Nil(t, err)
+	assert.Equal(t, 1001, len(db3.ListKeys()))
+	val, err := db3.Get(utils.GetTestKey(1000))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+}
+
+func TestDB_InMemory_BPTree(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/bitcask-go-in-memory-bptree"
+	opts.InMemory = true
+	opts.IndexType = index.BPTree
+	_, err := Open(opts)
+	assert.NotNil(t, err)
+}
@@ -1,14 +1,17 @@
 package db
 
 import (
-	"io"
 	"os"
 	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
+	"sync/atomic"
 
 	"github.com/tClown11/kv-storage/errs"
+	"github.com/tClown11/kv-storage/fio"
+	"github.com/tClown11/kv-storage/index"
 	"github.com/tClown11/kv-storage/structure"
 	"github.com/tClown11/kv-storage/utils"
 )
@@ -16,6 +19,7 @@ import (
 const (
 	mergeDirName     = "-merge"
 	mergeFinishedKey = "merge.finished"
+	mergedFilesKey   = "merge.files"
 )
 
 // Merge 清理无效数据，生成 Hint 文件
@@ -30,29 +34,38 @@ func (db *DB) Merge() error {
 		return errs.ErrMergeIsProgress
 	}
 
+	// 持有写入锁，已经写入活跃文件的数据都已经更新到索引中，merge 时不会被当作无效的数据丢弃
+	db.writeMu.Lock()
 	db.mu.Lock()
 
 	// 查看 merge 的数据量是否已达到阈值
-	totalSize, err := utils.DirSize(db.options.DirPath)
+	totalSize, err := fio.DirSize(db.fs, db.options.DirPath)
 	if err != nil {
 		db.mu.Unlock()
+		db.writeMu.Unlock()
 		return err
 	}
 
-	if float32(db.reclaimSize)/float32(totalSize) < db.options.DataFileMergeRatio {
+	reclaimSize := atomic.LoadInt64(&db.reclaimSize)
+	if float32(reclaimSize)/float32(totalSize) < db.options.DataFileMergeRatio {
 		db.mu.Unlock()
+		db.writeMu.Unlock()
 		return errs.ErrMergeRatioUnreached
 	}
 
 	// 查看剩余的空间是否可以容纳 merge 时生成的临时目录
-	availableDiskSize, err := utils.AvailableDiskSize()
-	if err != nil {
-		db.mu.Unlock()
-		return err
-	}
-	if uint64(totalSize-db.reclaimSize) >= availableDiskSize {
-		db.mu.Unlock()
-		return errs.ErrNoEnoughSpaceForMerge
+	if _, ok := db.options.FileSystem.(fio.OSFileSystem); ok {
+		availableDiskSize, err := utils.AvailableDiskSize()
+		if err != nil {
+			db.mu.Unlock()
+			db.writeMu.Unlock()
+			return err
+		}
+		if uint64(totalSize-reclaimSize) >= availableDiskSize {
+			db.mu.Unlock()
+			db.writeMu.Unlock()
+			return errs.ErrNoEnoughSpaceForMerge
+		}
 	}
 
 	db.isMerging = true
@@ -63,13 +76,13 @@ func (db *DB) Merge() error {
 	// 持久化当前活跃文件
 	if err := db.activeFile.Sync(); err != nil {
 		db.mu.Unlock()
+		db.writeMu.Unlock()
 		return err
 	}
-	// 将当前活跃文件转换为旧的数据文件
-	db.olderFiles[db.activeFile.FileID] = db.activeFile
-	// 打开新的活跃文件
+	// 将当前活跃文件转换为旧的数据文件，并打开新的活跃文件
 	if err := db.setActiveDataFile(); err != nil {
 		db.mu.Unlock()
+		db.writeMu.Unlock()
 		return err
 	}
 	// 记录不参与 merge 的文件 id
@@ -81,6 +94,7 @@ func (db *DB) Merge() error {
 		mergeFiles = append(mergeFiles, file)
 	}
 	db.mu.Unlock()
+	db.writeMu.Unlock()
 
 	// 待 merge 的文件，从小到大进行排序，依次 merge ( 考虑是否可以优化 merge 顺序，以减少 IO 读取消耗 )
 	sort.Slice(mergeFiles, func(i, j int) bool {
@@ -89,13 +103,13 @@ func (db *DB) Merge() error {
 
 	mergePath := db.getMergePath()
 	// 如果目录存在，说明发生过 merge，将其删除掉
-	if _, err := os.Stat(mergePath); err == nil {
-		if err := os.RemoveAll(mergePath); err != nil {
+	if _, err := db.fs.Stat(mergePath); err == nil {
+		if err := db.fs.RemoveAll(mergePath); err != nil {
 			return err
 		}
 	}
 	// 新建一个 merge path 的目录
-	if err := os.MkdirAll(mergePath, os.ModePerm); err != nil {
+	if err := db.fs.MkdirAll(mergePath); err != nil {
 		return err
 	}
 
@@ -103,32 +117,47 @@ func (db *DB) Merge() error {
 	mergeOptions := db.options
 	mergeOptions.DirPath = mergePath
 	mergeOptions.SyncWrites = false
+	// merge 时不使用索引，不能与当前实例共享配置项中指定的索引
+	mergeOptions.Indexer = nil
 	mergeDB, err := Open(mergeOptions)
 	if err != nil {
 		return err
 	}
+	// 出错时也要关闭临时实例，释放数据文件、索引和 merge 目录的文件锁
+	mergeDBClosed := false
+	defer func() {
+		if !mergeDBClosed {
+			_ = mergeDB.Close()
+		}
+	}()
+	// 新的数据文件和 hint 文件按照 merge 的限速写入
+	mergeDB.fileWriteLimiter = db.mergeWriteLimiter
 
 	// 打开 hint 文件存储索引
-	hintFile, err := structure.OpenHintFile(mergePath)
+	hintFile, err := structure.OpenHintFile(db.fs, mergePath)
 	if err != nil {
 		return err
 	}
+	defer hintFile.Close()
+	hintFile.IoManager = fio.NewThrottledIOManager(hintFile.IoManager, nil, db.mergeWriteLimiter)
+
+	// 被丢弃的数据量，在当前实例中加载 merge 的结果时从无效数据量中减去
+	var discardSize int64
 
 	// 遍历处理每个数据文件
-	for _, dataFile := range mergeFiles {
-		var offset int64 = 0
-		for {
-			logRecord, size, err := dataFile.ReadLogRecord(offset)
-			if err != nil {
-				if err == io.EOF {
-					break
-				}
-				return err
-			}
+	for _, mergeFile := range mergeFiles {
+		// 按照 merge 的限速读取，不影响其他读取同一个文件的请求
+		dataFile := structure.NewStorageFile(mergeFile.FileID, fio.NewThrottledIOManager(mergeFile.IoManager, db.mergeReadLimiter, nil))
+		scanner := dataFile.NewScanner(0)
+		for scanner.Next() {
+			logRecord, offset := scanner.Record(), scanner.Offset()
 
 			// 解析拿到实际的 key
 			realKey, _ := structure.ParseKeyAndSeqFromLogRecordKey(logRecord.Key)
-			logRecordPos := db.index.Get(realKey)
+			logRecordPos, err := index.GetWithError(db.index, realKey)
+			if err != nil {
+				return err
+			}
 			// 与内存中的索引位置进行比较，如果有效则重写
 			if logRecordPos != nil &&
 				logRecordPos.Fid == dataFile.FileID &&
@@ -144,9 +173,12 @@ func (db *DB) Merge() error {
 				if err = hintFile.WriteHintRecord(realKey, pos); err != nil {
 					return err
 				}
+			} else if logRecord.Type == structure.LogRecordNormal {
+				discardSize += scanner.Size()
 			}
-			// 增加 offset
-			offset += size
+		}
+		if err := scanner.Err(); err != nil {
+			return err
 		}
 	}
 
@@ -154,15 +186,30 @@ func (db *DB) Merge() error {
 	if err := hintFile.Sync(); err != nil {
 		return err
 	}
+	if mergeDB.activeFile != nil {
+		if err := mergeDB.trimActiveFile(); err != nil {
+			return err
+		}
+	}
 	if err := mergeDB.Sync(); err != nil {
 		return err
 	}
+	// 记录 merge 生成的数据文件数量，加载时编号更大的旧数据文件需要删除
+	var mergedFileNum int
+	if mergeDB.activeFile != nil {
+		mergedFileNum = int(mergeDB.activeFile.FileID) + 1
+	}
+	mergeDBClosed = true
+	if err := mergeDB.Close(); err != nil {
+		return err
+	}
 
 	// 写标识 merge 完成的文件
-	mergeFinishedFile, err := structure.OpenMergeFinishedFile(mergePath)
+	mergeFinishedFile, err := structure.OpenMergeFinishedFile(db.fs, mergePath)
 	if err != nil {
 		return err
 	}
+	defer mergeFinishedFile.Close()
 	mergeFinRecord := &structure.LogRecord{
 		Key:   []byte(mergeFinishedKey),
 		Value: []byte(strconv.Itoa(int(nonMergeFileID))),
@@ -171,31 +218,154 @@ func (db *DB) Merge() error {
 	if err := mergeFinishedFile.Write(encRecord); err != nil {
 		return err
 	}
+	mergedFilesRecord := &structure.LogRecord{
+		Key:   []byte(mergedFilesKey),
+		Value: []byte(strconv.Itoa(mergedFileNum)),
+	}
+	encRecord, _ = mergedFilesRecord.EncodeLogRecord()
+	if err := mergeFinishedFile.Write(encRecord); err != nil {
+		return err
+	}
 	if err := mergeFinishedFile.Sync(); err != nil {
 		return err
 	}
+
+	// 打开时新建的内存文件系统关闭之后无法再次打开，直接在当前实例中加载 merge 的结果
+	if db.privateMemFS {
+		return db.applyMerge(nonMergeFileID, discardSize)
+	}
+	return nil
+}
+
+// applyMerge 在当前实例中加载 merge 的结果，替换参与 merge 的数据文件，并更新仍然指向这些文件的索引
+// 加载期间持有写入锁和 db.mu，新的数据文件会复用旧的文件 id，读取时通过 mergeSeq 判断位置信息是否失效
+func (db *DB) applyMerge(nonMergeFileID uint32, discardSize int64) error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// 序号为奇数时表示正在加载，读取需要等待
+	db.mergeSeq.Add(1)
+	defer db.mergeSeq.Add(1)
+
+	// 替换数据文件之前找到需要更新的索引，KeyHash 索引需要通过旧的位置信息读取 key
+	updates, err := db.mergedPositions(nonMergeFileID)
+	if err != nil {
+		return err
+	}
+	if err := db.loadMergeFiles(); err != nil {
+		return err
+	}
+
+	// 重新打开编号小于 nonMergeFileID 的数据文件，旧的文件在新的快照发布之后再关闭
+	var closeFiles []*structure.StorageFile
+	for fid, file := range db.olderFiles {
+		if fid < nonMergeFileID {
+			closeFiles = append(closeFiles, file)
+			delete(db.olderFiles, fid)
+		}
+	}
+	dirEntries, err := db.fs.ReadDir(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range dirEntries {
+		fid, ok := parseStorageFileID(entry.Name())
+		if !ok || uint32(fid) >= nonMergeFileID {
+			continue
+		}
+		var dataFile *structure.StorageFile
+		if db.fileCache != nil {
+			dataFile = structure.NewStorageFile(uint32(fid), db.cachedIOManager(uint32(fid), db.options.IOType, nil))
+		} else if dataFile, err = db.openStorageFile(uint32(fid), db.options.IOType); err != nil {
+			return err
+		}
+		if dataFile.WriteOff, err = dataFile.IoManager.Size(); err != nil {
+			return err
+		}
+		db.olderFiles[uint32(fid)] = dataFile
+	}
+
+	khi, isKeyHash := db.index.(*index.KeyHashIndex)
+	for _, update := range updates {
+		if isKeyHash {
+			khi.ReplacePos(update.key, update.oldPos, update.newPos)
+		} else if _, err := index.PutWithError(db.index, update.key, update.newPos); err != nil {
+			return err
+		}
+	}
+	db.publishFiles()
+	for _, file := range closeFiles {
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+
+	// 加载之后被丢弃的数据已经被删除
+	if reclaimSize := atomic.AddInt64(&db.reclaimSize, -discardSize); reclaimSize < 0 {
+		atomic.AddInt64(&db.reclaimSize, -reclaimSize)
+	}
 	return nil
 }
 
+// mergedPosition 参与 merge 的数据在 merge 前后的位置信息
+type mergedPosition struct {
+	key    []byte
+	oldPos *structure.LogRecordPos
+	newPos *structure.LogRecordPos
+}
+
+// mergedPositions 读取 merge 目录中的 hint 文件，找到当前仍然指向参与 merge 的文件的 key
+// merge 之后被重新写入或者删除的 key 已经指向新的位置，不需要更新
+func (db *DB) mergedPositions(nonMergeFileID uint32) ([]*mergedPosition, error) {
+	hintFile, err := structure.OpenHintFile(db.fs, db.getMergePath())
+	if err != nil {
+		return nil, err
+	}
+	defer hintFile.Close()
+
+	var updates []*mergedPosition
+	scanner := hintFile.NewScanner(0)
+	for scanner.Next() {
+		logRecord := scanner.Record()
+		oldPos, err := index.GetWithError(db.index, logRecord.Key)
+		if err != nil {
+			return nil, err
+		}
+		if oldPos == nil || oldPos.Fid >= nonMergeFileID {
+			continue
+		}
+		updates = append(updates, &mergedPosition{
+			key:    logRecord.Key,
+			oldPos: oldPos,
+			newPos: structure.DecodeLogRecordPos(logRecord.Value),
+		})
+	}
+	return updates, scanner.Err()
+}
+
 func (db *DB) getMergePath() string {
-	dir := path.Dir(path.Clean(db.options.DirPath))
-	base := path.Base(db.options.DirPath)
+	return mergeDirPath(db.options.DirPath)
+}
+
+// mergeDirPath 数据目录对应的 merge 目录
+func mergeDirPath(dirPath string) string {
+	dir := path.Dir(path.Clean(dirPath))
+	base := path.Base(dirPath)
 	return filepath.Join(dir, base+mergeDirName)
 }
 
 // 加载 merge 数据目录
+// 加载过程中崩溃时 merge 完成的标识仍然在 merge 目录中，下次启动会重新执行，每一步都可以重复执行
 func (db *DB) loadMergeFiles() error {
 	mergePath := db.getMergePath()
 	// merge 目录不存在，直接返回
-	if _, err := os.Stat(mergePath); os.IsNotExist(err) {
+	if _, err := db.fs.Stat(mergePath); os.IsNotExist(err) {
 		return nil
 	}
 
-	defer func() {
-		os.RemoveAll(mergePath)
-	}()
-
-	dirEntries, err := os.ReadDir(mergePath)
+	dirEntries, err := db.fs.ReadDir(mergePath)
 	if err != nil {
 		return err
 	}
@@ -207,92 +377,134 @@ func (db *DB) loadMergeFiles() error {
 		switch entry.Name() {
 		case structure.MergeFinishedfileName:
 			mergeFinished = true
-		case structure.SeqNoFileName, fileLockName:
+			continue
+		case structure.SeqNoFileName, structure.ComparatorFileName, fileLockName, index.BPTreeIndexFileName,
+			structure.IndexCheckpointFileName, structure.IndexCheckpointTempFileName:
 			continue
 		}
 
 		mergeFileNames = append(mergeFileNames, entry.Name())
 	}
 
-	// 没有 merge 完成则直接返回
+	// 没有 merge 完成则删除 merge 目录
 	if !mergeFinished {
-		return nil
+		return db.fs.RemoveAll(mergePath)
 	}
 
-	nonMergeFiledID, err := db.getNonMergeFileID(mergePath)
+	nonMergeFiledID, mergedFileNum, err := db.readMergeFinished(mergePath)
 	if err != nil {
 		return nil
 	}
-
-	// 删除旧的数据文件
-	var fileID uint32 = 0
-	for ; fileID < nonMergeFiledID; fileID++ {
-		fileName := structure.GetStorageFileName(db.options.DirPath, fileID)
-		if _, err := os.Stat(fileName); err == nil {
-			if err := os.Remove(fileName); err != nil {
-				return err
+	// 旧版本没有记录 merge 生成的文件数量，此时 merge 目录中的文件还没有被移动过
+	if mergedFileNum < 0 {
+		mergedFileNum = 0
+		for _, fileName := range mergeFileNames {
+			if fileID, ok := parseStorageFileID(fileName); ok && fileID+1 > mergedFileNum {
+				mergedFileNum = fileID + 1
 			}
 		}
 	}
 
-	// 将新的数据文件移动到数据目录中
+	// 将新的数据文件移动到数据目录中，覆盖编号相同的旧数据文件
 	for _, fileName := range mergeFileNames {
 		srcPath := filepath.Join(mergePath, fileName)
 		dstPath := filepath.Join(db.options.DirPath, fileName)
-		if err := os.Rename(srcPath, dstPath); err != nil {
+		if err := db.fs.Rename(srcPath, dstPath); err != nil {
 			return err
 		}
 	}
-	return nil
+
+	// 删除剩余的旧数据文件
+	for fileID := uint32(mergedFileNum); fileID < nonMergeFiledID; fileID++ {
+		fileName := structure.GetStorageFileName(db.options.DirPath, fileID)
+		if _, err := db.fs.Stat(fileName); err == nil {
+			if err := db.fs.Remove(fileName); err != nil {
+				return err
+			}
+		}
+	}
+
+	// 索引检查点中的位置信息指向的是 merge 之前的数据文件，已经失效
+	checkpointFileName := filepath.Join(db.options.DirPath, structure.IndexCheckpointFileName)
+	if err := db.fs.Remove(checkpointFileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// 最后移动 merge 完成的标识，之后 merge 目录中的内容不再需要
+	srcPath := filepath.Join(mergePath, structure.MergeFinishedfileName)
+	dstPath := filepath.Join(db.options.DirPath, structure.MergeFinishedfileName)
+	if err := db.fs.Rename(srcPath, dstPath); err != nil {
+		return err
+	}
+	db.mergeLoaded = true
+	return db.fs.RemoveAll(mergePath)
 }
 
 func (db *DB) getNonMergeFileID(dirPath string) (uint32, error) {
-	mergeFinishedFile, err := structure.OpenMergeFinishedFile(dirPath)
+	nonMergeFileID, _, err := db.readMergeFinished(dirPath)
+	return nonMergeFileID, err
+}
+
+// readMergeFinished 读取 merge 完成的标识中记录的没有参与 merge 的文件 id，以及 merge 生成的数据文件数量
+// 旧版本没有记录数据文件数量，此时返回 -1
+func (db *DB) readMergeFinished(dirPath string) (uint32, int, error) {
+	mergeFinishedFile, err := structure.OpenMergeFinishedFile(db.fs, dirPath)
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
+	defer mergeFinishedFile.Close()
 
-	record, _, err := mergeFinishedFile.ReadLogRecord(0)
+	record, size, err := mergeFinishedFile.ReadLogRecord(0)
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
-
 	nonMergeFileID, err := strconv.Atoi(string(record.Value))
 	if err != nil {
-		return 0, err
+		return 0, 0, err
+	}
+
+	mergedFileNum := -1
+	record, _, err = mergeFinishedFile.ReadLogRecord(size)
+	if err == nil && string(record.Key) == mergedFilesKey {
+		if mergedFileNum, err = strconv.Atoi(string(record.Value)); err != nil {
+			return 0, 0, err
+		}
 	}
-	return uint32(nonMergeFileID), nil
+	return uint32(nonMergeFileID), mergedFileNum, nil
+}
+
+// parseStorageFileID 从数据文件的名称中解析文件 id
+func parseStorageFileID(fileName string) (int, bool) {
+	if !strings.HasSuffix(fileName, structure.StorageFileNameSuffix) {
+		return 0, false
+	}
+	fileID, err := strconv.Atoi(strings.TrimSuffix(fileName, structure.StorageFileNameSuffix))
+	return fileID, err == nil
 }
 
 // 从 hint 文件中加载索引
 func (db *DB) loadIndexFromHintFile() error {
 	// 查看 hint 索引文件是否存在
 	hintFileName := filepath.Join(db.options.DirPath, structure.HintFileName)
-	if _, err := os.Stat(hintFileName); os.IsNotExist(err) {
+	if _, err := db.fs.Stat(hintFileName); os.IsNotExist(err) {
 		return nil
 	}
 
 	// 打开 hint 索引文件
-	hintFile, err := structure.OpenHintFile(db.options.DirPath)
+	hintFile, err := structure.OpenHintFile(db.fs, db.options.DirPath)
 	if err != nil {
 		return err
 	}
 
 	// 读取文件中的索引
-	var offset int64 = 0
-	for {
-		logRecord, size, err := hintFile.ReadLogRecord(offset)
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return err
-		}
-
+	scanner := hintFile.NewScanner(0)
+	for scanner.Next() {
 		// 解码拿到实际的位置索引
+		logRecord := scanner.Record()
 		pos := structure.DecodeLogRecordPos(logRecord.Value)
-		db.index.Put(logRecord.Key, pos)
-		offset += size
+		if _, err := index.PutWithError(db.index, logRecord.Key, pos); err != nil {
+			return err
+		}
 	}
-	return nil
+	return scanner.Err()
 }
@@ -2,11 +2,14 @@ package db
 
 import (
 	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/tClown11/kv-storage/errs"
+	"github.com/tClown11/kv-storage/fio"
+	"github.com/tClown11/kv-storage/index"
 	"github.com/tClown11/kv-storage/utils"
 )
 
@@ -202,3 +205,66 @@ func TestDB_Merge5(t *testing.T) {
 		assert.NotNil(t, val)
 	}
 }
+
+// 使用 B+ 树索引进行 merge
+func TestDB_Merge_BPTree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-bptree")
+	opts.DataFileSize = 32 * 1024 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.DirPath = dir
+	opts.IndexType = index.BPTree
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 50000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestValue(1024))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 10000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	// merge 使用的临时实例已经关闭，merge 目录的文件锁已经释放
+	lock, hold, err := fio.OS.TryLock(filepath.Join(mergeDirPath(dir), fileLockName))
+	assert.Nil(t, err)
+	assert.True(t, hold)
+	assert.Nil(t, lock.Unlock())
+
+	// merge 之后继续写入
+	for i := 40000; i < 50000; i++ {
+		err := db.Put(utils.GetTestKey(i), []byte("new value after merge"))
+		assert.Nil(t, err)
+	}
+
+	// 重启校验，检查点早于 merge，索引需要重新构建
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	defer func() {
+		_ = db2.Close()
+	}()
+	assert.Nil(t, err)
+	assert.False(t, db2.fromCheckpoint)
+	keys := db2.ListKeys()
+	assert.Equal(t, 40000, len(keys))
+
+	for i := 0; i < 10000; i++ {
+		_, err := db2.Get(utils.GetTestKey(i))
+		assert.Equal(t, errs.ErrKeyNotFound, err)
+	}
+	for i := 10000; i < 50000; i += 100 {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		if i >= 40000 {
+			assert.Equal(t, []byte("new value after merge"), val)
+		}
+	}
+}
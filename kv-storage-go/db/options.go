@@ -2,7 +2,9 @@ package db
 
 import (
 	"os"
+	"time"
 
+	"github.com/tClown11/kv-storage/fio"
 	"github.com/tClown11/kv-storage/index"
 )
 
@@ -19,20 +21,79 @@ type Options struct {
 	// 累计写到多少字节后进行持久化
 	BytesPerSync uint
 
-	// 索引类型
+	// 索引类型，自定义的索引类型需要先通过 index.Register 注册
 	IndexType index.IndexType
 
+	// 已经创建好的索引实例，不为空时忽略 IndexType 和 IndexShards，由 DB 负责关闭
+	Indexer index.Indexer
+
+	// key 的排序方式，影响索引的遍历顺序、Seek 以及范围查询，为空时按照字节序排序
+	// 比较器的名称会记录在数据目录中，之后必须使用相同名称的比较器打开
+	Comparator index.Comparator
+
+	// 内存索引的分片数量，大于 1 时按 key 的哈希值分片，减少并发读写时的锁竞争
+	IndexShards int
+
 	// 启动时是否使用 MMap 加载数据
 	MMapAtStartup bool
 
+	// 数据文件的 IO 类型，默认为标准文件 IO，DirectIO 可以避免数据文件占用页缓存，
+	// BufferedIO 将追加写入合并到缓冲区中，减少系统调用
+	IOType fio.FileIOType
+
+	// BufferedIO 的写缓冲区大小，为 0 时使用 fio.DefaultWriteBufferSize
+	WriteBufferSize int
+
+	// BufferedIO 定时将缓冲区写入文件的间隔，为 0 时只在缓冲区满、Sync 和 Close 时写入
+	WriteFlushInterval time.Duration
+
+	// 是否在创建数据文件时预分配 DataFileSize 大小的磁盘空间，只有标准文件 IO 支持，其他 IO 类型忽略
+	// 启动时通过最后一条有效的记录确定写入的位置，文件写满或者关闭时截断到实际的大小
+	PreallocateDataFiles bool
+
+	// IO 限速，单位为字节每秒，为 0 时不限速，可以在运行时通过 DB.SetWriteRateLimit 等方法修改
+	// 前台写入的限速在获取锁之前等待，不会阻塞读取
+	WriteBytesPerSec int64
+
+	// merge 读取旧数据文件以及写入新数据文件、hint 文件的限速
+	MergeReadBytesPerSec  int64
+	MergeWriteBytesPerSec int64
+
+	// 备份读取数据目录以及写入备份目录的限速
+	BackupReadBytesPerSec  int64
+	BackupWriteBytesPerSec int64
+
+	// 同时打开的旧数据文件的最大数量，超过时关闭最久没有读取的文件，之后读取时重新打开，为 0 时不限制
+	// 活跃文件不计算在内，始终保持打开
+	MaxOpenFiles int
+
+	// 启动时并行解析数据文件构建索引的并发数，为 0 时使用 GOMAXPROCS
+	IndexLoadConcurrency int
+
 	//	数据文件合并的阈值
 	DataFileMergeRatio float32
+
+	// 是否将数据全部保存在内存中，不访问文件系统，适用于测试以及临时的缓存
+	// FileSystem 为空时每次打开都会使用新的内存文件系统，关闭之后数据丢失，Merge 的结果直接在当前实例中加载
+	InMemory bool
+
+	// 数据目录所在的文件系统，为空时使用操作系统的文件系统
+	// 使用同一个 fio.MemFileSystem 重新打开可以读取到之前写入的数据
+	FileSystem fio.FileSystem
+
+	// 数据加密使用的密钥，不为空时数据文件、hint 索引文件等全部使用 AES-GCM 加密后写入
+	// 之后必须使用能够提供相同密钥的 KeyProvider 打开，可以使用 RotateKeys 离线更换密钥
+	KeyProvider fio.KeyProvider
 }
 
 // IteratorOptions 索引迭代器配置项
 type IteratorOptions struct {
 	// 遍历前缀为指定值的 Key，默认为空
 	Prefix []byte
+	// 遍历范围的下界( 包含 )，默认为空表示没有下界
+	LowerBound []byte
+	// 遍历范围的上界( 不包含 )，默认为空表示没有上界
+	UpperBound []byte
 	// 是否反向遍历，默认 false 是正向
 	Reverse bool
 }
@@ -52,7 +113,9 @@ var DefaultOptions = Options{
 	SyncWrites:         false,
 	BytesPerSync:       0,
 	IndexType:          index.BTree,
+	Comparator:         index.BytewiseComparator,
 	MMapAtStartup:      false,
+	IOType:             fio.StandardFIO,
 	DataFileMergeRatio: 0.5,
 }
 
@@ -0,0 +1,32 @@
+package db
+
+import "github.com/tClown11/kv-storage/errs"
+
+// SetWriteRateLimit 修改前台写入的限速，单位为字节每秒，为 0 时不限速
+func (db *DB) SetWriteRateLimit(bytesPerSec int64) error {
+	if bytesPerSec < 0 {
+		return errs.ErrNegativeRateLimit
+	}
+	db.writeLimiter.SetRate(bytesPerSec)
+	return nil
+}
+
+// SetMergeRateLimit 修改 merge 读写的限速，正在执行的 merge 立即生效
+func (db *DB) SetMergeRateLimit(readBytesPerSec, writeBytesPerSec int64) error {
+	if readBytesPerSec < 0 || writeBytesPerSec < 0 {
+		return errs.ErrNegativeRateLimit
+	}
+	db.mergeReadLimiter.SetRate(readBytesPerSec)
+	db.mergeWriteLimiter.SetRate(writeBytesPerSec)
+	return nil
+}
+
+// SetBackupRateLimit 修改备份读写的限速，正在执行的备份立即生效
+func (db *DB) SetBackupRateLimit(readBytesPerSec, writeBytesPerSec int64) error {
+	if readBytesPerSec < 0 || writeBytesPerSec < 0 {
+		return errs.ErrNegativeRateLimit
+	}
+	db.backupReadLimiter.SetRate(readBytesPerSec)
+	db.backupWriteLimiter.SetRate(writeBytesPerSec)
+	return nil
+}
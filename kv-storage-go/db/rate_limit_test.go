@@ -0,0 +1,123 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tClown11/kv-storage/utils"
+)
+
+func TestDB_BackupRateLimit(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = filepath.Join(t.TempDir(), "bitcask-go-backup-rate-limit")
+	opts.DataFileSize = 64 * 1024
+	opts.BackupWriteBytesPerSec = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+
+	// 大约 175KB 的数据，除去令牌桶中积累的令牌和最后一次读取，至少需要等待 0.7 秒
+	start := time.Now()
+	assert.Nil(t, db.Backup(filepath.Join(t.TempDir(), "backup-limited")))
+	assert.True(t, time.Since(start) >= 500*time.Millisecond)
+
+	// 备份过程中不阻塞写入
+	done := make(chan error)
+	go func() {
+		done <- db.Backup(filepath.Join(t.TempDir(), "backup-writes"))
+	}()
+	time.Sleep(100 * time.Millisecond)
+	start = time.Now()
+	assert.Nil(t, db.Put(utils.GetTestKey(1000), utils.GetTestValue(128)))
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+	// 运行时取消限速，正在执行的备份很快完成
+	assert.Nil(t, db.SetBackupRateLimit(0, 0))
+	select {
+	case err := <-done:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("the backup is still throttled after the rate limit is removed")
+	}
+
+	backupDir := filepath.Join(t.TempDir(), "backup")
+	assert.Nil(t, db.Backup(backupDir))
+	backupOpts := DefaultOptions
+	backupOpts.DirPath = backupDir
+	db2, err := Open(backupOpts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 1001, len(db2.ListKeys()))
+}
+
+func TestDB_MergeRateLimit(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = filepath.Join(t.TempDir(), "bitcask-go-merge-rate-limit")
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.MergeReadBytesPerSec = 16 * 1024
+	opts.MergeWriteBytesPerSec = 16 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- db.Merge()
+	}()
+	time.Sleep(200 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("the merge is not throttled")
+	default:
+	}
+	assert.Nil(t, db.SetMergeRateLimit(0, 0))
+	select {
+	case err := <-done:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("the merge is still throttled after the rate limit is removed")
+	}
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(db.ListKeys()))
+}
+
+func TestDB_WriteRateLimit(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = filepath.Join(t.TempDir(), "bitcask-go-write-rate-limit")
+	opts.WriteBytesPerSec = 10 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(200)))
+	}
+	assert.True(t, time.Since(start) >= time.Second)
+
+	assert.NotNil(t, db.SetWriteRateLimit(-1))
+	assert.Nil(t, db.SetWriteRateLimit(0))
+	start = time.Now()
+	for i := 100; i < 200; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(200)))
+	}
+	assert.True(t, time.Since(start) < time.Second)
+
+	negOpts := DefaultOptions
+	negOpts.DirPath, _ = os.MkdirTemp("", "bitcask-go-negative-rate-limit")
+	negOpts.MergeReadBytesPerSec = -1
+	_, err = Open(negOpts)
+	assert.NotNil(t, err)
+}
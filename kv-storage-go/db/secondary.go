@@ -0,0 +1,244 @@
+package db
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/google/btree"
+	"github.com/tClown11/kv-storage/errs"
+)
+
+// 二级索引 BTree 的阶数
+const defaultSecondaryDegree = 32
+
+// SecondaryKeyExtractor 从一条数据中提取二级索引的 key，返回空表示这条数据不需要建立二级索引
+type SecondaryKeyExtractor func(key, value []byte) [][]byte
+
+// secondaryEntry 二级索引中的单个数据对象，按照二级索引 key、主键的顺序排列
+type secondaryEntry struct {
+	secondaryKey []byte
+	primaryKey   []byte
+}
+
+func secondaryEntryLess(a, b secondaryEntry) bool {
+	if c := bytes.Compare(a.secondaryKey, b.secondaryKey); c != 0 {
+		return c < 0
+	}
+	return bytes.Compare(a.primaryKey, b.primaryKey) < 0
+}
+
+// secondaryIndex 二级索引，只保存在内存中
+type secondaryIndex struct {
+	extractor SecondaryKeyExtractor
+	tree      *btree.BTreeG[secondaryEntry]
+	keys      map[string][][]byte // 主键对应的二级索引 key，更新和删除时用于清理旧的数据
+}
+
+func newSecondaryIndex(extractor SecondaryKeyExtractor) *secondaryIndex {
+	return &secondaryIndex{
+		extractor: extractor,
+		tree:      btree.NewG(defaultSecondaryDegree, secondaryEntryLess),
+		keys:      make(map[string][][]byte),
+	}
+}
+
+// put 写入主键对应的数据，会先清理主键之前的二级索引 key
+func (si *secondaryIndex) put(key, value []byte) {
+	si.delete(key)
+
+	secondaryKeys := si.extractor(key, value)
+	if len(secondaryKeys) == 0 {
+		return
+	}
+	primaryKey := append([]byte(nil), key...)
+	copied := make([][]byte, 0, len(secondaryKeys))
+	for _, secondaryKey := range secondaryKeys {
+		secondaryKey = append([]byte(nil), secondaryKey...)
+		si.tree.ReplaceOrInsert(secondaryEntry{secondaryKey: secondaryKey, primaryKey: primaryKey})
+		copied = append(copied, secondaryKey)
+	}
+	si.keys[string(key)] = copied
+}
+
+// delete 删除主键对应的所有二级索引 key
+func (si *secondaryIndex) delete(key []byte) {
+	secondaryKeys, ok := si.keys[string(key)]
+	if !ok {
+		return
+	}
+	for _, secondaryKey := range secondaryKeys {
+		si.tree.Delete(secondaryEntry{secondaryKey: secondaryKey, primaryKey: key})
+	}
+	delete(si.keys, string(key))
+}
+
+// CreateSecondaryIndex 创建二级索引，extractor 用于从数据中提取二级索引的 key
+// 创建时会遍历全部数据构建索引，之后随着 Put、Delete 和 WriteBatch 的提交原子地更新。
+// 二级索引只保存在内存中，每次 Open 之后需要重新创建，Merge 不会改变数据，不需要重建
+func (db *DB) CreateSecondaryIndex(name string, extractor SecondaryKeyExtractor) error {
+	if name == "" || extractor == nil {
+		return errs.ErrSecondaryIndexInvalid
+	}
+
+	// 持有写入锁，构建期间主索引与已经写入的数据一致
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.secondaryIndexes[name]; ok {
+		return errs.ErrSecondaryIndexExists
+	}
+
+	si := newSecondaryIndex(extractor)
+	iterator := db.index.Iterator(false)
+	defer iterator.Close()
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		value, err := db.getValueByPosition(iterator.Value())
+		if err != nil {
+			return err
+		}
+		si.put(iterator.Key(), value)
+	}
+	db.secondaryIndexes[name] = si
+	return nil
+}
+
+// DropSecondaryIndex 删除二级索引
+func (db *DB) DropSecondaryIndex(name string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.secondaryIndexes[name]; !ok {
+		return errs.ErrSecondaryIndexNotFound
+	}
+	delete(db.secondaryIndexes, name)
+	return nil
+}
+
+// LookupBy 根据二级索引 key 查找对应的所有主键，主键按照字节序排列
+func (db *DB) LookupBy(name string, secondaryKey []byte) ([][]byte, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	si, ok := db.secondaryIndexes[name]
+	if !ok {
+		return nil, errs.ErrSecondaryIndexNotFound
+	}
+
+	var keys [][]byte
+	si.tree.AscendGreaterOrEqual(secondaryEntry{secondaryKey: secondaryKey}, func(entry secondaryEntry) bool {
+		if !bytes.Equal(entry.secondaryKey, secondaryKey) {
+			return false
+		}
+		keys = append(keys, entry.primaryKey)
+		return true
+	})
+	return keys, nil
+}
+
+// putSecondary 更新所有二级索引，调用方需要持有写锁
+func (db *DB) putSecondary(key, value []byte) {
+	for _, si := range db.secondaryIndexes {
+		si.put(key, value)
+	}
+}
+
+// deleteSecondary 从所有二级索引中删除主键，调用方需要持有写锁
+func (db *DB) deleteSecondary(key []byte) {
+	for _, si := range db.secondaryIndexes {
+		si.delete(key)
+	}
+}
+
+// SecondaryIterator 二级索引迭代器，按照二级索引 key 的字节序遍历
+// 基于创建时的快照，之后的写入不可见
+type SecondaryIterator struct {
+	db        *DB
+	reverse   bool
+	currIndex int
+	entries   []secondaryEntry
+}
+
+// NewSecondaryIterator 初始化二级索引迭代器，配置项中的前缀和上下界作用于二级索引 key
+func (db *DB) NewSecondaryIterator(name string, opts IteratorOptions) (*SecondaryIterator, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	si, ok := db.secondaryIndexes[name]
+	if !ok {
+		return nil, errs.ErrSecondaryIndexNotFound
+	}
+
+	lower, upper := bytewiseBounds(opts)
+	var entries []secondaryEntry
+	saveEntries := func(entry secondaryEntry) bool {
+		if upper != nil && bytes.Compare(entry.secondaryKey, upper) >= 0 {
+			return false
+		}
+		entries = append(entries, entry)
+		return true
+	}
+	if lower != nil {
+		si.tree.AscendGreaterOrEqual(secondaryEntry{secondaryKey: lower}, saveEntries)
+	} else {
+		si.tree.Ascend(saveEntries)
+	}
+	if opts.Reverse {
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+	}
+
+	return &SecondaryIterator{
+		db:      db,
+		reverse: opts.Reverse,
+		entries: entries,
+	}, nil
+}
+
+// Rewind 重新回到迭代器的起点
+func (it *SecondaryIterator) Rewind() {
+	it.currIndex = 0
+}
+
+// Seek 查找第一个大于( 或小于 ) 等于 secondaryKey 的二级索引 key，从这个位置开始遍历
+func (it *SecondaryIterator) Seek(secondaryKey []byte) {
+	it.currIndex = sort.Search(len(it.entries), func(i int) bool {
+		c := bytes.Compare(it.entries[i].secondaryKey, secondaryKey)
+		if it.reverse {
+			return c <= 0
+		}
+		return c >= 0
+	})
+}
+
+// Next 跳转到下一条数据
+func (it *SecondaryIterator) Next() {
+	it.currIndex += 1
+}
+
+// Valid 是否还能继续遍历
+func (it *SecondaryIterator) Valid() bool {
+	return it.currIndex < len(it.entries)
+}
+
+// Key 当前位置的二级索引 key
+func (it *SecondaryIterator) Key() []byte {
+	return it.entries[it.currIndex].secondaryKey
+}
+
+// PrimaryKey 当前位置的主键
+func (it *SecondaryIterator) PrimaryKey() []byte {
+	return it.entries[it.currIndex].primaryKey
+}
+
+// Value 当前位置的主键对应的最新数据
+func (it *SecondaryIterator) Value() ([]byte, error) {
+	return it.db.Get(it.PrimaryKey())
+}
+
+// Close 关闭迭代器，释放相应资源
+func (it *SecondaryIterator) Close() {
+	it.entries = nil
+}
@@ -0,0 +1,147 @@
+package db
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tClown11/kv-storage/errs"
+)
+
+// emailExtractor 数据格式为 "email1,email2|name"，按照 email 建立二级索引
+func emailExtractor(key, value []byte) [][]byte {
+	emails, _, _ := bytes.Cut(value, []byte("|"))
+	if len(emails) == 0 {
+		return nil
+	}
+	return bytes.Split(emails, []byte(","))
+}
+
+func lookupKeys(t *testing.T, db *DB, secondaryKey string) []string {
+	keys, err := db.LookupBy("email", []byte(secondaryKey))
+	assert.Nil(t, err)
+	var res []string
+	for _, key := range keys {
+		res = append(res, string(key))
+	}
+	return res
+}
+
+func TestDB_SecondaryIndex(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "test_secondary_index")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 创建之前已经存在的数据
+	err = db.Put([]byte("user-1"), []byte("a@x.com|alice"))
+	assert.Nil(t, err)
+	err = db.Put([]byte("user-2"), []byte("b@x.com,shared@x.com|bob"))
+	assert.Nil(t, err)
+
+	err = db.CreateSecondaryIndex("email", emailExtractor)
+	assert.Nil(t, err)
+	err = db.CreateSecondaryIndex("email", emailExtractor)
+	assert.Equal(t, errs.ErrSecondaryIndexExists, err)
+	err = db.CreateSecondaryIndex("", emailExtractor)
+	assert.Equal(t, errs.ErrSecondaryIndexInvalid, err)
+	_, err = db.LookupBy("name", []byte("alice"))
+	assert.Equal(t, errs.ErrSecondaryIndexNotFound, err)
+
+	assert.Equal(t, []string{"user-1"}, lookupKeys(t, db, "a@x.com"))
+	assert.Equal(t, []string{"user-2"}, lookupKeys(t, db, "shared@x.com"))
+
+	// Put 更新二级索引，旧的二级索引 key 被清理
+	err = db.Put([]byte("user-3"), []byte("shared@x.com|carol"))
+	assert.Nil(t, err)
+	err = db.Put([]byte("user-1"), []byte("a2@x.com|alice"))
+	assert.Nil(t, err)
+	assert.Nil(t, lookupKeys(t, db, "a@x.com"))
+	assert.Equal(t, []string{"user-1"}, lookupKeys(t, db, "a2@x.com"))
+	assert.Equal(t, []string{"user-2", "user-3"}, lookupKeys(t, db, "shared@x.com"))
+
+	// Delete 删除二级索引
+	err = db.Delete([]byte("user-2"))
+	assert.Nil(t, err)
+	assert.Nil(t, lookupKeys(t, db, "b@x.com"))
+	assert.Equal(t, []string{"user-3"}, lookupKeys(t, db, "shared@x.com"))
+
+	// WriteBatch 提交时更新二级索引
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	err = wb.Put([]byte("user-4"), []byte("d@x.com|dave"))
+	assert.Nil(t, err)
+	err = wb.Delete([]byte("user-3"))
+	assert.Nil(t, err)
+	assert.Nil(t, lookupKeys(t, db, "d@x.com"))
+	err = wb.Commit()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"user-4"}, lookupKeys(t, db, "d@x.com"))
+	assert.Nil(t, lookupKeys(t, db, "shared@x.com"))
+
+	err = db.DropSecondaryIndex("email")
+	assert.Nil(t, err)
+	err = db.DropSecondaryIndex("email")
+	assert.Equal(t, errs.ErrSecondaryIndexNotFound, err)
+}
+
+func TestDB_SecondaryIterator(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "test_secondary_iterator")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for key, value := range map[string]string{
+		"user-1": "a@x.com|alice",
+		"user-2": "b@x.com,a@y.com|bob",
+		"user-3": "c@y.com|carol",
+		"user-4": "|nobody",
+	} {
+		err = db.Put([]byte(key), []byte(value))
+		assert.Nil(t, err)
+	}
+	err = db.CreateSecondaryIndex("email", emailExtractor)
+	assert.Nil(t, err)
+	_, err = db.NewSecondaryIterator("name", DefaultIteratorOptions)
+	assert.Equal(t, errs.ErrSecondaryIndexNotFound, err)
+
+	collect := func(opts IteratorOptions) []string {
+		iter, err := db.NewSecondaryIterator("email", opts)
+		assert.Nil(t, err)
+		defer iter.Close()
+		var res []string
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			res = append(res, string(iter.Key())+"="+string(iter.PrimaryKey()))
+		}
+		return res
+	}
+	assert.Equal(t, []string{"a@x.com=user-1", "a@y.com=user-2", "b@x.com=user-2", "c@y.com=user-3"}, collect(DefaultIteratorOptions))
+	assert.Equal(t, []string{"a@y.com=user-2", "a@x.com=user-1"}, collect(IteratorOptions{Prefix: []byte("a@"), Reverse: true}))
+	assert.Equal(t, []string{"a@y.com=user-2", "b@x.com=user-2"}, collect(IteratorOptions{LowerBound: []byte("a@y"), UpperBound: []byte("c")}))
+
+	iter, err := db.NewSecondaryIterator("email", DefaultIteratorOptions)
+	assert.Nil(t, err)
+	iter.Seek([]byte("b"))
+	assert.Equal(t, []byte("b@x.com"), iter.Key())
+	value, err := iter.Value()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b@x.com,a@y.com|bob"), value)
+	iter.Close()
+
+	// 重新打开之后需要重新创建二级索引
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	_, err = db.LookupBy("email", []byte("a@x.com"))
+	assert.Equal(t, errs.ErrSecondaryIndexNotFound, err)
+	err = db.CreateSecondaryIndex("email", emailExtractor)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"user-3"}, lookupKeys(t, db, "c@y.com"))
+}
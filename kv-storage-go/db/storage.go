@@ -1,20 +1,20 @@
 package db
 
 import (
-	"io"
-	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/tClown11/kv-storage/errs"
 	"github.com/tClown11/kv-storage/fio"
+	"github.com/tClown11/kv-storage/index"
 	"github.com/tClown11/kv-storage/structure"
 )
 
 func (db *DB) loadStorageFiles() error {
-	dirEntries, err := os.ReadDir(db.options.DirPath)
+	dirEntries, err := db.fs.ReadDir(db.options.DirPath)
 	if err != nil {
 		return err
 	}
@@ -39,28 +39,69 @@ func (db *DB) loadStorageFiles() error {
 
 	// 遍历每个文件ID，打开对应的数据文件
 	for i, fid := range fileIDs {
-		ioType := fio.StandardFIO
+		ioType := db.options.IOType
 		if db.options.MMapAtStartup {
 			ioType = fio.MemoryMap
 		}
 
-		dataFile, err := structure.OpenStorageFile(db.options.DirPath, uint32(fid), ioType)
-		if err != nil {
-			return err
-		}
-
 		if i == len(fileIDs)-1 { // 最后一个 ID 是最大的，说明是当前活跃文件
+			dataFile, err := db.openStorageFile(uint32(fid), ioType)
+			if err != nil {
+				return err
+			}
 			db.activeFile = dataFile
-		} else { // 说明是旧的文件
+		} else if db.fileCache != nil { // 说明是旧的文件，限制了打开文件的数量时在读取时才打开
+			db.olderFiles[uint32(fid)] = structure.NewStorageFile(uint32(fid), db.cachedIOManager(uint32(fid), ioType, nil))
+		} else {
+			dataFile, err := db.openStorageFile(uint32(fid), ioType)
+			if err != nil {
+				return err
+			}
 			db.olderFiles[uint32(fid)] = dataFile
 		}
 	}
+	db.publishFiles()
 	return nil
 }
 
+// loadIndexCheckpoint 获取持久化索引的检查点，返回 nil 表示需要从 hint 文件和数据文件中加载全部的索引
+func (db *DB) loadIndexCheckpoint() (*structure.LogRecordPos, error) {
+	persistent, ok := db.index.(index.Persistent)
+	if !ok {
+		return nil, nil
+	}
+	checkpoint := persistent.CheckpointPos()
+	if checkpoint == nil {
+		return nil, nil
+	}
+
+	// 检查点对应的数据文件必须存在，且检查点之后没有发生过 merge，否则索引中的位置信息已经失效
+	var valid bool
+	for _, fid := range db.fileIDs {
+		if uint32(fid) == checkpoint.Fid {
+			valid = !db.mergeLoaded
+			break
+		}
+	}
+	mergeFinFileName := filepath.Join(db.options.DirPath, structure.MergeFinishedfileName)
+	if _, err := db.fs.Stat(mergeFinFileName); valid && err == nil {
+		nonMergeFileID, err := db.getNonMergeFileID(db.options.DirPath)
+		if err != nil {
+			return nil, err
+		}
+		valid = checkpoint.Fid >= nonMergeFileID
+	}
+	if !valid {
+		return nil, persistent.Reset()
+	}
+
+	db.fromCheckpoint = true
+	return checkpoint, nil
+}
+
 // 从数据文件中加载索引
-// 遍历文件中的所有记录，并更新到内存索引中
-func (db *DB) loadIndexFromStorageFiles() error {
+// 遍历文件中的所有记录，并更新到内存索引中，checkpoint 不为空时只加载检查点之后的记录
+func (db *DB) loadIndexFromStorageFiles(checkpoint *structure.LogRecordPos) error {
 	// 没有文件，说明数据库是空的，直接返回
 	if len(db.fileIDs) == 0 {
 		return nil
@@ -69,7 +110,7 @@ func (db *DB) loadIndexFromStorageFiles() error {
 	// 查看是否发生过 merge
 	hasMerge, nonMergeFileID := false, uint32(0)
 	mergeFinFileName := filepath.Join(db.options.DirPath, structure.MergeFinishedfileName)
-	if _, err := os.Stat(mergeFinFileName); err == nil {
+	if _, err := db.fs.Stat(mergeFinFileName); err == nil {
 		fid, err := db.getNonMergeFileID(db.options.DirPath)
 		if err != nil {
 			return err
@@ -78,77 +119,158 @@ func (db *DB) loadIndexFromStorageFiles() error {
 		nonMergeFileID = fid
 	}
 
-	// 遍历所有的文件 id， 处理文件中的记录
-	for i, fid := range db.fileIDs {
+	// 找出需要加载的文件以及开始加载的位置
+	var tasks []*loadTask
+	for _, fid := range db.fileIDs {
 		var fileID = uint32(fid)
 		// 如果比最近未参与 merge 的文件 id 更小，则说明已经从 Hint 文件中加载索引了
 		if hasMerge && fileID < nonMergeFileID {
 			continue
 		}
+		// 检查点之前的数据已经在持久化索引中了
+		var offset int64
+		if checkpoint != nil {
+			if fileID < checkpoint.Fid {
+				continue
+			}
+			if fileID == checkpoint.Fid {
+				offset = checkpoint.Offset
+			}
+		}
 		var storageFile *structure.StorageFile
 		if fileID == db.activeFile.FileID {
 			storageFile = db.activeFile
 		} else {
 			storageFile = db.olderFiles[fileID]
 		}
+		tasks = append(tasks, &loadTask{fileID: fileID, file: storageFile, offset: offset, result: make(chan *loadResult, 1)})
+	}
+	if len(tasks) == 0 {
+		return nil
+	}
 
-		offset, err := db.writeCache(fileID, storageFile)
-		if err != nil {
+	concurrency := db.options.IndexLoadConcurrency
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+
+	// 多个文件并行解析，按照文件 id 的顺序依次更新索引，保证后写入的数据覆盖先写入的数据
+	// 已经解析但还没有更新到索引中的文件最多 concurrency 个，避免占用过多的内存
+	activeFileID := db.activeFile.FileID
+	tokens := make(chan struct{}, concurrency)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for _, task := range tasks {
+			select {
+			case tokens <- struct{}{}:
+			case <-done:
+				return
+			}
+			go func(task *loadTask) {
+				ops, offset, seqID, err := parseStorageFile(task.fileID, task.file, task.offset, task.fileID == activeFileID)
+				task.result <- &loadResult{ops: ops, offset: offset, seqID: seqID, err: err}
+			}(task)
+		}
+	}()
+
+	for _, task := range tasks {
+		result := <-task.result
+		<-tokens
+		if result.err != nil {
+			return result.err
+		}
+		if err := db.applyIndexOps(result.ops); err != nil {
 			return err
 		}
 
-		if i == len(db.fileIDs)-1 {
-			db.activeFile.WriteOff = int64(offset)
+		// 更新事务序列号，序列号只会递增
+		if result.seqID > db.seqNo {
+			db.seqNo = result.seqID
+		}
+		if task.fileID == db.activeFile.FileID {
+			db.activeFile.WriteOff = result.offset
 		}
 	}
 	return nil
 }
 
-// writeCache 将文件中的数据解析到结构体中，并更新 index 数据
-func (db *DB) writeCache(fileID uint32, file *structure.StorageFile) (int64, error) {
-	var offset int64 = 0
-	var currentSeqID = nonTransactionSeqNo
-	transationRecords := make(map[uint64][]*structure.TransactionRecord)
+// loadTask 加载索引时需要解析的数据文件
+type loadTask struct {
+	fileID uint32
+	file   *structure.StorageFile
+	offset int64 // 开始解析的位置
+	result chan *loadResult
+}
+
+// loadResult 数据文件的解析结果
+type loadResult struct {
+	ops    []*indexOp
+	offset int64  // 解析结束的位置
+	seqID  uint64 // 文件中最大的事务序列号
+	err    error
+}
 
-	updateIndex := func(key []byte, typ structure.LogRecordType, pos *structure.LogRecordPos) {
-		var oldPos *structure.LogRecordPos
-		if typ == structure.LogRecordDeleted {
-			oldPos, _ = db.index.Delete(key)
-			db.reclaimSize += int64(pos.Size)
+// indexOp 解析数据文件得到的索引更新操作
+type indexOp struct {
+	key []byte
+	typ structure.LogRecordType
+	pos *structure.LogRecordPos
+}
+
+// applyIndexOps 将解析得到的操作依次更新到索引中
+// 索引不支持数据中的 key 或者读写出错时返回错误，例如其他索引类型写入的 key 超过了 B+ 树索引支持的长度
+func (db *DB) applyIndexOps(ops []*indexOp) error {
+	for _, op := range ops {
+		var (
+			oldPos *structure.LogRecordPos
+			err    error
+		)
+		if op.typ == structure.LogRecordDeleted {
+			oldPos, _, err = index.DeleteWithError(db.index, op.key)
+			db.reclaimSize += int64(op.pos.Size)
 		} else {
-			oldPos = db.index.Put(key, pos)
+			oldPos, err = index.PutWithError(db.index, op.key, op.pos)
+		}
+		if err != nil {
+			return err
 		}
 		if oldPos != nil {
 			db.reclaimSize += int64(oldPos.Size)
 		}
 	}
+	return nil
+}
 
-	for {
-		logRecord, size, err := file.ReadLogRecord(offset)
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return offset, err
-		}
+// parseStorageFile 解析文件中从 offset 开始的数据，得到需要按顺序更新到索引中的操作
+// 事务中的数据在读取到事务完成的标识之后才会生效
+// 活跃文件末尾可能有崩溃时没有完整写入的记录，校验失败时认为已经解析到了有效数据的末尾
+func parseStorageFile(fileID uint32, file *structure.StorageFile, offset int64, isActive bool) ([]*indexOp, int64, uint64, error) {
+	var currentSeqID = nonTransactionSeqNo
+	var ops []*indexOp
+	transationRecords := make(map[uint64][]*structure.TransactionRecord)
+
+	scanner := file.NewScanner(offset)
+	for scanner.Next() {
+		logRecord := scanner.Record()
 
 		// 构造内存索引并保存
 		logRecordPos := &structure.LogRecordPos{
 			Fid:    fileID,
-			Offset: int64(offset),
-			Size:   uint32(size),
+			Offset: scanner.Offset(),
+			Size:   uint32(scanner.Size()),
 		}
 
 		// 解析 key，拿到事务序列号
 		realKey, seqID := structure.ParseKeyAndSeqFromLogRecordKey(logRecord.Key)
 		if seqID == nonTransactionSeqNo {
 			// 非事务操作, 直接更新内存索引
-			updateIndex(realKey, logRecord.Type, logRecordPos)
+			ops = append(ops, &indexOp{key: realKey, typ: logRecord.Type, pos: logRecordPos})
 		} else {
 			// 事务完成，对应的 seq no 的数据可以更新到内存索引中
 			if logRecord.Type == structure.LogRecordTxnFinished {
 				for _, txnRecord := range transationRecords[seqID] {
-					updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
+					ops = append(ops, &indexOp{key: txnRecord.Record.Key, typ: txnRecord.Record.Type, pos: txnRecord.Pos})
 				}
 				delete(transationRecords, seqID)
 			} else {
@@ -164,13 +286,9 @@ func (db *DB) writeCache(fileID uint32, file *structure.StorageFile) (int64, err
 		if seqID > currentSeqID {
 			currentSeqID = seqID
 		}
-
-		// 递增 offset，下一次从新的位置开始读取
-		offset += size
 	}
-
-	// 更新事务序列号
-	db.seqNo = currentSeqID
-
-	return offset, nil
+	if err := scanner.Err(); err != nil && !(isActive && err == errs.ErrInvalidCRC) {
+		return nil, scanner.Offset(), 0, err
+	}
+	return ops, scanner.Offset(), currentSeqID, nil
 }
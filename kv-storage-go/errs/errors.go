@@ -4,7 +4,9 @@ import "errors"
 
 var (
 	ErrKeyIsEmpty             = errors.New("the key is empty")
+	ErrKeyTooLarge            = errors.New("the key is too large for the index type")
 	ErrIndexUpdateFailed      = errors.New("failed to update index")
+	ErrIndexTypeUnsupported   = errors.New("unsupported index type")
 	ErrKeyNotFound            = errors.New("key not found in database")
 	ErrDataFileNotFound       = errors.New("data file is not found")
 	ErrDataDirectoryCorrupted = errors.New("the database directory maybe corrupted")
@@ -13,6 +15,12 @@ var (
 	ErrDatabaseIsUsing        = errors.New("the database directory is used by another process")
 	ErrMergeRatioUnreached    = errors.New("the merge ratio do not reach the option")
 	ErrNoEnoughSpaceForMerge  = errors.New("no enough disk space for merge")
+	ErrSecondaryIndexInvalid  = errors.New("the secondary index name or extractor is empty")
+	ErrSecondaryIndexExists   = errors.New("the secondary index already exists")
+	ErrSecondaryIndexNotFound = errors.New("secondary index not found")
+	ErrComparatorMismatch     = errors.New("the comparator does not match the one the database was created with")
+	ErrTruncateUnsupported    = errors.New("the io manager does not support truncating files")
+	ErrNegativeRateLimit      = errors.New("io rate limits must not be negative")
 
 	// crc error
 	ErrInvalidCRC = errors.New("invalid crc value, log record maybe corrupted")
@@ -0,0 +1,199 @@
+package fio
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultWriteBufferSize 默认的写缓冲区大小
+	DefaultWriteBufferSize = 64 * 1024
+
+	// DefaultFlushInterval 默认的定时刷盘间隔
+	DefaultFlushInterval = 100 * time.Millisecond
+)
+
+// BufferedFileIO 带写缓冲的文件 IO
+// 追加写入的数据先合并到用户态的缓冲区中，缓冲区满、Sync、Close 或者到达刷盘间隔时才写入文件，
+// 减少系统调用的次数。读取时会合并缓冲区中还没有写入文件的数据。
+// 进程崩溃时缓冲区中的数据会丢失
+type BufferedFileIO struct {
+	fd      *os.File
+	mu      sync.Mutex
+	buf     []byte // 还没有写入文件的数据
+	flushed int64  // 已经写入文件的数据大小
+	err     error  // 后台刷盘时发生的错误，在下一次写入或者 Sync 时返回
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+// NewBufferedIOManager 打开带写缓冲的文件，flushInterval 大于 0 时在后台定时将缓冲区写入文件
+func NewBufferedIOManager(fileName string, bufferSize int, flushInterval time.Duration) (*BufferedFileIO, error) {
+	fd, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR|os.O_APPEND, DataFilePerm)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	if bufferSize <= 0 {
+		bufferSize = DefaultWriteBufferSize
+	}
+
+	bio := &BufferedFileIO{
+		fd:      fd,
+		buf:     make([]byte, 0, bufferSize),
+		flushed: stat.Size(),
+	}
+	if flushInterval > 0 {
+		bio.stop = make(chan struct{})
+		bio.done = make(chan struct{})
+		go bio.flushLoop(flushInterval)
+	}
+	return bio, nil
+}
+
+// Read 从文件的给定位置读取对应的数据，包括缓冲区中的数据
+func (bio *BufferedFileIO) Read(buf []byte, offset int64) (int, error) {
+	bio.mu.Lock()
+	defer bio.mu.Unlock()
+
+	var n int
+	if offset < bio.flushed {
+		end := min(bio.flushed, offset+int64(len(buf)))
+		read, err := bio.fd.ReadAt(buf[:end-offset], offset)
+		if err != nil {
+			return read, err
+		}
+		n = read
+	}
+	if n < len(buf) {
+		bufOffset := offset + int64(n) - bio.flushed
+		if bufOffset < int64(len(bio.buf)) {
+			n += copy(buf[n:], bio.buf[bufOffset:])
+		}
+	}
+	if n < len(buf) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Write 追加写入字节数组到缓冲区中
+func (bio *BufferedFileIO) Write(data []byte) (int, error) {
+	bio.mu.Lock()
+	defer bio.mu.Unlock()
+
+	if bio.err != nil {
+		return 0, bio.err
+	}
+	if len(bio.buf)+len(data) > cap(bio.buf) {
+		if err := bio.flush(); err != nil {
+			return 0, err
+		}
+	}
+	// 超过缓冲区大小的数据直接写入文件
+	if len(data) > cap(bio.buf) {
+		n, err := bio.fd.Write(data)
+		bio.flushed += int64(n)
+		return n, err
+	}
+	bio.buf = append(bio.buf, data...)
+	return len(data), nil
+}
+
+// Sync 将缓冲区写入文件并持久化
+func (bio *BufferedFileIO) Sync() error {
+	bio.mu.Lock()
+	defer bio.mu.Unlock()
+
+	if err := bio.flush(); err != nil {
+		return err
+	}
+	return bio.fd.Sync()
+}
+
+// Close 将缓冲区写入文件并关闭文件
+func (bio *BufferedFileIO) Close() error {
+	if bio.stop != nil {
+		bio.once.Do(func() {
+			close(bio.stop)
+			<-bio.done
+		})
+	}
+
+	bio.mu.Lock()
+	defer bio.mu.Unlock()
+	if err := bio.flush(); err != nil {
+		_ = bio.fd.Close()
+		return err
+	}
+	return bio.fd.Close()
+}
+
+// Size 获取文件大小，包括缓冲区中的数据
+func (bio *BufferedFileIO) Size() (int64, error) {
+	bio.mu.Lock()
+	defer bio.mu.Unlock()
+	return bio.flushed + int64(len(bio.buf)), nil
+}
+
+// Truncate 将文件截断到 size 大小，size 之后还在缓冲区中的数据直接丢弃
+func (bio *BufferedFileIO) Truncate(size int64) error {
+	bio.mu.Lock()
+	defer bio.mu.Unlock()
+
+	if size >= bio.flushed {
+		bio.buf = bio.buf[:min(size-bio.flushed, int64(len(bio.buf)))]
+		return nil
+	}
+	if err := bio.fd.Truncate(size); err != nil {
+		return err
+	}
+	bio.flushed = size
+	bio.buf = bio.buf[:0]
+	return nil
+}
+
+// flush 将缓冲区写入文件，调用方需要持有锁
+func (bio *BufferedFileIO) flush() error {
+	if bio.err != nil {
+		return bio.err
+	}
+	if len(bio.buf) == 0 {
+		return nil
+	}
+	n, err := bio.fd.Write(bio.buf)
+	bio.flushed += int64(n)
+	if err != nil {
+		// 部分写入的数据已经在文件中，只保留剩余的部分
+		bio.buf = bio.buf[:copy(bio.buf, bio.buf[n:])]
+		bio.err = err
+		return err
+	}
+	bio.buf = bio.buf[:0]
+	return nil
+}
+
+// flushLoop 定时将缓冲区写入文件
+func (bio *BufferedFileIO) flushLoop(interval time.Duration) {
+	defer close(bio.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			bio.mu.Lock()
+			_ = bio.flush()
+			bio.mu.Unlock()
+		case <-bio.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package fio
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBufferedIO_WriteRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buffered.data")
+	bio, err := NewBufferedIOManager(path, 16, 0)
+	assert.Nil(t, err)
+
+	n, err := bio.Write([]byte("hello "))
+	assert.Nil(t, err)
+	assert.Equal(t, 6, n)
+	_, err = bio.Write([]byte("world"))
+	assert.Nil(t, err)
+
+	// 数据还在缓冲区中，文件中没有数据
+	stat, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), stat.Size())
+	size, err := bio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(11), size)
+
+	buf := make([]byte, 5)
+	n, err = bio.Read(buf, 6)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("world"), buf[:n])
+
+	// 缓冲区满时写入文件，读取可以跨越文件和缓冲区
+	_, err = bio.Write([]byte("!!!!!!"))
+	assert.Nil(t, err)
+	stat, err = os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(11), stat.Size())
+	buf = make([]byte, 8)
+	n, err = bio.Read(buf, 8)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("rld!!!!!"), buf[:n])
+
+	// 超过缓冲区大小的数据直接写入
+	_, err = bio.Write([]byte("0123456789abcdefghij"))
+	assert.Nil(t, err)
+	size, err = bio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(37), size)
+
+	// 读取超出末尾
+	buf = make([]byte, 10)
+	n, err = bio.Read(buf, 30)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, []byte("defghij"), buf[:n])
+
+	assert.Nil(t, bio.Sync())
+	_, err = bio.Write([]byte("tail"))
+	assert.Nil(t, err)
+	assert.Nil(t, bio.Close())
+
+	content, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello world!!!!!!0123456789abcdefghijtail", string(content))
+}
+
+func TestBufferedIO_FlushInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buffered-interval.data")
+	bio, err := NewBufferedIOManager(path, 1024, 10*time.Millisecond)
+	assert.Nil(t, err)
+	defer bio.Close()
+
+	_, err = bio.Write([]byte("data"))
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		stat, err := os.Stat(path)
+		return err == nil && stat.Size() == 4
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestBufferedIO_Truncate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buffered-truncate.data")
+	bio, err := NewBufferedIOManager(path, 16, 0)
+	assert.Nil(t, err)
+	defer bio.Close()
+
+	_, err = bio.Write([]byte("0123456789"))
+	assert.Nil(t, err)
+	assert.Nil(t, bio.Sync())
+	_, err = bio.Write([]byte("abcdef"))
+	assert.Nil(t, err)
+
+	// 只截断缓冲区中的数据
+	assert.Nil(t, bio.Truncate(13))
+	size, err := bio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(13), size)
+
+	// 截断文件中的数据，之后的写入从截断的位置开始
+	assert.Nil(t, bio.Truncate(4))
+	_, err = bio.Write([]byte("xy"))
+	assert.Nil(t, err)
+	assert.Nil(t, bio.Sync())
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("0123xy"), data)
+}
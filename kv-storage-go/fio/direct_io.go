@@ -0,0 +1,244 @@
+package fio
+
+import (
+	"io"
+	"os"
+	"sync"
+	"unsafe"
+)
+
+const (
+	// directIOAlignment Direct IO 要求读写的偏移、长度以及内存地址都按照块大小对齐
+	directIOAlignment = 4096
+
+	// directIOBufferSize 追加写入的缓冲区大小，写满之后将其中完整的块写入磁盘
+	directIOBufferSize = 64 * 1024
+)
+
+// DirectFileIO 绕过操作系统页缓存的文件 IO
+// 追加写入的数据先保存在按块对齐的缓冲区中，缓冲区满时只将完整的块写入磁盘，
+// 最后一个不完整的块在 Sync、Close 时补齐写入，再将文件截断到实际的大小。
+// 读取时会合并缓冲区中的数据，Size 返回包括缓冲区在内的实际数据大小，进程崩溃时缓冲区中的数据会丢失
+type DirectFileIO struct {
+	fd       *os.File
+	mu       sync.RWMutex
+	size     int64  // 文件中实际数据的大小，包括缓冲区中的数据
+	flushed  int64  // 缓冲区中的数据在文件中的起始位置，按块大小对齐
+	buf      []byte // 对齐的写缓冲区，保存从 flushed 开始的数据
+	diskSize int64  // 磁盘上文件的大小
+	dirty    bool   // 缓冲区中是否有还没有写入磁盘的数据
+}
+
+// NewDirectIOManager 以 Direct IO 的方式打开文件，文件不存在时会创建
+func NewDirectIOManager(fileName string) (*DirectFileIO, error) {
+	fd, err := openDirect(fileName)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+
+	dio := &DirectFileIO{
+		fd:       fd,
+		size:     stat.Size(),
+		diskSize: stat.Size(),
+		buf:      alignedBlock(directIOBufferSize)[:0],
+	}
+	if err := dio.loadTail(); err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	return dio, nil
+}
+
+// loadTail 将最后一个不完整的块读取到缓冲区中，之后的写入与它合并
+func (dio *DirectFileIO) loadTail() error {
+	dio.flushed = dio.size &^ (directIOAlignment - 1)
+	dio.buf = dio.buf[:dio.size-dio.flushed]
+	if len(dio.buf) == 0 {
+		return nil
+	}
+	_, err := dio.readDisk(dio.buf, dio.flushed)
+	return err
+}
+
+// Read 从文件的给定位置读取对应的数据，包括缓冲区中的数据
+func (dio *DirectFileIO) Read(buf []byte, offset int64) (int, error) {
+	dio.mu.RLock()
+	defer dio.mu.RUnlock()
+
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	if offset >= dio.size {
+		return 0, io.EOF
+	}
+	var n int
+	if offset < dio.flushed {
+		end := min(dio.flushed, offset+int64(len(buf)))
+		read, err := dio.readDisk(buf[:end-offset], offset)
+		if err != nil {
+			return read, err
+		}
+		n = read
+	}
+	if n < len(buf) {
+		n += copy(buf[n:], dio.buf[offset+int64(n)-dio.flushed:])
+	}
+	if n < len(buf) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// readDisk 按块对齐读取磁盘上的数据
+func (dio *DirectFileIO) readDisk(buf []byte, offset int64) (int, error) {
+	start := offset &^ (directIOAlignment - 1)
+	end := alignUp(offset + int64(len(buf)))
+	aligned := alignedBlock(int(end - start))
+
+	n, err := dio.fd.ReadAt(aligned, start)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	skip := int(offset - start)
+	if n <= skip {
+		return 0, io.EOF
+	}
+	copied := copy(buf, aligned[skip:n])
+	if copied < len(buf) {
+		return copied, io.EOF
+	}
+	return copied, nil
+}
+
+// Write 追加写入字节数组到缓冲区中，缓冲区满时将完整的块写入磁盘
+func (dio *DirectFileIO) Write(data []byte) (int, error) {
+	dio.mu.Lock()
+	defer dio.mu.Unlock()
+
+	if len(data) == 0 {
+		return 0, nil
+	}
+	n := len(dio.buf) + len(data)
+	if n > cap(dio.buf) {
+		// 单次写入的数据超过缓冲区大小时临时扩大缓冲区
+		buf := alignedBlock(int(alignUp(int64(n))))
+		dio.buf = buf[:copy(buf, dio.buf)]
+	}
+	dio.buf = append(dio.buf, data...)
+	if len(dio.buf) >= directIOBufferSize {
+		if err := dio.flushBlocks(); err != nil {
+			dio.buf = dio.buf[:n-len(data)]
+			return 0, err
+		}
+	}
+	dio.size += int64(len(data))
+	dio.dirty = true
+	return len(data), nil
+}
+
+// flushBlocks 将缓冲区中完整的块写入磁盘，不完整的块继续保留在缓冲区中
+func (dio *DirectFileIO) flushBlocks() error {
+	full := len(dio.buf) &^ (directIOAlignment - 1)
+	if full == 0 {
+		return nil
+	}
+	if _, err := dio.fd.WriteAt(dio.buf[:full], dio.flushed); err != nil {
+		return err
+	}
+	dio.flushed += int64(full)
+	dio.diskSize = max(dio.diskSize, dio.flushed)
+
+	tail := dio.buf[full:]
+	if cap(dio.buf) > directIOBufferSize {
+		dio.buf = alignedBlock(directIOBufferSize)
+	}
+	dio.buf = dio.buf[:copy(dio.buf[:len(tail)], tail)]
+	return nil
+}
+
+// flush 将缓冲区中的数据全部写入磁盘，最后一个不完整的块补齐后写入，再截断到实际的大小
+func (dio *DirectFileIO) flush() error {
+	if !dio.dirty {
+		return nil
+	}
+	if err := dio.flushBlocks(); err != nil {
+		return err
+	}
+	if tailLen := len(dio.buf); tailLen > 0 {
+		padded := dio.buf[:directIOAlignment]
+		clear(padded[tailLen:])
+		if _, err := dio.fd.WriteAt(padded, dio.flushed); err != nil {
+			return err
+		}
+		dio.diskSize = max(dio.diskSize, dio.flushed+directIOAlignment)
+	}
+	if dio.diskSize != dio.size {
+		if err := dio.fd.Truncate(dio.size); err != nil {
+			return err
+		}
+		dio.diskSize = dio.size
+	}
+	dio.dirty = false
+	return nil
+}
+
+// Sync 将缓冲区中的数据写入磁盘并持久化
+func (dio *DirectFileIO) Sync() error {
+	dio.mu.Lock()
+	defer dio.mu.Unlock()
+	if err := dio.flush(); err != nil {
+		return err
+	}
+	return dio.fd.Sync()
+}
+
+// Close 将缓冲区中的数据写入磁盘后关闭文件
+func (dio *DirectFileIO) Close() error {
+	dio.mu.Lock()
+	defer dio.mu.Unlock()
+	if err := dio.flush(); err != nil {
+		_ = dio.fd.Close()
+		return err
+	}
+	return dio.fd.Close()
+}
+
+// Truncate 将文件截断到 size 大小
+func (dio *DirectFileIO) Truncate(size int64) error {
+	dio.mu.Lock()
+	defer dio.mu.Unlock()
+	if err := dio.flush(); err != nil {
+		return err
+	}
+	if err := dio.fd.Truncate(size); err != nil {
+		return err
+	}
+	dio.size, dio.diskSize = size, size
+	return dio.loadTail()
+}
+
+// Size 获取文件大小，包括缓冲区中的数据
+func (dio *DirectFileIO) Size() (int64, error) {
+	dio.mu.RLock()
+	defer dio.mu.RUnlock()
+	return dio.size, nil
+}
+
+func alignUp(n int64) int64 {
+	return (n + directIOAlignment - 1) &^ (directIOAlignment - 1)
+}
+
+// alignedBlock 分配起始地址按块大小对齐的内存
+func alignedBlock(size int) []byte {
+	buf := make([]byte, size+directIOAlignment)
+	offset := 0
+	if rem := int(uintptr(unsafe.Pointer(&buf[0])) & (directIOAlignment - 1)); rem != 0 {
+		offset = directIOAlignment - rem
+	}
+	return buf[offset : offset+size : offset+size]
+}
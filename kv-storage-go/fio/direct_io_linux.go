@@ -0,0 +1,12 @@
+//go:build linux
+
+package fio
+
+import (
+	"os"
+	"syscall"
+)
+
+func openDirect(fileName string) (*os.File, error) {
+	return os.OpenFile(fileName, os.O_CREATE|os.O_RDWR|syscall.O_DIRECT, DataFilePerm)
+}
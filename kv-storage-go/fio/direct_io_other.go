@@ -0,0 +1,12 @@
+//go:build !linux
+
+package fio
+
+import (
+	"errors"
+	"os"
+)
+
+func openDirect(string) (*os.File, error) {
+	return nil, errors.New("direct io is not supported on this platform")
+}
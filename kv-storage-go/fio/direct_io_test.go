@@ -0,0 +1,128 @@
+//go:build linux
+
+package fio
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestDirectIO(t *testing.T, path string) *DirectFileIO {
+	dio, err := NewDirectIOManager(path)
+	if err != nil {
+		// 部分文件系统( 例如 tmpfs ) 不支持 O_DIRECT
+		t.Skipf("direct io is not supported: %v", err)
+	}
+	return dio
+}
+
+func TestDirectIO_WriteRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "direct.data")
+	dio := newTestDirectIO(t, path)
+
+	var expected []byte
+	r := rand.New(rand.NewSource(1))
+	for _, n := range []int{0, 10, 4086, 1, 4096, 9000, 3, 20000} {
+		data := make([]byte, n)
+		r.Read(data)
+		written, err := dio.Write(data)
+		assert.Nil(t, err)
+		assert.Equal(t, n, written)
+		expected = append(expected, data...)
+
+		// 大小包括缓冲区中还没有写入磁盘的数据
+		size, err := dio.Size()
+		assert.Nil(t, err)
+		assert.Equal(t, int64(len(expected)), size)
+	}
+	// 缓冲区没有写满时不会写入磁盘
+	stat, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), stat.Size())
+
+	// 任意位置、任意长度的读取
+	for _, tt := range [][2]int{{0, 10}, {5, 4091}, {4095, 2}, {4096, 4096}, {10000, 7000}, {len(expected) - 5, 5}} {
+		buf := make([]byte, tt[1])
+		n, err := dio.Read(buf, int64(tt[0]))
+		assert.Nil(t, err)
+		assert.Equal(t, tt[1], n)
+		assert.True(t, bytes.Equal(expected[tt[0]:tt[0]+tt[1]], buf))
+	}
+
+	// 读取超出文件末尾
+	buf := make([]byte, 10)
+	n, err := dio.Read(buf, int64(len(expected)-4))
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, expected[len(expected)-4:], buf[:n])
+	_, err = dio.Read(buf, int64(len(expected)+100))
+	assert.Equal(t, io.EOF, err)
+
+	// Sync 之后文件大小与实际写入的数据一致，不包含补齐的部分
+	assert.Nil(t, dio.Sync())
+	stat, err = os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(expected)), stat.Size())
+	assert.Nil(t, dio.Close())
+
+	// 重新打开后继续追加
+	dio = newTestDirectIO(t, path)
+	defer dio.Close()
+	_, err = dio.Write([]byte("append"))
+	assert.Nil(t, err)
+	expected = append(expected, []byte("append")...)
+	assert.Nil(t, dio.Sync())
+
+	content, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(expected, content))
+}
+
+func TestDirectIO_BufferedAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "direct.data")
+	dio := newTestDirectIO(t, path)
+	defer dio.Close()
+
+	var expected []byte
+	record := bytes.Repeat([]byte("direct-io-record"), 10)
+	for i := 0; i < 2000; i++ {
+		_, err := dio.Write(record)
+		assert.Nil(t, err)
+		expected = append(expected, record...)
+	}
+	// 缓冲区写满时只写入完整的块
+	stat, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.True(t, stat.Size() > 0)
+	assert.Equal(t, int64(0), stat.Size()%directIOAlignment)
+
+	buf := make([]byte, len(expected))
+	n, err := dio.Read(buf, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, len(expected), n)
+	assert.True(t, bytes.Equal(expected, buf))
+
+	// 超过缓冲区大小的单次写入
+	large := bytes.Repeat([]byte("l"), directIOBufferSize*2+100)
+	_, err = dio.Write(large)
+	assert.Nil(t, err)
+	expected = append(expected, large...)
+
+	// 截断之后从截断的位置继续写入
+	expected = expected[:100001]
+	assert.Nil(t, dio.Truncate(int64(len(expected))))
+	_, err = dio.Write([]byte("tail"))
+	assert.Nil(t, err)
+	expected = append(expected, []byte("tail")...)
+	assert.Nil(t, dio.Sync())
+
+	content, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(expected, content))
+}
@@ -0,0 +1,386 @@
+package fio
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+)
+
+const (
+	encryptedFileMagic = "KVENC001"
+
+	// 文件头：魔数 + 随机的文件 id
+	encryptedFileIDSize     = 16
+	encryptedFileHeaderSize = len(encryptedFileMagic) + encryptedFileIDSize
+
+	// 数据块头：明文长度 + 密钥 id + nonce
+	encryptedNonceSize       = 12
+	encryptedFrameHeaderSize = 4 + 4 + encryptedNonceSize
+	encryptedTagSize         = 16
+)
+
+var (
+	// ErrNotEncrypted 使用加密的 IO 打开了没有加密的文件
+	ErrNotEncrypted = errors.New("the file is not encrypted")
+
+	// ErrDecryptFailed 数据块无法解密，密钥错误或者数据已经损坏
+	ErrDecryptFailed = errors.New("failed to decrypt the data, the key is wrong or the data is corrupted")
+)
+
+// EncryptedFileIO 加密的文件 IO，每次写入的数据使用 AES-GCM 单独加密为一个数据块追加到底层的文件中
+// 对外的读写偏移都是明文中的偏移，打开文件时扫描所有的数据块头，在内存中记录明文偏移与数据块位置的对应关系。
+// 数据块的认证数据包含文件 id、明文偏移以及数据块头，数据块不能在文件之间或者文件内部被替换
+type EncryptedFileIO struct {
+	inner    IOManager
+	keys     KeyProvider
+	mu       sync.RWMutex
+	fileID   []byte
+	frames   []encryptedFrame
+	size     int64 // 明文大小
+	physEnd  int64 // 有效的数据块在底层文件中结束的位置
+	physSize int64 // 底层文件的大小，大于 physEnd 时末尾是没有完整写入的数据
+
+	cacheMu    sync.Mutex
+	aeads      map[uint32]cipher.AEAD
+	cacheFrame int64  // 最近一次解密的数据块位置
+	cacheData  []byte // 最近一次解密的明文，顺序读取时不需要重复解密
+}
+
+// encryptedFrame 数据块在明文中的偏移以及在底层文件中的位置
+type encryptedFrame struct {
+	off  int64
+	phys int64
+}
+
+// NewEncryptedIOManager 在 inner 之上创建加密的文件 IO，inner 中已有的数据必须是加密的
+// 末尾没有完整写入或者无法解密的数据块会被忽略，在下一次写入之前截断
+func NewEncryptedIOManager(inner IOManager, keys KeyProvider) (*EncryptedFileIO, error) {
+	physSize, err := inner.Size()
+	if err != nil {
+		return nil, err
+	}
+	eio := &EncryptedFileIO{
+		inner:      inner,
+		keys:       keys,
+		physSize:   physSize,
+		aeads:      make(map[uint32]cipher.AEAD),
+		cacheFrame: -1,
+	}
+	if err := eio.load(); err != nil {
+		return nil, err
+	}
+	return eio, nil
+}
+
+// load 读取文件头并扫描所有的数据块
+func (eio *EncryptedFileIO) load() error {
+	if eio.physSize == 0 {
+		return nil
+	}
+	header := make([]byte, min(eio.physSize, int64(encryptedFileHeaderSize)))
+	if _, err := eio.inner.Read(header, 0); err != nil && err != io.EOF {
+		return err
+	}
+	n := min(len(header), len(encryptedFileMagic))
+	if !bytes.Equal(header[:n], []byte(encryptedFileMagic[:n])) {
+		return ErrNotEncrypted
+	}
+	// 文件头和第一个数据块一起写入，文件头不完整说明第一次写入没有完成
+	if len(header) < encryptedFileHeaderSize {
+		return nil
+	}
+	eio.fileID = header[len(encryptedFileMagic):]
+	eio.physEnd = int64(encryptedFileHeaderSize)
+
+	frameHeader := make([]byte, encryptedFrameHeaderSize)
+	for phys := eio.physEnd; phys+encryptedFrameHeaderSize <= eio.physSize; {
+		if _, err := eio.inner.Read(frameHeader, phys); err != nil {
+			return err
+		}
+		end := phys + encryptedFrameHeaderSize + int64(binary.LittleEndian.Uint32(frameHeader)) + encryptedTagSize
+		if end > eio.physSize {
+			break
+		}
+		eio.frames = append(eio.frames, encryptedFrame{off: eio.size, phys: phys})
+		eio.size += int64(binary.LittleEndian.Uint32(frameHeader))
+		eio.physEnd = end
+		phys = end
+	}
+
+	// 末尾的数据块可能是崩溃时没有完整写入的数据，从后往前丢弃无法解密的数据块
+	// 第一个数据块也无法解密时说明密钥错误，不能丢弃任何数据
+	for len(eio.frames) > 0 {
+		last := len(eio.frames) - 1
+		_, err := eio.decrypt(last)
+		if err == nil {
+			break
+		}
+		if last == 0 {
+			return err
+		}
+		eio.size = eio.frames[last].off
+		eio.physEnd = eio.frames[last].phys
+		eio.frames = eio.frames[:last]
+	}
+	return nil
+}
+
+// Read 从明文的给定位置读取对应的数据
+func (eio *EncryptedFileIO) Read(buf []byte, offset int64) (int, error) {
+	eio.mu.RLock()
+	defer eio.mu.RUnlock()
+
+	var n int
+	for n < len(buf) && offset+int64(n) < eio.size {
+		pos := offset + int64(n)
+		idx := sort.Search(len(eio.frames), func(i int) bool {
+			return eio.frames[i].off > pos
+		}) - 1
+		plaintext, err := eio.decrypt(idx)
+		if err != nil {
+			return n, err
+		}
+		n += copy(buf[n:], plaintext[pos-eio.frames[idx].off:])
+	}
+	if n < len(buf) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Write 加密数据并追加写入到文件中，写入失败时不会留下不完整的数据块
+func (eio *EncryptedFileIO) Write(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+	eio.mu.Lock()
+	defer eio.mu.Unlock()
+	return eio.write(data)
+}
+
+// write 写入一个数据块，调用方需要持有写锁
+func (eio *EncryptedFileIO) write(data []byte) (int, error) {
+	if eio.physSize > eio.physEnd {
+		if err := eio.truncateInner(eio.physEnd); err != nil {
+			return 0, err
+		}
+	}
+
+	var buf []byte
+	if eio.physEnd == 0 {
+		eio.fileID = make([]byte, encryptedFileIDSize)
+		if _, err := rand.Read(eio.fileID); err != nil {
+			return 0, err
+		}
+		buf = append([]byte(encryptedFileMagic), eio.fileID...)
+	}
+	buf, err := eio.seal(buf, data)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := eio.inner.Write(buf)
+	if err != nil {
+		eio.physSize += int64(n)
+		if n > 0 {
+			_ = eio.truncateInner(eio.physEnd)
+		}
+		return 0, err
+	}
+	phys := max(eio.physEnd, int64(encryptedFileHeaderSize))
+	eio.frames = append(eio.frames, encryptedFrame{off: eio.size, phys: phys})
+	eio.size += int64(len(data))
+	eio.physEnd += int64(n)
+	eio.physSize = eio.physEnd
+	return len(data), nil
+}
+
+// Truncate 将明文截断到 size 大小，截断位置在数据块中间时重新加密数据块剩余的部分
+func (eio *EncryptedFileIO) Truncate(size int64) error {
+	eio.mu.Lock()
+	defer eio.mu.Unlock()
+	if size >= eio.size {
+		return nil
+	}
+
+	// 第一个起始位置不小于 size 的数据块之后全部丢弃
+	cut := sort.Search(len(eio.frames), func(i int) bool {
+		return eio.frames[i].off >= size
+	})
+	var remain []byte
+	if cut > 0 && (cut == len(eio.frames) || eio.frames[cut].off != size) {
+		cut--
+		plaintext, err := eio.decrypt(cut)
+		if err != nil {
+			return err
+		}
+		remain = append([]byte(nil), plaintext[:size-eio.frames[cut].off]...)
+	}
+
+	var physEnd int64
+	if cut > 0 {
+		physEnd = eio.frames[cut].phys
+	}
+	if err := eio.truncateInner(physEnd); err != nil {
+		return err
+	}
+	eio.size = eio.frames[cut].off
+	eio.frames = eio.frames[:cut]
+	eio.physEnd = physEnd
+
+	if len(remain) > 0 {
+		_, err := eio.write(remain)
+		return err
+	}
+	return nil
+}
+
+// Sync 持久化数据
+func (eio *EncryptedFileIO) Sync() error {
+	return eio.inner.Sync()
+}
+
+// Close 关闭文件
+func (eio *EncryptedFileIO) Close() error {
+	return eio.inner.Close()
+}
+
+// Size 获取明文的大小
+func (eio *EncryptedFileIO) Size() (int64, error) {
+	eio.mu.RLock()
+	defer eio.mu.RUnlock()
+	return eio.size, nil
+}
+
+// truncateInner 截断底层的文件，调用方需要持有写锁
+func (eio *EncryptedFileIO) truncateInner(size int64) error {
+	truncater, ok := eio.inner.(Truncater)
+	if !ok {
+		return errors.New("the underlying io manager does not support truncating files")
+	}
+	if err := truncater.Truncate(size); err != nil {
+		return err
+	}
+	eio.physSize = size
+	eio.cacheMu.Lock()
+	eio.cacheFrame = -1
+	eio.cacheData = nil
+	eio.cacheMu.Unlock()
+	return nil
+}
+
+// seal 加密 data，将数据块追加到 buf 之后
+func (eio *EncryptedFileIO) seal(buf, data []byte) ([]byte, error) {
+	keyID, key, err := eio.keys.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := eio.aead(keyID, key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, encryptedFrameHeaderSize)
+	binary.LittleEndian.PutUint32(header, uint32(len(data)))
+	binary.LittleEndian.PutUint32(header[4:], keyID)
+	if _, err := rand.Read(header[8:]); err != nil {
+		return nil, err
+	}
+	buf = append(buf, header...)
+	return aead.Seal(buf, header[8:], data, eio.additionalData(eio.size, header)), nil
+}
+
+// decrypt 读取并解密第 idx 个数据块，调用方需要持有锁
+func (eio *EncryptedFileIO) decrypt(idx int) ([]byte, error) {
+	frame := eio.frames[idx]
+	eio.cacheMu.Lock()
+	if eio.cacheFrame == frame.phys {
+		defer eio.cacheMu.Unlock()
+		return eio.cacheData, nil
+	}
+	eio.cacheMu.Unlock()
+
+	plainLen := eio.size - frame.off
+	if idx+1 < len(eio.frames) {
+		plainLen = eio.frames[idx+1].off - frame.off
+	}
+	buf := make([]byte, encryptedFrameHeaderSize+plainLen+encryptedTagSize)
+	if _, err := eio.inner.Read(buf, frame.phys); err != nil {
+		return nil, err
+	}
+	header := buf[:encryptedFrameHeaderSize]
+	keyID := binary.LittleEndian.Uint32(header[4:])
+	key, err := eio.keys.Key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := eio.aead(keyID, key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, header[8:], buf[encryptedFrameHeaderSize:], eio.additionalData(frame.off, header))
+	if err != nil {
+		return nil, ErrDecryptFailed
+	}
+
+	eio.cacheMu.Lock()
+	eio.cacheFrame = frame.phys
+	eio.cacheData = plaintext
+	eio.cacheMu.Unlock()
+	return plaintext, nil
+}
+
+// additionalData 数据块的认证数据
+func (eio *EncryptedFileIO) additionalData(offset int64, header []byte) []byte {
+	ad := make([]byte, 0, encryptedFileIDSize+8+encryptedFrameHeaderSize)
+	ad = append(ad, eio.fileID...)
+	ad = binary.LittleEndian.AppendUint64(ad, uint64(offset))
+	return append(ad, header...)
+}
+
+func (eio *EncryptedFileIO) aead(keyID uint32, key []byte) (cipher.AEAD, error) {
+	eio.cacheMu.Lock()
+	defer eio.cacheMu.Unlock()
+	if aead, ok := eio.aeads[keyID]; ok {
+		return aead, nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	eio.aeads[keyID] = aead
+	return aead, nil
+}
+
+// EncryptedFileSystem 加密的文件系统，打开的文件全部使用 EncryptedFileIO 读写
+type EncryptedFileSystem struct {
+	FileSystem
+	keys KeyProvider
+}
+
+func NewEncryptedFileSystem(fs FileSystem, keys KeyProvider) *EncryptedFileSystem {
+	return &EncryptedFileSystem{FileSystem: fs, keys: keys}
+}
+
+func (efs *EncryptedFileSystem) OpenFile(name string, ioType FileIOType) (IOManager, error) {
+	inner, err := efs.FileSystem.OpenFile(name, ioType)
+	if err != nil {
+		return nil, err
+	}
+	eio, err := NewEncryptedIOManager(inner, efs.keys)
+	if err != nil {
+		_ = inner.Close()
+		return nil, err
+	}
+	return eio, nil
+}
@@ -0,0 +1,144 @@
+package fio
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestKeyProvider(t *testing.T, id uint32, b byte) KeyProvider {
+	keys, err := NewStaticKeyProvider(id, bytes.Repeat([]byte{b}, 32))
+	assert.Nil(t, err)
+	return keys
+}
+
+func openTestEncryptedFile(t *testing.T, fs FileSystem, keys KeyProvider) (*EncryptedFileIO, error) {
+	assert.Nil(t, fs.MkdirAll("/enc"))
+	file, err := fs.OpenFile("/enc/001.data", StandardFIO)
+	assert.Nil(t, err)
+	return NewEncryptedIOManager(file, keys)
+}
+
+func TestEncryptedFileIO_ReadWrite(t *testing.T) {
+	mfs := NewMemFileSystem()
+	keys := newTestKeyProvider(t, 1, 'a')
+	eio, err := openTestEncryptedFile(t, mfs, keys)
+	assert.Nil(t, err)
+
+	for _, data := range []string{"key-a", "bitcask kv", "storage"} {
+		n, err := eio.Write([]byte(data))
+		assert.Nil(t, err)
+		assert.Equal(t, len(data), n)
+	}
+	size, err := eio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(22), size)
+
+	// 读取跨越多个数据块
+	buf := make([]byte, 10)
+	n, err := eio.Read(buf, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, "-abitcask ", string(buf[:n]))
+	n, err = eio.Read(buf, 18)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "rage", string(buf[:n]))
+	assert.Nil(t, eio.Close())
+
+	// 底层文件中没有明文
+	raw, err := mfs.OpenFile("/enc/001.data", StandardFIO)
+	assert.Nil(t, err)
+	rawSize, err := raw.Size()
+	assert.Nil(t, err)
+	rawData := make([]byte, rawSize)
+	_, err = raw.Read(rawData, 0)
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(rawData, []byte("bitcask")))
+
+	eio, err = NewEncryptedIOManager(raw, keys)
+	assert.Nil(t, err)
+	buf = make([]byte, 22)
+	_, err = eio.Read(buf, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "key-abitcask kvstorage", string(buf))
+}
+
+func TestEncryptedFileIO_Truncate(t *testing.T) {
+	eio, err := openTestEncryptedFile(t, NewMemFileSystem(), newTestKeyProvider(t, 1, 'a'))
+	assert.Nil(t, err)
+	_, err = eio.Write([]byte("abcd"))
+	assert.Nil(t, err)
+	_, err = eio.Write([]byte("efgh"))
+	assert.Nil(t, err)
+
+	// 截断到数据块中间
+	assert.Nil(t, eio.Truncate(6))
+	_, err = eio.Write([]byte("xy"))
+	assert.Nil(t, err)
+	buf := make([]byte, 8)
+	_, err = eio.Read(buf, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "abcdefxy", string(buf))
+
+	assert.Nil(t, eio.Truncate(0))
+	size, err := eio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), size)
+}
+
+func TestEncryptedFileIO_WrongKey(t *testing.T) {
+	mfs := NewMemFileSystem()
+	eio, err := openTestEncryptedFile(t, mfs, newTestKeyProvider(t, 1, 'a'))
+	assert.Nil(t, err)
+	_, err = eio.Write([]byte("abcd"))
+	assert.Nil(t, err)
+	assert.Nil(t, eio.Close())
+
+	_, err = openTestEncryptedFile(t, mfs, newTestKeyProvider(t, 1, 'b'))
+	assert.Equal(t, ErrDecryptFailed, err)
+	_, err = openTestEncryptedFile(t, mfs, newTestKeyProvider(t, 2, 'a'))
+	assert.Equal(t, ErrUnknownKey, err)
+}
+
+func TestEncryptedFileIO_TornTail(t *testing.T) {
+	ffs := NewFaultFileSystem()
+	keys := newTestKeyProvider(t, 1, 'a')
+	eio, err := openTestEncryptedFile(t, ffs, keys)
+	assert.Nil(t, err)
+	_, err = eio.Write([]byte("synced"))
+	assert.Nil(t, err)
+	assert.Nil(t, eio.Sync())
+	_, err = eio.Write([]byte("unsynced-data"))
+	assert.Nil(t, err)
+	ffs.PowerLoss(CrashTornUnsynced)
+
+	// 写坏的数据块被丢弃，之后的写入覆盖掉这部分数据
+	eio, err = openTestEncryptedFile(t, ffs, keys)
+	assert.Nil(t, err)
+	size, err := eio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), size)
+	_, err = eio.Write([]byte("-new"))
+	assert.Nil(t, err)
+	assert.Nil(t, eio.Close())
+
+	eio, err = openTestEncryptedFile(t, ffs, keys)
+	assert.Nil(t, err)
+	buf := make([]byte, 10)
+	_, err = eio.Read(buf, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "synced-new", string(buf))
+}
+
+func TestEncryptedFileIO_NotEncrypted(t *testing.T) {
+	mfs := NewMemFileSystem()
+	assert.Nil(t, mfs.MkdirAll("/enc"))
+	file, err := mfs.OpenFile("/enc/001.data", StandardFIO)
+	assert.Nil(t, err)
+	_, err = file.Write([]byte("plaintext"))
+	assert.Nil(t, err)
+
+	_, err = NewEncryptedIOManager(file, newTestKeyProvider(t, 1, 'a'))
+	assert.Equal(t, ErrNotEncrypted, err)
+}
@@ -0,0 +1,18 @@
+//go:build linux
+
+package fio
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+func fallocate(fd *os.File, size int64) error {
+	err := syscall.Fallocate(int(fd.Fd()), 0, 0, size)
+	// 文件系统不支持时退化为稀疏文件
+	if errors.Is(err, syscall.EOPNOTSUPP) {
+		return fd.Truncate(size)
+	}
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package fio
+
+import "os"
+
+// fallocate 其他平台使用稀疏文件代替，只扩展文件大小，不保证磁盘空间连续
+func fallocate(fd *os.File, size int64) error {
+	return fd.Truncate(size)
+}
@@ -0,0 +1,232 @@
+package fio
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// ErrInjectedFault 注入的故障返回的错误
+var ErrInjectedFault = fmt.Errorf("injected fault: %w", syscall.EIO)
+
+// FaultType 注入的故障类型
+type FaultType byte
+
+const (
+	// FaultShortWrite 只写入一半的数据，返回 io.ErrShortWrite
+	FaultShortWrite FaultType = iota + 1
+
+	// FaultWriteError 写入失败，没有写入任何数据
+	FaultWriteError
+
+	// FaultSyncError 持久化失败，数据仍然处于没有持久化的状态
+	FaultSyncError
+
+	// FaultRenameError 重命名失败
+	FaultRenameError
+)
+
+// Fault 注入的故障，名称与 Pattern 匹配的文件第 After+1 次执行对应的操作时触发，只触发一次
+type Fault struct {
+	Type    FaultType
+	Pattern string // 文件名称的匹配模式，语法与 filepath.Match 相同，为空时匹配所有文件
+	After   int    // 触发之前跳过的操作次数
+}
+
+// CrashMode 掉电时没有持久化的数据的处理方式
+type CrashMode byte
+
+const (
+	// CrashDropUnsynced 丢弃所有没有持久化的数据
+	CrashDropUnsynced CrashMode = iota
+
+	// CrashKeepPartial 只保留没有持久化的数据的前一半，模拟只写入了部分扇区
+	CrashKeepPartial
+
+	// CrashTornUnsynced 保留没有持久化的数据，但后一半被写坏，模拟写入撕裂
+	CrashTornUnsynced
+)
+
+// FaultFileSystem 用于测试的故障注入文件系统，基于内存文件系统实现
+// 可以模拟写入不完整、写入失败、持久化失败、重命名失败，以及掉电丢失没有持久化的数据。
+// 目录操作( 创建、删除、重命名 )在返回之后立即生效，不会因为掉电丢失
+type FaultFileSystem struct {
+	*MemFileSystem
+	mu      sync.Mutex
+	synced  map[*memFileData]int64 // 文件中已经持久化的数据大小
+	faults  []*faultState
+	handles map[*FaultFile]struct{} // 还没有关闭的文件
+}
+
+type faultState struct {
+	Fault
+	count int
+}
+
+func NewFaultFileSystem() *FaultFileSystem {
+	return &FaultFileSystem{
+		MemFileSystem: NewMemFileSystem(),
+		synced:        make(map[*memFileData]int64),
+		handles:       make(map[*FaultFile]struct{}),
+	}
+}
+
+// Inject 注入一个故障
+func (ffs *FaultFileSystem) Inject(fault Fault) {
+	ffs.mu.Lock()
+	defer ffs.mu.Unlock()
+	ffs.faults = append(ffs.faults, &faultState{Fault: fault})
+}
+
+// ClearFaults 清除所有还没有触发的故障
+func (ffs *FaultFileSystem) ClearFaults() {
+	ffs.mu.Lock()
+	defer ffs.mu.Unlock()
+	ffs.faults = nil
+}
+
+// PowerLoss 模拟掉电，按照 mode 处理所有文件中没有持久化的数据
+// 已经打开的文件全部失效，文件锁全部释放，之后可以像进程重启一样重新打开
+func (ffs *FaultFileSystem) PowerLoss(mode CrashMode) {
+	ffs.mu.Lock()
+	defer ffs.mu.Unlock()
+
+	for handle := range ffs.handles {
+		handle.MemFile.closed.Store(true)
+	}
+	ffs.handles = make(map[*FaultFile]struct{})
+
+	ffs.MemFileSystem.mu.Lock()
+	defer ffs.MemFileSystem.mu.Unlock()
+	for _, file := range ffs.MemFileSystem.files {
+		synced, ok := ffs.synced[file]
+		if !ok {
+			continue
+		}
+		file.mu.Lock()
+		unsynced := file.data[synced:]
+		switch mode {
+		case CrashDropUnsynced:
+			file.data = file.data[:synced]
+		case CrashKeepPartial:
+			file.data = file.data[:synced+int64(len(unsynced)/2)]
+		case CrashTornUnsynced:
+			for i := len(unsynced) / 2; i < len(unsynced); i++ {
+				unsynced[i] = byte(i*31 + 7)
+			}
+		}
+		ffs.synced[file] = int64(len(file.data))
+		file.mu.Unlock()
+	}
+	ffs.MemFileSystem.locks = make(map[string]bool)
+}
+
+// OpenFile 打开文件，新创建的文件在持久化之前掉电会丢失其中的数据
+func (ffs *FaultFileSystem) OpenFile(name string, _ FileIOType) (IOManager, error) {
+	memFile, err := ffs.MemFileSystem.openFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	ffs.mu.Lock()
+	defer ffs.mu.Unlock()
+	if _, ok := ffs.synced[memFile.file]; !ok {
+		size, _ := memFile.Size()
+		ffs.synced[memFile.file] = size
+	}
+	file := &FaultFile{MemFile: memFile, fs: ffs, name: filepath.Base(name)}
+	ffs.handles[file] = struct{}{}
+	return file, nil
+}
+
+// Rename 重命名文件，可以注入 FaultRenameError
+func (ffs *FaultFileSystem) Rename(oldName, newName string) error {
+	if ffs.trigger(FaultRenameError, filepath.Base(oldName)) {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: ErrInjectedFault}
+	}
+	return ffs.MemFileSystem.Rename(oldName, newName)
+}
+
+// trigger 判断本次操作是否需要触发故障
+func (ffs *FaultFileSystem) trigger(typ FaultType, name string) bool {
+	ffs.mu.Lock()
+	defer ffs.mu.Unlock()
+
+	for i, fault := range ffs.faults {
+		if fault.Type != typ {
+			continue
+		}
+		if fault.Pattern != "" {
+			if matched, _ := filepath.Match(fault.Pattern, name); !matched {
+				continue
+			}
+		}
+		fault.count++
+		if fault.count > fault.After {
+			ffs.faults = append(ffs.faults[:i], ffs.faults[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// FaultFile 故障注入文件系统中的文件 IO
+type FaultFile struct {
+	*MemFile
+	fs   *FaultFileSystem
+	name string
+}
+
+// Write 写入字节数组到文件末尾，可以注入 FaultShortWrite 和 FaultWriteError
+func (ff *FaultFile) Write(data []byte) (int, error) {
+	if ff.fs.trigger(FaultWriteError, ff.name) {
+		return 0, ErrInjectedFault
+	}
+	if ff.fs.trigger(FaultShortWrite, ff.name) {
+		n, err := ff.MemFile.Write(data[:len(data)/2])
+		if err != nil {
+			return n, err
+		}
+		return n, io.ErrShortWrite
+	}
+	return ff.MemFile.Write(data)
+}
+
+// Sync 持久化数据，可以注入 FaultSyncError
+func (ff *FaultFile) Sync() error {
+	if err := ff.MemFile.Sync(); err != nil {
+		return err
+	}
+	if ff.fs.trigger(FaultSyncError, ff.name) {
+		return ErrInjectedFault
+	}
+	size, _ := ff.MemFile.Size()
+	ff.fs.mu.Lock()
+	defer ff.fs.mu.Unlock()
+	ff.fs.synced[ff.file] = size
+	return nil
+}
+
+// Truncate 将文件截断到 size 大小
+func (ff *FaultFile) Truncate(size int64) error {
+	if err := ff.MemFile.Truncate(size); err != nil {
+		return err
+	}
+	ff.fs.mu.Lock()
+	defer ff.fs.mu.Unlock()
+	if ff.fs.synced[ff.file] > size {
+		ff.fs.synced[ff.file] = size
+	}
+	return nil
+}
+
+// Close 关闭文件，没有持久化的数据仍然可能在掉电时丢失
+func (ff *FaultFile) Close() error {
+	ff.fs.mu.Lock()
+	delete(ff.fs.handles, ff)
+	ff.fs.mu.Unlock()
+	return ff.MemFile.Close()
+}
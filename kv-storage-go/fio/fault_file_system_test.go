@@ -0,0 +1,116 @@
+package fio
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestFaultFile(t *testing.T, ffs *FaultFileSystem, name string) IOManager {
+	assert.Nil(t, ffs.MkdirAll("/fault"))
+	file, err := ffs.OpenFile("/fault/"+name, StandardFIO)
+	assert.Nil(t, err)
+	return file
+}
+
+func TestFaultFileSystem_WriteFault(t *testing.T) {
+	ffs := NewFaultFileSystem()
+	file := newTestFaultFile(t, ffs, "001.data")
+
+	ffs.Inject(Fault{Type: FaultShortWrite, Pattern: "*.data", After: 1})
+	ffs.Inject(Fault{Type: FaultWriteError, Pattern: "*.hint"})
+	_, err := file.Write([]byte("abcd"))
+	assert.Nil(t, err)
+	n, err := file.Write([]byte("efgh"))
+	assert.Equal(t, io.ErrShortWrite, err)
+	assert.Equal(t, 2, n)
+	// 故障只触发一次，与名称不匹配的故障不会触发
+	_, err = file.Write([]byte("ijkl"))
+	assert.Nil(t, err)
+
+	size, err := file.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), size)
+
+	hint := newTestFaultFile(t, ffs, "001.hint")
+	_, err = hint.Write([]byte("abcd"))
+	assert.ErrorIs(t, err, ErrInjectedFault)
+}
+
+func TestFaultFileSystem_PowerLoss(t *testing.T) {
+	tests := []struct {
+		mode CrashMode
+		want []byte
+	}{
+		{mode: CrashDropUnsynced, want: []byte("synced")},
+		{mode: CrashKeepPartial, want: []byte("synced-un")},
+	}
+	for _, tt := range tests {
+		ffs := NewFaultFileSystem()
+		file := newTestFaultFile(t, ffs, "001.data")
+		_, err := file.Write([]byte("synced"))
+		assert.Nil(t, err)
+		assert.Nil(t, file.Sync())
+
+		// 持久化失败的数据仍然会在掉电时丢失
+		ffs.Inject(Fault{Type: FaultSyncError})
+		_, err = file.Write([]byte("-unsync"))
+		assert.Nil(t, err)
+		assert.ErrorIs(t, file.Sync(), ErrInjectedFault)
+		ffs.PowerLoss(tt.mode)
+
+		// 掉电之前打开的文件已经失效
+		_, err = file.Write([]byte("after"))
+		assert.NotNil(t, err)
+
+		file = newTestFaultFile(t, ffs, "001.data")
+		buf := make([]byte, len(tt.want))
+		n, err := file.Read(buf, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, tt.want, buf[:n])
+		size, err := file.Size()
+		assert.Nil(t, err)
+		assert.Equal(t, int64(len(tt.want)), size)
+	}
+}
+
+func TestFaultFileSystem_TornUnsynced(t *testing.T) {
+	ffs := NewFaultFileSystem()
+	file := newTestFaultFile(t, ffs, "001.data")
+	_, err := file.Write([]byte("synced"))
+	assert.Nil(t, err)
+	assert.Nil(t, file.Sync())
+	_, err = file.Write([]byte("unsynced"))
+	assert.Nil(t, err)
+
+	// 掉电之前文件锁被占用，掉电之后释放
+	_, hold, err := ffs.TryLock("/fault/flock")
+	assert.Nil(t, err)
+	assert.True(t, hold)
+	_, hold, err = ffs.TryLock("/fault/flock")
+	assert.Nil(t, err)
+	assert.False(t, hold)
+	ffs.PowerLoss(CrashTornUnsynced)
+	_, hold, err = ffs.TryLock("/fault/flock")
+	assert.Nil(t, err)
+	assert.True(t, hold)
+
+	file = newTestFaultFile(t, ffs, "001.data")
+	buf := make([]byte, 14)
+	_, err = file.Read(buf, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("syncedunsy"), buf[:10])
+	assert.NotEqual(t, []byte("nced"), buf[10:])
+}
+
+func TestFaultFileSystem_RenameFault(t *testing.T) {
+	ffs := NewFaultFileSystem()
+	newTestFaultFile(t, ffs, "001.data")
+
+	ffs.Inject(Fault{Type: FaultRenameError})
+	assert.ErrorIs(t, ffs.Rename("/fault/001.data", "/fault/002.data"), ErrInjectedFault)
+	assert.Nil(t, ffs.Rename("/fault/001.data", "/fault/002.data"))
+	_, err := ffs.Stat("/fault/002.data")
+	assert.Nil(t, err)
+}
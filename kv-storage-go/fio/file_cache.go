@@ -0,0 +1,177 @@
+package fio
+
+import (
+	"container/list"
+	"os"
+	"sync"
+
+	"github.com/tClown11/kv-storage/errs"
+)
+
+// FileCache 限制同时打开的文件数量，超过数量时关闭最久没有使用的文件，之后访问时重新打开
+// 正在读写的文件不会被关闭，此时打开的文件数量可能暂时超过限制
+type FileCache struct {
+	mu       sync.Mutex
+	capacity int
+	lru      *list.List // 已经打开的文件，最近使用的在前面
+}
+
+func NewFileCache(capacity int) *FileCache {
+	return &FileCache{capacity: capacity, lru: list.New()}
+}
+
+// Wrap 创建按需打开的文件 IO，inner 为已经打开的文件，为空时在第一次访问时通过 open 打开
+func (fc *FileCache) Wrap(inner IOManager, open func() (IOManager, error)) *CachedFileIO {
+	cio := &CachedFileIO{cache: fc, open: open}
+	if inner != nil {
+		fc.mu.Lock()
+		cio.inner = inner
+		cio.elem = fc.lru.PushFront(cio)
+		fc.evict()
+		fc.mu.Unlock()
+	}
+	return cio
+}
+
+// Len 获取当前打开的文件数量
+func (fc *FileCache) Len() int {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.lru.Len()
+}
+
+// evict 从最久没有使用的文件开始关闭，直到打开的文件数量不超过限制，调用方需要持有锁
+func (fc *FileCache) evict() {
+	for elem := fc.lru.Back(); elem != nil && fc.lru.Len() > fc.capacity; {
+		prev := elem.Prev()
+		if cio := elem.Value.(*CachedFileIO); cio.refs == 0 {
+			_ = cio.inner.Close()
+			cio.inner = nil
+			fc.lru.Remove(elem)
+			cio.elem = nil
+		}
+		elem = prev
+	}
+}
+
+// CachedFileIO 由 FileCache 管理的文件 IO，被关闭之后在下一次访问时重新打开
+type CachedFileIO struct {
+	cache  *FileCache
+	open   func() (IOManager, error)
+	inner  IOManager // 为空表示文件已经被关闭，以下字段由 cache.mu 保护
+	elem   *list.Element
+	refs   int // 正在使用文件的请求数量
+	closed bool
+}
+
+// acquire 获取打开的文件，使用完之后需要调用 release
+func (cio *CachedFileIO) acquire() (IOManager, error) {
+	fc := cio.cache
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if cio.closed {
+		return nil, os.ErrClosed
+	}
+	if cio.inner == nil {
+		inner, err := cio.open()
+		if err != nil {
+			return nil, err
+		}
+		cio.inner = inner
+		cio.elem = fc.lru.PushFront(cio)
+	} else {
+		fc.lru.MoveToFront(cio.elem)
+	}
+	cio.refs++
+	fc.evict()
+	return cio.inner, nil
+}
+
+func (cio *CachedFileIO) release() {
+	fc := cio.cache
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	cio.refs--
+	fc.evict()
+}
+
+// Read 从文件的给定位置读取对应的数据
+func (cio *CachedFileIO) Read(buf []byte, offset int64) (int, error) {
+	inner, err := cio.acquire()
+	if err != nil {
+		return 0, err
+	}
+	defer cio.release()
+	return inner.Read(buf, offset)
+}
+
+// Write 写入字节数组到文件中
+func (cio *CachedFileIO) Write(data []byte) (int, error) {
+	inner, err := cio.acquire()
+	if err != nil {
+		return 0, err
+	}
+	defer cio.release()
+	return inner.Write(data)
+}
+
+// Sync 持久化数据，文件已经被关闭时不需要持久化
+func (cio *CachedFileIO) Sync() error {
+	fc := cio.cache
+	fc.mu.Lock()
+	if cio.inner == nil {
+		fc.mu.Unlock()
+		return nil
+	}
+	fc.mu.Unlock()
+
+	inner, err := cio.acquire()
+	if err != nil {
+		return err
+	}
+	defer cio.release()
+	return inner.Sync()
+}
+
+// Close 关闭文件，之后不能再访问
+func (cio *CachedFileIO) Close() error {
+	fc := cio.cache
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if cio.closed {
+		return nil
+	}
+	cio.closed = true
+	if cio.inner == nil {
+		return nil
+	}
+	fc.lru.Remove(cio.elem)
+	cio.elem = nil
+	inner := cio.inner
+	cio.inner = nil
+	return inner.Close()
+}
+
+// Size 获取文件大小
+func (cio *CachedFileIO) Size() (int64, error) {
+	inner, err := cio.acquire()
+	if err != nil {
+		return 0, err
+	}
+	defer cio.release()
+	return inner.Size()
+}
+
+// Truncate 将文件截断到 size 大小
+func (cio *CachedFileIO) Truncate(size int64) error {
+	inner, err := cio.acquire()
+	if err != nil {
+		return err
+	}
+	defer cio.release()
+	truncater, ok := inner.(Truncater)
+	if !ok {
+		return errs.ErrTruncateUnsupported
+	}
+	return truncater.Truncate(size)
+}
@@ -0,0 +1,61 @@
+package fio
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileCache(t *testing.T) {
+	mfs := NewMemFileSystem()
+	assert.Nil(t, mfs.MkdirAll("/cache"))
+	fc := NewFileCache(2)
+
+	var opens int
+	var files []*CachedFileIO
+	for i := 0; i < 4; i++ {
+		name := fmt.Sprintf("/cache/%09d.data", i)
+		file, err := mfs.OpenFile(name, StandardFIO)
+		assert.Nil(t, err)
+		_, err = file.Write([]byte(name))
+		assert.Nil(t, err)
+		files = append(files, fc.Wrap(file, func() (IOManager, error) {
+			opens++
+			return mfs.OpenFile(name, StandardFIO)
+		}))
+	}
+	// 只保留最近打开的两个文件
+	assert.Equal(t, 2, fc.Len())
+
+	// 被关闭的文件在读取时重新打开
+	for i, file := range files {
+		buf := make([]byte, 21)
+		_, err := file.Read(buf, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("/cache/%09d.data", i), string(buf))
+		assert.True(t, fc.Len() <= 2)
+	}
+	assert.Equal(t, 4, opens)
+
+	// 正在使用的文件不会被关闭
+	inner, err := files[0].acquire()
+	assert.Nil(t, err)
+	for _, file := range files[1:] {
+		_, err := file.Size()
+		assert.Nil(t, err)
+	}
+	size, err := inner.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(21), size)
+	files[0].release()
+	assert.Equal(t, 2, fc.Len())
+
+	for _, file := range files {
+		assert.Nil(t, file.Close())
+	}
+	assert.Equal(t, 0, fc.Len())
+	_, err = files[0].Size()
+	assert.Equal(t, os.ErrClosed, err)
+}
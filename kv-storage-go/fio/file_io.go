@@ -1,6 +1,9 @@
 package fio
 
-import "os"
+import (
+	"os"
+	"sync"
+)
 
 const DataFilePerm = 0644
 
@@ -12,24 +15,38 @@ const (
 
 	// MemoryMap 内存文件映射
 	MemoryMap
+
+	// DirectIO 绕过页缓存的 Direct IO
+	DirectIO
+
+	// BufferedIO 带写缓冲的文件 IO
+	BufferedIO
 )
 
 // FileIO 标准系统文件 IO
+// 写入位置由 FileIO 自己维护，预分配磁盘空间之后文件大小不再是写入的位置
 type FileIO struct {
-	fd *os.File
+	fd       *os.File
+	mu       sync.Mutex
+	writeOff int64 // 下一次写入的位置
 }
 
 func NewFileIO(fileName string) (*FileIO, error) {
 	fd, err := os.OpenFile(
 		fileName,
-		os.O_CREATE|os.O_RDWR|os.O_APPEND,
+		os.O_CREATE|os.O_RDWR,
 		0644,
 	)
 	if err != nil {
 		return nil, err
 	}
+	stat, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
 
-	return &FileIO{fd: fd}, nil
+	return &FileIO{fd: fd, writeOff: stat.Size()}, nil
 }
 
 // Read 从文件的给定位置读取对应的数据
@@ -39,7 +56,11 @@ func (fio *FileIO) Read(buf []byte, offset int64) (int, error) {
 
 // Write 写入字节数组到文件中
 func (fio *FileIO) Write(data []byte) (int, error) {
-	return fio.fd.Write(data)
+	fio.mu.Lock()
+	defer fio.mu.Unlock()
+	n, err := fio.fd.WriteAt(data, fio.writeOff)
+	fio.writeOff += int64(n)
+	return n, err
 }
 
 // Sync 持久化数据
@@ -52,6 +73,31 @@ func (fio *FileIO) Close() error {
 	return fio.fd.Close()
 }
 
+// Truncate 将文件截断到 size 大小，之后的写入从新的文件末尾开始
+func (fio *FileIO) Truncate(size int64) error {
+	fio.mu.Lock()
+	defer fio.mu.Unlock()
+	if err := fio.fd.Truncate(size); err != nil {
+		return err
+	}
+	fio.writeOff = size
+	return nil
+}
+
+// Preallocate 预分配磁盘空间，将文件扩展到 size 大小，写入位置不变
+func (fio *FileIO) Preallocate(size int64) error {
+	fio.mu.Lock()
+	defer fio.mu.Unlock()
+	stat, err := fio.fd.Stat()
+	if err != nil {
+		return err
+	}
+	if stat.Size() >= size {
+		return nil
+	}
+	return fallocate(fio.fd, size)
+}
+
 func (fio *FileIO) Size() (int64, error) {
 	stat, err := fio.fd.Stat()
 	if err != nil {
@@ -95,6 +95,33 @@ func TestFileIO_Sync(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestFileIO_Preallocate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test4.data")
+	fio, err := NewFileIO(path)
+	assert.Nil(t, err)
+	defer fio.Close()
+
+	_, err = fio.Write([]byte("bitcask"))
+	assert.Nil(t, err)
+	assert.Nil(t, fio.Preallocate(4096))
+	size, err := fio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4096), size)
+
+	// 写入仍然从预分配之前的位置继续，多出的部分读取为 0
+	_, err = fio.Write([]byte(" kv"))
+	assert.Nil(t, err)
+	buf := make([]byte, 12)
+	_, err = fio.Read(buf, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bitcask kv\x00\x00"), buf)
+
+	assert.Nil(t, fio.Truncate(10))
+	size, err = fio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), size)
+}
+
 func TestFileIO_Close(t *testing.T) {
 	path := filepath.Join("/tmp", "test3.data")
 	fio, err := NewFileIO(path)
@@ -0,0 +1,105 @@
+package fio
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/gofrs/flock"
+)
+
+// FileSystem 数据目录所在的文件系统，存储引擎对目录和文件的所有操作都通过它完成
+type FileSystem interface {
+	// OpenFile 以指定的 IO 类型打开文件，文件不存在时创建
+	OpenFile(name string, ioType FileIOType) (IOManager, error)
+
+	// Stat 获取文件或目录的信息，不存在时返回的错误满足 os.IsNotExist
+	Stat(name string) (os.FileInfo, error)
+
+	// ReadDir 获取目录下的所有文件和子目录，按照名称排序
+	ReadDir(dir string) ([]os.DirEntry, error)
+
+	// MkdirAll 创建目录以及所有不存在的上级目录
+	MkdirAll(dir string) error
+
+	// Remove 删除文件或空目录
+	Remove(name string) error
+
+	// RemoveAll 删除文件或目录以及目录下的所有内容，不存在时不返回错误
+	RemoveAll(name string) error
+
+	// Rename 重命名文件，目标文件已经存在时覆盖
+	Rename(oldName, newName string) error
+
+	// TryLock 尝试获取文件锁，已经被占用时返回 false
+	TryLock(name string) (FileLock, bool, error)
+}
+
+// FileLock 文件锁
+type FileLock interface {
+	Unlock() error
+}
+
+// OSFileSystem 操作系统的文件系统
+type OSFileSystem struct{}
+
+// OS 默认使用的操作系统文件系统
+var OS FileSystem = OSFileSystem{}
+
+func (OSFileSystem) OpenFile(name string, ioType FileIOType) (IOManager, error) {
+	return NewIOManager(name, ioType)
+}
+
+func (OSFileSystem) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (OSFileSystem) ReadDir(dir string) ([]os.DirEntry, error) {
+	return os.ReadDir(dir)
+}
+
+func (OSFileSystem) MkdirAll(dir string) error {
+	return os.MkdirAll(dir, os.ModePerm)
+}
+
+func (OSFileSystem) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (OSFileSystem) RemoveAll(name string) error {
+	return os.RemoveAll(name)
+}
+
+func (OSFileSystem) Rename(oldName, newName string) error {
+	return os.Rename(oldName, newName)
+}
+
+func (OSFileSystem) TryLock(name string) (FileLock, bool, error) {
+	fileLock := flock.New(name)
+	hold, err := fileLock.TryLock()
+	return fileLock, hold, err
+}
+
+// DirSize 获取文件系统中一个目录的大小
+func DirSize(fs FileSystem, dir string) (int64, error) {
+	entries, err := fs.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, entry := range entries {
+		if entry.IsDir() {
+			subSize, err := DirSize(fs, filepath.Join(dir, entry.Name()))
+			if err != nil {
+				return 0, err
+			}
+			size += subSize
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return 0, err
+		}
+		size += info.Size()
+	}
+	return size, nil
+}
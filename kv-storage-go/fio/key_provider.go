@@ -0,0 +1,44 @@
+package fio
+
+import (
+	"crypto/aes"
+	"errors"
+)
+
+// ErrUnknownKey KeyProvider 中没有对应 id 的密钥
+var ErrUnknownKey = errors.New("unknown encryption key id")
+
+// KeyProvider 提供数据加密使用的密钥，密钥长度为 16、24 或 32 字节，分别对应 AES-128、AES-192 和 AES-256
+// 每个加密的数据块中都会记录密钥的 id，轮换密钥之后旧的数据仍然可以使用旧的密钥解密
+type KeyProvider interface {
+	// CurrentKey 返回加密新写入的数据使用的密钥以及它的 id
+	CurrentKey() (uint32, []byte, error)
+
+	// Key 返回 id 对应的密钥，用于解密
+	Key(id uint32) ([]byte, error)
+}
+
+// StaticKeyProvider 只有一个固定密钥的 KeyProvider
+type StaticKeyProvider struct {
+	id  uint32
+	key []byte
+}
+
+// NewStaticKeyProvider 使用固定的密钥创建 KeyProvider
+func NewStaticKeyProvider(id uint32, key []byte) (*StaticKeyProvider, error) {
+	if _, err := aes.NewCipher(key); err != nil {
+		return nil, err
+	}
+	return &StaticKeyProvider{id: id, key: append([]byte(nil), key...)}, nil
+}
+
+func (skp *StaticKeyProvider) CurrentKey() (uint32, []byte, error) {
+	return skp.id, skp.key, nil
+}
+
+func (skp *StaticKeyProvider) Key(id uint32) ([]byte, error) {
+	if id != skp.id {
+		return nil, ErrUnknownKey
+	}
+	return skp.key, nil
+}
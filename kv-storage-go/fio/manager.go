@@ -6,6 +6,10 @@ import "errors"
 //
 //	目前支持:
 //	      1. 标准文件 IO
+//	      2. 内存文件映射( 只读 )
+//	      3. Direct IO( 仅 Linux )
+//	      4. 带写缓冲的文件 IO
+//	      5. 内存文件( MemFileSystem )
 type IOManager interface {
 	// Read 从文件的给定位置读取对应的数据
 	Read([]byte, int64) (int, error)
@@ -23,12 +27,27 @@ type IOManager interface {
 	Size() (int64, error)
 }
 
+// Truncater 支持截断文件的 IO 管理器，用于丢弃文件末尾没有完整写入的数据
+type Truncater interface {
+	Truncate(size int64) error
+}
+
+// Preallocator 支持预分配磁盘空间的 IO 管理器，避免文件不断增长带来的磁盘碎片和元数据持久化
+// 预分配之后文件大小变为 size，多出的部分读取为 0，写入仍然从原来的位置继续
+type Preallocator interface {
+	Preallocate(size int64) error
+}
+
 func NewIOManager(fileName string, ioType FileIOType) (IOManager, error) {
 	switch ioType {
 	case StandardFIO:
 		return NewFileIO(fileName)
-	// case MemoryMap:
-	// 	return NewMMapIOManager(fileName)
+	case MemoryMap:
+		return NewMMapIOManager(fileName)
+	case DirectIO:
+		return NewDirectIOManager(fileName)
+	case BufferedIO:
+		return NewBufferedIOManager(fileName, DefaultWriteBufferSize, DefaultFlushInterval)
 	default:
 		return nil, errors.New("unsupport iotype")
 	}
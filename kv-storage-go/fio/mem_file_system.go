@@ -0,0 +1,410 @@
+package fio
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MemFileSystem 内存文件系统，文件的内容全部保存在内存中，不会访问磁盘
+// 同一个 MemFileSystem 可以被多次打开，数据在它被回收之前一直有效
+type MemFileSystem struct {
+	mu    sync.RWMutex
+	files map[string]*memFileData // 文件路径 -> 文件内容
+	dirs  map[string]bool         // 已经创建的目录
+	locks map[string]bool         // 已经被占用的文件锁
+}
+
+// memFileData 内存文件的内容，打开同一个文件的 MemFile 共享同一份数据
+type memFileData struct {
+	mu      sync.RWMutex
+	data    []byte
+	modTime time.Time
+}
+
+func NewMemFileSystem() *MemFileSystem {
+	return &MemFileSystem{
+		files: make(map[string]*memFileData),
+		dirs:  make(map[string]bool),
+		locks: make(map[string]bool),
+	}
+}
+
+func (mfs *MemFileSystem) OpenFile(name string, _ FileIOType) (IOManager, error) {
+	return mfs.openFile(name)
+}
+
+func (mfs *MemFileSystem) openFile(name string) (*MemFile, error) {
+	name = filepath.Clean(name)
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	if file, ok := mfs.files[name]; ok {
+		return &MemFile{file: file}, nil
+	}
+	if mfs.dirs[name] {
+		return nil, &os.PathError{Op: "open", Path: name, Err: errors.New("is a directory")}
+	}
+	if !mfs.dirExists(filepath.Dir(name)) {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	file := &memFileData{modTime: time.Now()}
+	mfs.files[name] = file
+	return &MemFile{file: file}, nil
+}
+
+func (mfs *MemFileSystem) Stat(name string) (os.FileInfo, error) {
+	name = filepath.Clean(name)
+	mfs.mu.RLock()
+	defer mfs.mu.RUnlock()
+
+	if file, ok := mfs.files[name]; ok {
+		return file.stat(filepath.Base(name)), nil
+	}
+	if mfs.dirExists(name) {
+		return &memFileInfo{name: filepath.Base(name), isDir: true}, nil
+	}
+	return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+}
+
+func (mfs *MemFileSystem) ReadDir(dir string) ([]os.DirEntry, error) {
+	dir = filepath.Clean(dir)
+	mfs.mu.RLock()
+	defer mfs.mu.RUnlock()
+
+	if !mfs.dirExists(dir) {
+		return nil, &os.PathError{Op: "readdir", Path: dir, Err: os.ErrNotExist}
+	}
+	var entries []os.DirEntry
+	for name, file := range mfs.files {
+		if filepath.Dir(name) == dir {
+			entries = append(entries, fs.FileInfoToDirEntry(file.stat(filepath.Base(name))))
+		}
+	}
+	for name := range mfs.dirs {
+		if name != dir && filepath.Dir(name) == dir {
+			entries = append(entries, fs.FileInfoToDirEntry(&memFileInfo{name: filepath.Base(name), isDir: true}))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+func (mfs *MemFileSystem) MkdirAll(dir string) error {
+	dir = filepath.Clean(dir)
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	for ; !mfs.dirExists(dir); dir = filepath.Dir(dir) {
+		if _, ok := mfs.files[dir]; ok {
+			return &os.PathError{Op: "mkdir", Path: dir, Err: errors.New("not a directory")}
+		}
+		mfs.dirs[dir] = true
+	}
+	return nil
+}
+
+func (mfs *MemFileSystem) Remove(name string) error {
+	name = filepath.Clean(name)
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	if _, ok := mfs.files[name]; ok {
+		delete(mfs.files, name)
+		delete(mfs.locks, name)
+		return nil
+	}
+	if !mfs.dirs[name] {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	for path := range mfs.files {
+		if filepath.Dir(path) == name {
+			return &os.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+		}
+	}
+	for path := range mfs.dirs {
+		if path != name && filepath.Dir(path) == name {
+			return &os.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+		}
+	}
+	delete(mfs.dirs, name)
+	return nil
+}
+
+func (mfs *MemFileSystem) RemoveAll(name string) error {
+	name = filepath.Clean(name)
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	for path := range mfs.files {
+		if isSubPath(name, path) {
+			delete(mfs.files, path)
+			delete(mfs.locks, path)
+		}
+	}
+	for path := range mfs.dirs {
+		if isSubPath(name, path) {
+			delete(mfs.dirs, path)
+		}
+	}
+	return nil
+}
+
+// Rename 重命名文件，已经打开的 MemFile 仍然可以继续读写
+func (mfs *MemFileSystem) Rename(oldName, newName string) error {
+	oldName, newName = filepath.Clean(oldName), filepath.Clean(newName)
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	file, ok := mfs.files[oldName]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: os.ErrNotExist}
+	}
+	if !mfs.dirExists(filepath.Dir(newName)) {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: os.ErrNotExist}
+	}
+	delete(mfs.files, oldName)
+	mfs.files[newName] = file
+	return nil
+}
+
+// TryLock 获取内存中的文件锁，与 flock 一样会创建对应的文件
+func (mfs *MemFileSystem) TryLock(name string) (FileLock, bool, error) {
+	if _, err := mfs.openFile(name); err != nil {
+		return nil, false, err
+	}
+	name = filepath.Clean(name)
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	if mfs.locks[name] {
+		return nil, false, nil
+	}
+	mfs.locks[name] = true
+	return &memFileLock{fs: mfs, name: name}, true, nil
+}
+
+// Load 将磁盘目录 src 中的文件拷贝到内存目录 dst 中
+func (mfs *MemFileSystem) Load(src, dst string) error {
+	if err := mfs.MkdirAll(dst); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		srcPath, dstPath := filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())
+		if entry.IsDir() {
+			if err := mfs.Load(srcPath, dstPath); err != nil {
+				return err
+			}
+			continue
+		}
+		data, err := os.ReadFile(srcPath)
+		if err != nil {
+			return err
+		}
+		file, err := mfs.openFile(dstPath)
+		if err != nil {
+			return err
+		}
+		file.file.reset(data)
+	}
+	return nil
+}
+
+// Save 将内存目录 src 中的文件拷贝到磁盘目录 dst 中，名称与 exclude 中的模式匹配的文件不进行拷贝
+func (mfs *MemFileSystem) Save(src, dst string, exclude []string) error {
+	if err := os.MkdirAll(dst, os.ModePerm); err != nil {
+		return err
+	}
+	entries, err := mfs.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		matched, err := matchAny(exclude, entry.Name())
+		if err != nil {
+			return err
+		}
+		if matched {
+			continue
+		}
+
+		srcPath, dstPath := filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())
+		if entry.IsDir() {
+			if err := mfs.Save(srcPath, dstPath, exclude); err != nil {
+				return err
+			}
+			continue
+		}
+		mfs.mu.RLock()
+		file := mfs.files[srcPath]
+		mfs.mu.RUnlock()
+		if file == nil {
+			continue
+		}
+		if err := os.WriteFile(dstPath, file.bytes(), DataFilePerm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dirExists 目录是否存在，调用方需要持有 mfs.mu
+func (mfs *MemFileSystem) dirExists(dir string) bool {
+	return mfs.dirs[dir] || dir == filepath.Dir(dir)
+}
+
+func isSubPath(dir, path string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+func matchAny(patterns []string, name string) (bool, error) {
+	for _, pattern := range patterns {
+		matched, err := filepath.Match(pattern, name)
+		if err != nil || matched {
+			return matched, err
+		}
+	}
+	return false, nil
+}
+
+func (file *memFileData) stat(name string) *memFileInfo {
+	file.mu.RLock()
+	defer file.mu.RUnlock()
+	return &memFileInfo{name: name, size: int64(len(file.data)), modTime: file.modTime}
+}
+
+func (file *memFileData) bytes() []byte {
+	file.mu.RLock()
+	defer file.mu.RUnlock()
+	return append([]byte(nil), file.data...)
+}
+
+func (file *memFileData) reset(data []byte) {
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	file.data = data
+	file.modTime = time.Now()
+}
+
+// MemFile 内存文件 IO
+type MemFile struct {
+	file   *memFileData
+	closed atomic.Bool
+}
+
+// Read 从文件的给定位置读取对应的数据，与 os.File.ReadAt 一样读取不完整时返回 io.EOF
+func (mf *MemFile) Read(buf []byte, offset int64) (int, error) {
+	if mf.closed.Load() {
+		return 0, os.ErrClosed
+	}
+	mf.file.mu.RLock()
+	defer mf.file.mu.RUnlock()
+
+	if offset >= int64(len(mf.file.data)) {
+		return 0, io.EOF
+	}
+	n := copy(buf, mf.file.data[offset:])
+	if n < len(buf) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Write 写入字节数组到文件末尾
+func (mf *MemFile) Write(data []byte) (int, error) {
+	if mf.closed.Load() {
+		return 0, os.ErrClosed
+	}
+	mf.file.mu.Lock()
+	defer mf.file.mu.Unlock()
+
+	mf.file.data = append(mf.file.data, data...)
+	mf.file.modTime = time.Now()
+	return len(data), nil
+}
+
+// Sync 内存文件不需要持久化
+func (mf *MemFile) Sync() error {
+	if mf.closed.Load() {
+		return os.ErrClosed
+	}
+	return nil
+}
+
+// Close 关闭文件，文件的内容仍然保留在文件系统中
+func (mf *MemFile) Close() error {
+	mf.closed.Store(true)
+	return nil
+}
+
+// Truncate 将文件截断到 size 大小
+func (mf *MemFile) Truncate(size int64) error {
+	if mf.closed.Load() {
+		return os.ErrClosed
+	}
+	mf.file.mu.Lock()
+	defer mf.file.mu.Unlock()
+
+	if size < int64(len(mf.file.data)) {
+		mf.file.data = mf.file.data[:size]
+		mf.file.modTime = time.Now()
+	}
+	return nil
+}
+
+func (mf *MemFile) Size() (int64, error) {
+	mf.file.mu.RLock()
+	defer mf.file.mu.RUnlock()
+	return int64(len(mf.file.data)), nil
+}
+
+// memFileLock 内存文件锁
+type memFileLock struct {
+	fs   *MemFileSystem
+	name string
+}
+
+func (l *memFileLock) Unlock() error {
+	l.fs.mu.Lock()
+	defer l.fs.mu.Unlock()
+	delete(l.fs.locks, l.name)
+	return nil
+}
+
+// memFileInfo 内存文件的信息
+type memFileInfo struct {
+	name    string
+	size    int64
+	isDir   bool
+	modTime time.Time
+}
+
+func (fi *memFileInfo) Name() string { return fi.name }
+
+func (fi *memFileInfo) Size() int64 { return fi.size }
+
+func (fi *memFileInfo) Mode() os.FileMode {
+	if fi.isDir {
+		return os.ModeDir | os.ModePerm
+	}
+	return DataFilePerm
+}
+
+func (fi *memFileInfo) ModTime() time.Time { return fi.modTime }
+
+func (fi *memFileInfo) IsDir() bool { return fi.isDir }
+
+func (fi *memFileInfo) Sys() any { return nil }
@@ -0,0 +1,118 @@
+package fio
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemFile_ReadWrite(t *testing.T) {
+	mfs := NewMemFileSystem()
+	assert.Nil(t, mfs.MkdirAll("/a/b"))
+
+	file, err := mfs.OpenFile("/a/b/001.data", StandardFIO)
+	assert.Nil(t, err)
+	_, err = file.Write([]byte("key-a"))
+	assert.Nil(t, err)
+	_, err = file.Write([]byte("key-b"))
+	assert.Nil(t, err)
+	size, err := file.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), size)
+
+	buf := make([]byte, 5)
+	n, err := file.Read(buf, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, []byte("key-b"), buf)
+	n, err = file.Read(buf, 8)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 2, n)
+	assert.Nil(t, file.Close())
+
+	// 重新打开可以读取到之前写入的数据
+	file, err = mfs.OpenFile("/a/b/001.data", StandardFIO)
+	assert.Nil(t, err)
+	size, err = file.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), size)
+
+	// 目录不存在
+	_, err = mfs.OpenFile("/c/001.data", StandardFIO)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestMemFileSystem_Dir(t *testing.T) {
+	mfs := NewMemFileSystem()
+	assert.Nil(t, mfs.MkdirAll("/a/b"))
+	for _, name := range []string{"/a/2.data", "/a/1.data", "/a/b/3.data"} {
+		file, err := mfs.OpenFile(name, StandardFIO)
+		assert.Nil(t, err)
+		_, err = file.Write([]byte("abc"))
+		assert.Nil(t, err)
+	}
+
+	entries, err := mfs.ReadDir("/a")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(entries))
+	assert.Equal(t, "1.data", entries[0].Name())
+	assert.Equal(t, "2.data", entries[1].Name())
+	assert.True(t, entries[2].IsDir())
+
+	size, err := DirSize(mfs, "/a")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(9), size)
+
+	assert.Nil(t, mfs.Rename("/a/b/3.data", "/a/3.data"))
+	_, err = mfs.Stat("/a/b/3.data")
+	assert.True(t, os.IsNotExist(err))
+	info, err := mfs.Stat("/a/3.data")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), info.Size())
+
+	assert.Nil(t, mfs.Remove("/a/b"))
+	assert.NotNil(t, mfs.Remove("/a"))
+	assert.Nil(t, mfs.RemoveAll("/a"))
+	_, err = mfs.Stat("/a")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestMemFileSystem_TryLock(t *testing.T) {
+	mfs := NewMemFileSystem()
+	assert.Nil(t, mfs.MkdirAll("/a"))
+
+	lock, hold, err := mfs.TryLock("/a/flock")
+	assert.Nil(t, err)
+	assert.True(t, hold)
+	_, hold, err = mfs.TryLock("/a/flock")
+	assert.Nil(t, err)
+	assert.False(t, hold)
+
+	assert.Nil(t, lock.Unlock())
+	_, hold, err = mfs.TryLock("/a/flock")
+	assert.Nil(t, err)
+	assert.True(t, hold)
+}
+
+func TestMemFileSystem_LoadSave(t *testing.T) {
+	src := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(src, "1.data"), []byte("abc"), DataFilePerm))
+	assert.Nil(t, os.WriteFile(filepath.Join(src, "flock"), nil, DataFilePerm))
+
+	mfs := NewMemFileSystem()
+	assert.Nil(t, mfs.Load(src, "/mem"))
+	info, err := mfs.Stat("/mem/1.data")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), info.Size())
+
+	dst := filepath.Join(t.TempDir(), "saved")
+	assert.Nil(t, mfs.Save("/mem", dst, []string{"flock"}))
+	data, err := os.ReadFile(filepath.Join(dst, "1.data"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("abc"), data)
+	_, err = os.Stat(filepath.Join(dst, "flock"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,78 @@
+package fio
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// ErrMMapReadOnly 内存映射的文件只能读取
+var ErrMMapReadOnly = errors.New("mmap io manager is read only")
+
+// MMap 内存文件映射 IO，只读
+// 启动时加载索引需要顺序读取全部的数据文件，通过内存映射可以减少系统调用和数据拷贝
+type MMap struct {
+	fd   *os.File
+	data []byte
+}
+
+// NewMMapIOManager 以只读的方式将文件映射到内存中，文件不存在时会创建
+func NewMMapIOManager(fileName string) (*MMap, error) {
+	fd, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDONLY, DataFilePerm)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+
+	// 空文件无法映射，直接当作没有数据
+	var data []byte
+	if stat.Size() > 0 {
+		if data, err = mmap(fd, int(stat.Size())); err != nil {
+			_ = fd.Close()
+			return nil, err
+		}
+	}
+	return &MMap{fd: fd, data: data}, nil
+}
+
+// Read 从文件的给定位置读取对应的数据
+func (mm *MMap) Read(buf []byte, offset int64) (int, error) {
+	if offset >= int64(len(mm.data)) {
+		return 0, io.EOF
+	}
+	n := copy(buf, mm.data[offset:])
+	if n < len(buf) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Write 内存映射的文件不支持写入
+func (mm *MMap) Write([]byte) (int, error) {
+	return 0, ErrMMapReadOnly
+}
+
+// Sync 只读的映射没有需要持久化的数据
+func (mm *MMap) Sync() error {
+	return nil
+}
+
+// Close 解除映射并关闭文件
+func (mm *MMap) Close() error {
+	if mm.data != nil {
+		if err := munmap(mm.data); err != nil {
+			return err
+		}
+		mm.data = nil
+	}
+	return mm.fd.Close()
+}
+
+// Size 获取文件大小，即映射时的文件大小
+func (mm *MMap) Size() (int64, error) {
+	return int64(len(mm.data)), nil
+}
@@ -0,0 +1,21 @@
+//go:build !unix
+
+package fio
+
+import (
+	"io"
+	"os"
+)
+
+// 不支持 mmap 的平台上将文件一次性读取到内存中
+func mmap(fd *os.File, size int) ([]byte, error) {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(fd, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func munmap([]byte) error {
+	return nil
+}
@@ -0,0 +1,71 @@
+package fio
+
+import (
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMMap_Read(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mmap-a.data")
+
+	// 空文件
+	mmapIO, err := NewMMapIOManager(path)
+	assert.Nil(t, err)
+	size, err := mmapIO.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), size)
+	n, err := mmapIO.Read(make([]byte, 10), 0)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, io.EOF, err)
+	assert.Nil(t, mmapIO.Close())
+
+	fio, err := NewFileIO(path)
+	assert.Nil(t, err)
+	_, err = fio.Write([]byte("aa"))
+	assert.Nil(t, err)
+	_, err = fio.Write([]byte("bb"))
+	assert.Nil(t, err)
+	_, err = fio.Write([]byte("cc"))
+	assert.Nil(t, err)
+	assert.Nil(t, fio.Close())
+
+	mmapIO, err = NewMMapIOManager(path)
+	assert.Nil(t, err)
+	defer mmapIO.Close()
+	size, err = mmapIO.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), size)
+
+	b1 := make([]byte, 2)
+	n, err = mmapIO.Read(b1, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []byte("bb"), b1)
+
+	// 读取超出文件末尾
+	b2 := make([]byte, 4)
+	n, err = mmapIO.Read(b2, 4)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []byte("cc"), b2[:n])
+
+	_, err = mmapIO.Write([]byte("dd"))
+	assert.Equal(t, ErrMMapReadOnly, err)
+	assert.Nil(t, mmapIO.Sync())
+}
+
+func TestNewIOManager(t *testing.T) {
+	dir := t.TempDir()
+	ioManager, err := NewIOManager(filepath.Join(dir, "a.data"), StandardFIO)
+	assert.Nil(t, err)
+	assert.IsType(t, &FileIO{}, ioManager)
+	assert.Nil(t, ioManager.Close())
+
+	ioManager, err = NewIOManager(filepath.Join(dir, "b.data"), MemoryMap)
+	assert.Nil(t, err)
+	assert.IsType(t, &MMap{}, ioManager)
+	assert.Nil(t, ioManager.Close())
+}
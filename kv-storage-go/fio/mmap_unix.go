@@ -0,0 +1,16 @@
+//go:build unix
+
+package fio
+
+import (
+	"os"
+	"syscall"
+)
+
+func mmap(fd *os.File, size int) ([]byte, error) {
+	return syscall.Mmap(int(fd.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmap(data []byte) error {
+	return syscall.Munmap(data)
+}
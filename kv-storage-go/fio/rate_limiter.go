@@ -0,0 +1,68 @@
+package fio
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter 令牌桶限速器，每秒产生 rate 个令牌，最多积累一秒的令牌
+// 一次请求的令牌超过桶中剩余的数量时可以预支，之后的请求等待令牌补足，rate 为 0 时不限速
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimiter(bytesPerSec int64) *RateLimiter {
+	return &RateLimiter{rate: bytesPerSec, tokens: float64(bytesPerSec), last: time.Now()}
+}
+
+// SetRate 修改限速，正在等待的请求按照新的速度重新计算等待时间
+func (rl *RateLimiter) SetRate(bytesPerSec int64) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.refill(time.Now())
+	rl.rate = bytesPerSec
+	rl.tokens = min(rl.tokens, float64(bytesPerSec))
+}
+
+// Rate 获取当前的限速
+func (rl *RateLimiter) Rate() int64 {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.rate
+}
+
+// Wait 获取 n 个令牌，令牌不足时阻塞等待
+func (rl *RateLimiter) Wait(n int) {
+	if rl == nil || n <= 0 {
+		return
+	}
+	for {
+		rl.mu.Lock()
+		if rl.rate <= 0 {
+			rl.mu.Unlock()
+			return
+		}
+		now := time.Now()
+		rl.refill(now)
+		// 桶中有令牌时直接预支，否则等待令牌恢复为正数
+		if rl.tokens > 0 {
+			rl.tokens -= float64(n)
+			rl.mu.Unlock()
+			return
+		}
+		wait := time.Duration(-rl.tokens / float64(rl.rate) * float64(time.Second))
+		rl.mu.Unlock()
+		// 分段等待，限速修改之后尽快生效
+		time.Sleep(min(max(wait, time.Millisecond), 100*time.Millisecond))
+	}
+}
+
+func (rl *RateLimiter) refill(now time.Time) {
+	if rl.rate > 0 {
+		rl.tokens = min(rl.tokens+now.Sub(rl.last).Seconds()*float64(rl.rate), float64(rl.rate))
+	}
+	rl.last = now
+}
@@ -0,0 +1,73 @@
+package fio
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter_Wait(t *testing.T) {
+	// 不限速时不会等待
+	start := time.Now()
+	NewRateLimiter(0).Wait(1 << 30)
+	var nilLimiter *RateLimiter
+	nilLimiter.Wait(1 << 30)
+	assert.True(t, time.Since(start) < 50*time.Millisecond)
+
+	// 桶中积累的令牌可以直接使用，之后按照限速等待
+	rl := NewRateLimiter(10000)
+	start = time.Now()
+	rl.Wait(10000)
+	assert.True(t, time.Since(start) < 50*time.Millisecond)
+	rl.Wait(2000)
+	rl.Wait(1)
+	assert.True(t, time.Since(start) >= 150*time.Millisecond)
+}
+
+func TestRateLimiter_SetRate(t *testing.T) {
+	rl := NewRateLimiter(100)
+	rl.Wait(100)
+	rl.Wait(10000)
+
+	// 修改限速之后正在等待的请求立即生效
+	done := make(chan struct{})
+	go func() {
+		rl.Wait(1)
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	rl.SetRate(0)
+	assert.Equal(t, int64(0), rl.Rate())
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the waiting request is not released after the rate changed")
+	}
+}
+
+func TestThrottledFileIO(t *testing.T) {
+	mfs := NewMemFileSystem()
+	assert.Nil(t, mfs.MkdirAll("/throttled"))
+	file, err := mfs.OpenFile("/throttled/001.data", StandardFIO)
+	assert.Nil(t, err)
+
+	writeLimiter := NewRateLimiter(1000)
+	tio := NewThrottledIOManager(file, nil, writeLimiter)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		_, err := tio.Write(make([]byte, 1000))
+		assert.Nil(t, err)
+	}
+	assert.True(t, time.Since(start) >= 900*time.Millisecond)
+
+	// 读取没有限速
+	buf := make([]byte, 3000)
+	n, err := tio.Read(buf, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 3000, n)
+	assert.Nil(t, tio.Truncate(1000))
+	size, err := tio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1000), size)
+}
@@ -0,0 +1,59 @@
+package fio
+
+import "github.com/tClown11/kv-storage/errs"
+
+// ThrottledFileIO 限速的文件 IO，读写之前从对应的限速器中获取与数据大小相同的令牌
+// 限速器为空时不限速，多个文件可以共享同一个限速器，共同使用一份带宽
+type ThrottledFileIO struct {
+	inner        IOManager
+	readLimiter  *RateLimiter
+	writeLimiter *RateLimiter
+}
+
+func NewThrottledIOManager(inner IOManager, readLimiter, writeLimiter *RateLimiter) *ThrottledFileIO {
+	return &ThrottledFileIO{inner: inner, readLimiter: readLimiter, writeLimiter: writeLimiter}
+}
+
+// Read 从文件的给定位置读取对应的数据
+func (tio *ThrottledFileIO) Read(buf []byte, offset int64) (int, error) {
+	tio.readLimiter.Wait(len(buf))
+	return tio.inner.Read(buf, offset)
+}
+
+// Write 写入字节数组到文件中
+func (tio *ThrottledFileIO) Write(data []byte) (int, error) {
+	tio.writeLimiter.Wait(len(data))
+	return tio.inner.Write(data)
+}
+
+// Sync 持久化数据
+func (tio *ThrottledFileIO) Sync() error {
+	return tio.inner.Sync()
+}
+
+// Close 关闭文件
+func (tio *ThrottledFileIO) Close() error {
+	return tio.inner.Close()
+}
+
+// Size 获取文件大小
+func (tio *ThrottledFileIO) Size() (int64, error) {
+	return tio.inner.Size()
+}
+
+// Truncate 将文件截断到 size 大小
+func (tio *ThrottledFileIO) Truncate(size int64) error {
+	truncater, ok := tio.inner.(Truncater)
+	if !ok {
+		return errs.ErrTruncateUnsupported
+	}
+	return truncater.Truncate(size)
+}
+
+// Preallocate 预分配磁盘空间，底层的 IO 不支持时忽略
+func (tio *ThrottledFileIO) Preallocate(size int64) error {
+	if preallocator, ok := tio.inner.(Preallocator); ok {
+		return preallocator.Preallocate(size)
+	}
+	return nil
+}
@@ -0,0 +1,636 @@
+package index
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/tClown11/kv-storage/structure"
+)
+
+// 自适应基数树的内部节点类型，按照子节点数量自动升级或降级
+type artKind uint8
+
+const (
+	artNode4 artKind = iota
+	artNode16
+	artNode48
+	artNode256
+)
+
+// 各类内部节点可以容纳的最大子节点数量
+const (
+	artNode4Max   = 4
+	artNode16Max  = 16
+	artNode48Max  = 48
+	artNode256Max = 256
+)
+
+// 删除数据后节点降级的阈值，略低于下一级的容量，避免在边界上反复升降级
+const (
+	artNode16Min  = 3
+	artNode48Min  = 12
+	artNode256Min = 37
+)
+
+// artNode 树中的节点，只会是 *artInner 或 *artLeaf
+type artNode interface{}
+
+// artLeaf 叶子节点，只保存 key 在父节点之后剩余的部分，公共前缀不重复存储
+type artLeaf struct {
+	suffix []byte
+	pos    *structure.LogRecordPos
+}
+
+// artInner 内部节点
+type artInner struct {
+	kind     artKind
+	prefix   []byte    // 路径压缩后的公共前缀
+	leaf     *artLeaf  // 恰好在当前节点结束的 key
+	size     int       // 子节点的数量
+	count    int       // 子树中 key 的数量，用于范围计数
+	keys     []byte    // node4/node16 为有序的子节点字节，node48 为 256 长度的槽位索引( 槽位下标 + 1 )
+	children []artNode // 子节点，node256 直接以字节作为下标
+}
+
+// AdaptiveRadixTree 自适应基数树索引
+// 对于大量共享前缀的 key，公共部分只会在内部节点中存储一次，比 BTree 更节省内存。
+// 树本身按照字节序组织，使用其他比较器时遍历需要对快照重新排序，范围计数退化为线性扫描
+type AdaptiveRadixTree struct {
+	root artNode
+	size int
+	cmp  Comparator
+	lock *sync.RWMutex
+}
+
+// NewART 初始化自适应基数树索引，cmp 为 nil 时按照字节序排序
+func NewART(cmp Comparator) *AdaptiveRadixTree {
+	return &AdaptiveRadixTree{
+		cmp:  OrDefault(cmp),
+		lock: new(sync.RWMutex),
+	}
+}
+
+func (art *AdaptiveRadixTree) Put(key []byte, pos *structure.LogRecordPos) *structure.LogRecordPos {
+	art.lock.Lock()
+	defer art.lock.Unlock()
+
+	oldPos, inserted := artInsert(&art.root, key, 0, pos)
+	if inserted {
+		art.size++
+	}
+	return oldPos
+}
+
+func (art *AdaptiveRadixTree) Get(key []byte) *structure.LogRecordPos {
+	art.lock.RLock()
+	defer art.lock.RUnlock()
+
+	node, depth := art.root, 0
+	for {
+		switch n := node.(type) {
+		case *artLeaf:
+			if bytes.Equal(n.suffix, key[depth:]) {
+				return n.pos
+			}
+			return nil
+		case *artInner:
+			if !bytes.HasPrefix(key[depth:], n.prefix) {
+				return nil
+			}
+			depth += len(n.prefix)
+			if depth == len(key) {
+				if n.leaf == nil {
+					return nil
+				}
+				return n.leaf.pos
+			}
+			slot := n.findChild(key[depth])
+			if slot == nil {
+				return nil
+			}
+			node = *slot
+			depth++
+		default:
+			return nil
+		}
+	}
+}
+
+func (art *AdaptiveRadixTree) Delete(key []byte) (*structure.LogRecordPos, bool) {
+	art.lock.Lock()
+	defer art.lock.Unlock()
+
+	oldPos, ok := artDelete(&art.root, key, 0)
+	if ok {
+		art.size--
+	}
+	return oldPos, ok
+}
+
+func (art *AdaptiveRadixTree) Iterator(reverse bool) Iterator {
+	art.lock.RLock()
+	defer art.lock.RUnlock()
+
+	// 将所有的数据存放到数组中，树中的数据按照字节序排列，使用其他比较器时需要重新排序
+	values := make([]*indexItem, 0, art.size)
+	art.walk(reverse, func(key []byte, pos *structure.LogRecordPos) bool {
+		values = append(values, &indexItem{key: key, pos: pos})
+		return true
+	})
+	if !IsBytewise(art.cmp) {
+		sortIndexItems(values, reverse, art.cmp)
+	}
+	return newSliceIterator(values, reverse, art.cmp)
+}
+
+func (art *AdaptiveRadixTree) Size() int {
+	art.lock.RLock()
+	defer art.lock.RUnlock()
+	return art.size
+}
+
+func (art *AdaptiveRadixTree) Close() error {
+	return nil
+}
+
+// CountRange 统计 [lowerBound, upperBound) 范围内 key 的数量，边界为 nil 表示不限制
+// 内部节点记录了子树中 key 的数量，只需要沿着边界所在的路径向下查找
+func (art *AdaptiveRadixTree) CountRange(lowerBound, upperBound []byte) int {
+	art.lock.RLock()
+	defer art.lock.RUnlock()
+
+	if !IsBytewise(art.cmp) {
+		var count int
+		art.walk(false, func(key []byte, _ *structure.LogRecordPos) bool {
+			if (lowerBound == nil || art.cmp.Compare(key, lowerBound) >= 0) &&
+				(upperBound == nil || art.cmp.Compare(key, upperBound) < 0) {
+				count++
+			}
+			return true
+		})
+		return count
+	}
+
+	upper := art.size
+	if upperBound != nil {
+		upper = artCountLess(art.root, upperBound)
+	}
+	var lower int
+	if lowerBound != nil {
+		lower = artCountLess(art.root, lowerBound)
+	}
+	if upper < lower {
+		return 0
+	}
+	return upper - lower
+}
+
+// walk 按照 key 的字典序遍历整棵树，fn 返回 false 时终止遍历
+func (art *AdaptiveRadixTree) walk(reverse bool, fn func(key []byte, pos *structure.LogRecordPos) bool) {
+	artWalk(art.root, nil, reverse, fn)
+}
+
+// artInsert 插入数据，返回旧的位置信息，以及是否新增了 key
+func artInsert(ref *artNode, key []byte, depth int, pos *structure.LogRecordPos) (*structure.LogRecordPos, bool) {
+	rest := key[depth:]
+	switch n := (*ref).(type) {
+	case *artLeaf:
+		if bytes.Equal(n.suffix, rest) {
+			oldPos := n.pos
+			n.pos = pos
+			return oldPos, false
+		}
+
+		// 两个 key 在此处分叉，使用新的内部节点保存公共前缀
+		common := commonPrefixLen(n.suffix, rest)
+		inner := newArtInner(rest[:common])
+		n.suffix = n.suffix[common:]
+		inner.attachLeaf(n)
+		inner.attachLeaf(newArtLeaf(rest[common:], pos))
+		inner.count = 2
+		*ref = inner
+		return nil, true
+	case *artInner:
+		p := commonPrefixLen(n.prefix, rest)
+		if p < len(n.prefix) {
+			// 前缀不匹配，拆分当前节点的前缀
+			inner := newArtInner(n.prefix[:p])
+			b := n.prefix[p]
+			n.prefix = n.prefix[p+1:]
+			inner.addChild(b, n)
+			inner.attachLeaf(newArtLeaf(rest[p:], pos))
+			inner.count = n.count + 1
+			*ref = inner
+			return nil, true
+		}
+
+		depth += len(n.prefix)
+		if depth == len(key) {
+			if n.leaf != nil {
+				oldPos := n.leaf.pos
+				n.leaf.pos = pos
+				return oldPos, false
+			}
+			n.leaf = &artLeaf{pos: pos}
+			n.count++
+			return nil, true
+		}
+
+		if slot := n.findChild(key[depth]); slot != nil {
+			oldPos, inserted := artInsert(slot, key, depth+1, pos)
+			if inserted {
+				n.count++
+			}
+			return oldPos, inserted
+		}
+		n.addChild(key[depth], newArtLeaf(key[depth+1:], pos))
+		n.count++
+		return nil, true
+	default:
+		*ref = newArtLeaf(rest, pos)
+		return nil, true
+	}
+}
+
+// artDelete 删除数据，返回旧的位置信息，以及 key 是否存在
+func artDelete(ref *artNode, key []byte, depth int) (*structure.LogRecordPos, bool) {
+	switch n := (*ref).(type) {
+	case *artLeaf:
+		if !bytes.Equal(n.suffix, key[depth:]) {
+			return nil, false
+		}
+		*ref = nil
+		return n.pos, true
+	case *artInner:
+		if !bytes.HasPrefix(key[depth:], n.prefix) {
+			return nil, false
+		}
+		depth += len(n.prefix)
+
+		var oldPos *structure.LogRecordPos
+		if depth == len(key) {
+			if n.leaf == nil {
+				return nil, false
+			}
+			oldPos, n.leaf = n.leaf.pos, nil
+		} else {
+			slot := n.findChild(key[depth])
+			if slot == nil {
+				return nil, false
+			}
+			var ok bool
+			if oldPos, ok = artDelete(slot, key, depth+1); !ok {
+				return nil, false
+			}
+			if *slot == nil {
+				n.removeChild(key[depth])
+			}
+		}
+		n.count--
+		*ref = n.compact()
+		return oldPos, true
+	default:
+		return nil, false
+	}
+}
+
+// artCount 子树中 key 的数量
+func artCount(node artNode) int {
+	switch n := node.(type) {
+	case *artLeaf:
+		return 1
+	case *artInner:
+		return n.count
+	}
+	return 0
+}
+
+// artCountLess 子树中小于 key 的 key 的数量，key 为相对当前节点位置剩余的部分
+func artCountLess(node artNode, key []byte) int {
+	switch n := node.(type) {
+	case *artLeaf:
+		if bytes.Compare(n.suffix, key) < 0 {
+			return 1
+		}
+	case *artInner:
+		p := commonPrefixLen(n.prefix, key)
+		if p < len(n.prefix) {
+			// 在前缀处分叉，子树中的 key 要么全部小于 key，要么全部大于 key
+			if p < len(key) && n.prefix[p] < key[p] {
+				return n.count
+			}
+			return 0
+		}
+		rest := key[len(n.prefix):]
+		if len(rest) == 0 {
+			return 0
+		}
+
+		// 在当前节点结束的 key 以及字节更小的子树中的 key 都小于 key
+		var count int
+		if n.leaf != nil {
+			count++
+		}
+		n.forEachChild(false, func(b byte, child artNode) bool {
+			if b >= rest[0] {
+				return false
+			}
+			count += artCount(child)
+			return true
+		})
+		if slot := n.findChild(rest[0]); slot != nil {
+			count += artCountLess(*slot, rest[1:])
+		}
+		return count
+	}
+	return 0
+}
+
+// artWalk 深度优先遍历，buf 为当前节点之前的 key 前缀
+func artWalk(node artNode, buf []byte, reverse bool, fn func(key []byte, pos *structure.LogRecordPos) bool) bool {
+	switch n := node.(type) {
+	case *artLeaf:
+		key := make([]byte, len(buf)+len(n.suffix))
+		copy(key, buf)
+		copy(key[len(buf):], n.suffix)
+		return fn(key, n.pos)
+	case *artInner:
+		buf = append(buf, n.prefix...)
+		// 当前节点上结束的 key 比所有子节点中的 key 都小
+		if !reverse && n.leaf != nil && !artWalk(n.leaf, buf, reverse, fn) {
+			return false
+		}
+		if !n.forEachChild(reverse, func(b byte, child artNode) bool {
+			return artWalk(child, append(buf, b), reverse, fn)
+		}) {
+			return false
+		}
+		if reverse && n.leaf != nil {
+			return artWalk(n.leaf, buf, reverse, fn)
+		}
+	}
+	return true
+}
+
+// newArtLeaf 初始化叶子节点，拷贝 suffix，避免引用调用方( 例如整条 LogRecord )的内存
+func newArtLeaf(suffix []byte, pos *structure.LogRecordPos) *artLeaf {
+	return &artLeaf{
+		suffix: append([]byte(nil), suffix...),
+		pos:    pos,
+	}
+}
+
+func newArtInner(prefix []byte) *artInner {
+	return &artInner{
+		kind:     artNode4,
+		prefix:   append([]byte(nil), prefix...),
+		keys:     make([]byte, 0, artNode4Max),
+		children: make([]artNode, 0, artNode4Max),
+	}
+}
+
+// attachLeaf 将叶子节点挂到当前节点下，叶子的 suffix 为相对当前节点前缀之后的部分
+func (n *artInner) attachLeaf(leaf *artLeaf) {
+	if len(leaf.suffix) == 0 {
+		n.leaf = leaf
+		return
+	}
+	b := leaf.suffix[0]
+	leaf.suffix = leaf.suffix[1:]
+	n.addChild(b, leaf)
+}
+
+// findChild 查找字节 b 对应的子节点槽位
+func (n *artInner) findChild(b byte) *artNode {
+	switch n.kind {
+	case artNode4, artNode16:
+		for i := 0; i < n.size; i++ {
+			if n.keys[i] == b {
+				return &n.children[i]
+			}
+		}
+	case artNode48:
+		if idx := n.keys[b]; idx > 0 {
+			return &n.children[idx-1]
+		}
+	case artNode256:
+		if n.children[b] != nil {
+			return &n.children[b]
+		}
+	}
+	return nil
+}
+
+// addChild 添加子节点，节点已满时先升级为更大的节点类型
+func (n *artInner) addChild(b byte, child artNode) {
+	switch n.kind {
+	case artNode4, artNode16:
+		if (n.kind == artNode4 && n.size == artNode4Max) || (n.kind == artNode16 && n.size == artNode16Max) {
+			n.grow()
+			n.addChild(b, child)
+			return
+		}
+		// 保持 keys 有序
+		idx := 0
+		for idx < n.size && n.keys[idx] < b {
+			idx++
+		}
+		n.keys = append(n.keys, 0)
+		n.children = append(n.children, nil)
+		copy(n.keys[idx+1:], n.keys[idx:n.size])
+		copy(n.children[idx+1:], n.children[idx:n.size])
+		n.keys[idx] = b
+		n.children[idx] = child
+	case artNode48:
+		if n.size == artNode48Max {
+			n.grow()
+			n.addChild(b, child)
+			return
+		}
+		slot := 0
+		for n.children[slot] != nil {
+			slot++
+		}
+		n.children[slot] = child
+		n.keys[b] = byte(slot + 1)
+	case artNode256:
+		n.children[b] = child
+	}
+	n.size++
+}
+
+// removeChild 移除字节 b 对应的子节点
+func (n *artInner) removeChild(b byte) {
+	switch n.kind {
+	case artNode4, artNode16:
+		idx := 0
+		for idx < n.size && n.keys[idx] != b {
+			idx++
+		}
+		if idx == n.size {
+			return
+		}
+		copy(n.keys[idx:], n.keys[idx+1:])
+		copy(n.children[idx:], n.children[idx+1:])
+		n.keys = n.keys[:n.size-1]
+		n.children[n.size-1] = nil
+		n.children = n.children[:n.size-1]
+	case artNode48:
+		idx := n.keys[b]
+		if idx == 0 {
+			return
+		}
+		n.children[idx-1] = nil
+		n.keys[b] = 0
+	case artNode256:
+		if n.children[b] == nil {
+			return
+		}
+		n.children[b] = nil
+	}
+	n.size--
+}
+
+// grow 升级为更大的节点类型
+func (n *artInner) grow() {
+	switch n.kind {
+	case artNode4:
+		keys := make([]byte, n.size, artNode16Max)
+		children := make([]artNode, n.size, artNode16Max)
+		copy(keys, n.keys)
+		copy(children, n.children)
+		n.kind, n.keys, n.children = artNode16, keys, children
+	case artNode16:
+		keys := make([]byte, artNode256Max)
+		children := make([]artNode, artNode48Max)
+		for i := 0; i < n.size; i++ {
+			keys[n.keys[i]] = byte(i + 1)
+			children[i] = n.children[i]
+		}
+		n.kind, n.keys, n.children = artNode48, keys, children
+	case artNode48:
+		children := make([]artNode, artNode256Max)
+		for b := 0; b < artNode256Max; b++ {
+			if idx := n.keys[b]; idx > 0 {
+				children[b] = n.children[idx-1]
+			}
+		}
+		n.kind, n.keys, n.children = artNode256, nil, children
+	}
+}
+
+// shrink 降级为更小的节点类型
+func (n *artInner) shrink() {
+	keys := make([]byte, 0, artNode16Max)
+	children := make([]artNode, 0, artNode16Max)
+	switch n.kind {
+	case artNode16:
+		keys = append(make([]byte, 0, artNode4Max), n.keys...)
+		children = append(make([]artNode, 0, artNode4Max), n.children...)
+		n.kind = artNode4
+	case artNode48:
+		n.forEachChild(false, func(b byte, child artNode) bool {
+			keys = append(keys, b)
+			children = append(children, child)
+			return true
+		})
+		n.kind = artNode16
+	case artNode256:
+		keys = make([]byte, artNode256Max)
+		children = make([]artNode, artNode48Max)
+		slot := 0
+		n.forEachChild(false, func(b byte, child artNode) bool {
+			keys[b] = byte(slot + 1)
+			children[slot] = child
+			slot++
+			return true
+		})
+		n.kind = artNode48
+	}
+	n.keys, n.children = keys, children
+}
+
+// compact 删除数据后整理节点：合并只剩一个分支的节点，或者降级节点类型
+func (n *artInner) compact() artNode {
+	switch {
+	case n.size == 0 && n.leaf == nil:
+		return nil
+	case n.size == 0:
+		n.leaf.suffix = n.prefix
+		return n.leaf
+	case n.size == 1 && n.leaf == nil:
+		var (
+			b     byte
+			child artNode
+		)
+		n.forEachChild(false, func(cb byte, c artNode) bool {
+			b, child = cb, c
+			return false
+		})
+		merged := make([]byte, 0, len(n.prefix)+1)
+		merged = append(append(merged, n.prefix...), b)
+		switch c := child.(type) {
+		case *artLeaf:
+			c.suffix = append(merged, c.suffix...)
+		case *artInner:
+			c.prefix = append(merged, c.prefix...)
+		}
+		return child
+	}
+
+	if (n.kind == artNode16 && n.size <= artNode16Min) ||
+		(n.kind == artNode48 && n.size <= artNode48Min) ||
+		(n.kind == artNode256 && n.size <= artNode256Min) {
+		n.shrink()
+	}
+	return n
+}
+
+// forEachChild 按照字节顺序遍历子节点
+func (n *artInner) forEachChild(reverse bool, fn func(b byte, child artNode) bool) bool {
+	switch n.kind {
+	case artNode4, artNode16:
+		for i := 0; i < n.size; i++ {
+			idx := i
+			if reverse {
+				idx = n.size - 1 - i
+			}
+			if !fn(n.keys[idx], n.children[idx]) {
+				return false
+			}
+		}
+	case artNode48, artNode256:
+		for i := 0; i < artNode256Max; i++ {
+			b := i
+			if reverse {
+				b = artNode256Max - 1 - i
+			}
+			var child artNode
+			if n.kind == artNode48 {
+				if idx := n.keys[b]; idx > 0 {
+					child = n.children[idx-1]
+				}
+			} else {
+				child = n.children[b]
+			}
+			if child != nil && !fn(byte(b), child) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// commonPrefixLen 两个字节数组公共前缀的长度
+func commonPrefixLen(a, b []byte) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
@@ -0,0 +1,231 @@
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tClown11/kv-storage/structure"
+)
+
+func TestART_Put(t *testing.T) {
+	art := NewART(nil)
+	tests := []struct {
+		key    []byte
+		pos    *structure.LogRecordPos
+		result *structure.LogRecordPos
+	}{
+		{
+			key:    nil,
+			pos:    &structure.LogRecordPos{Fid: 1, Offset: 100},
+			result: nil,
+		},
+		{
+			key:    []byte("a"),
+			pos:    &structure.LogRecordPos{Fid: 1, Offset: 2},
+			result: nil,
+		},
+		{
+			key:    []byte("a"),
+			pos:    &structure.LogRecordPos{Fid: 11, Offset: 12},
+			result: &structure.LogRecordPos{Fid: 1, Offset: 2},
+		},
+		{
+			// 与已有 key 共享前缀
+			key:    []byte("abc"),
+			pos:    &structure.LogRecordPos{Fid: 1, Offset: 3},
+			result: nil,
+		},
+	}
+
+	for i := range tests {
+		res := art.Put(tests[i].key, tests[i].pos)
+		assert.Equal(t, tests[i].result, res)
+	}
+	assert.Equal(t, 3, art.Size())
+}
+
+func TestART_Get(t *testing.T) {
+	art := NewART(nil)
+	art.Put([]byte("/usr/local/bin"), &structure.LogRecordPos{Fid: 1, Offset: 10})
+	art.Put([]byte("/usr/local"), &structure.LogRecordPos{Fid: 1, Offset: 20})
+	art.Put([]byte("/usr/lib"), &structure.LogRecordPos{Fid: 1, Offset: 30})
+
+	tests := []struct {
+		key    []byte
+		result *structure.LogRecordPos
+	}{
+		{key: []byte("/usr/local/bin"), result: &structure.LogRecordPos{Fid: 1, Offset: 10}},
+		{key: []byte("/usr/local"), result: &structure.LogRecordPos{Fid: 1, Offset: 20}},
+		{key: []byte("/usr/lib"), result: &structure.LogRecordPos{Fid: 1, Offset: 30}},
+		{key: []byte("/usr"), result: nil},
+		{key: []byte("/usr/local/bin/go"), result: nil},
+		{key: []byte("unknown"), result: nil},
+	}
+
+	for i := range tests {
+		assert.Equal(t, tests[i].result, art.Get(tests[i].key))
+	}
+}
+
+func TestART_Delete(t *testing.T) {
+	art := NewART(nil)
+	art.Put([]byte("/usr/local/bin"), &structure.LogRecordPos{Fid: 1, Offset: 10})
+	art.Put([]byte("/usr/local"), &structure.LogRecordPos{Fid: 1, Offset: 20})
+
+	tests := []struct {
+		key    []byte
+		result *structure.LogRecordPos
+		ok     bool
+	}{
+		{key: []byte("/usr"), result: nil, ok: false},
+		{key: []byte("/usr/local"), result: &structure.LogRecordPos{Fid: 1, Offset: 20}, ok: true},
+		{key: []byte("/usr/local"), result: nil, ok: false},
+		{key: []byte("/usr/local/bin"), result: &structure.LogRecordPos{Fid: 1, Offset: 10}, ok: true},
+	}
+
+	for i := range tests {
+		res, ok := art.Delete(tests[i].key)
+		assert.Equal(t, tests[i].ok, ok)
+		assert.Equal(t, tests[i].result, res)
+	}
+	assert.Equal(t, 0, art.Size())
+	assert.Nil(t, art.root)
+}
+
+func TestART_Iterator(t *testing.T) {
+	art := NewART(nil)
+
+	// 空树
+	iter := art.Iterator(false)
+	assert.Equal(t, false, iter.Valid())
+	iter.Close()
+
+	keys := []string{"ccde", "acee", "eede", "bbcd", "cc", "ccdf"}
+	for i, key := range keys {
+		art.Put([]byte(key), &structure.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	var forward []string
+	iter1 := art.Iterator(false)
+	for iter1.Rewind(); iter1.Valid(); iter1.Next() {
+		assert.NotNil(t, iter1.Value())
+		forward = append(forward, string(iter1.Key()))
+	}
+	assert.Equal(t, []string{"acee", "bbcd", "cc", "ccde", "ccdf", "eede"}, forward)
+
+	iter1.Seek([]byte("cd"))
+	assert.Equal(t, []byte("eede"), iter1.Key())
+
+	var backward []string
+	iter2 := art.Iterator(true)
+	for iter2.Rewind(); iter2.Valid(); iter2.Next() {
+		backward = append(backward, string(iter2.Key()))
+	}
+	assert.Equal(t, []string{"eede", "ccdf", "ccde", "cc", "bbcd", "acee"}, backward)
+
+	iter2.Seek([]byte("cd"))
+	assert.Equal(t, []byte("ccdf"), iter2.Key())
+}
+
+// 随机数据与 map 对比，覆盖节点的升级、降级和路径合并
+func TestART_Random(t *testing.T) {
+	art := NewART(nil)
+	expected := make(map[string]*structure.LogRecordPos)
+	r := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 20000; i++ {
+		key := []byte(fmt.Sprintf("/data/%d/%d", r.Intn(50), r.Intn(300)))
+		if i%2 == 0 {
+			// 任意字节的短 key，使节点升级到 node48 和 node256
+			key = make([]byte, 1+r.Intn(2))
+			r.Read(key)
+		}
+		if r.Intn(3) == 0 {
+			_, ok := art.Delete(key)
+			_, exists := expected[string(key)]
+			assert.Equal(t, exists, ok)
+			delete(expected, string(key))
+			continue
+		}
+		pos := &structure.LogRecordPos{Fid: uint32(i), Offset: int64(i)}
+		art.Put(key, pos)
+		expected[string(key)] = pos
+	}
+	assert.Equal(t, len(expected), art.Size())
+
+	var sortedKeys []string
+	for key, pos := range expected {
+		assert.Equal(t, pos, art.Get([]byte(key)))
+		sortedKeys = append(sortedKeys, key)
+	}
+	sort.Strings(sortedKeys)
+
+	iter := art.Iterator(false)
+	defer iter.Close()
+	var idx int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.True(t, bytes.Equal([]byte(sortedKeys[idx]), iter.Key()))
+		idx++
+	}
+	assert.Equal(t, len(sortedKeys), idx)
+}
+
+func TestART_CountRange(t *testing.T) {
+	art := NewART(nil)
+	assert.Equal(t, 0, art.CountRange(nil, nil))
+
+	r := rand.New(rand.NewSource(1))
+	expected := make(map[string]struct{})
+	for i := 0; i < 5000; i++ {
+		key := []byte(fmt.Sprintf("/data/%d/%d", r.Intn(30), r.Intn(100)))
+		if i%3 == 0 {
+			key = make([]byte, 1+r.Intn(3))
+			r.Read(key)
+		}
+		if r.Intn(4) == 0 {
+			art.Delete(key)
+			delete(expected, string(key))
+			continue
+		}
+		art.Put(key, &structure.LogRecordPos{Fid: 1, Offset: int64(i)})
+		expected[string(key)] = struct{}{}
+	}
+
+	countRange := func(lower, upper []byte) int {
+		var count int
+		for key := range expected {
+			if (lower == nil || key >= string(lower)) && (upper == nil || key < string(upper)) {
+				count++
+			}
+		}
+		return count
+	}
+
+	tests := [][2][]byte{
+		{nil, nil},
+		{[]byte("/data/1"), []byte("/data/2")},
+		{[]byte("/data/1/"), nil},
+		{nil, []byte("/data/15/50")},
+		{[]byte("/data/15/50"), []byte("/data/15/50")},
+		{[]byte("/data/2"), []byte("/data/1")},
+		{[]byte{0x10}, []byte{0x80, 0x01}},
+		{[]byte(""), []byte{0xff, 0xff, 0xff, 0xff}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, countRange(tt[0], tt[1]), art.CountRange(tt[0], tt[1]))
+	}
+	for i := 0; i < 200; i++ {
+		lower, upper := make([]byte, 1+r.Intn(8)), make([]byte, 1+r.Intn(8))
+		r.Read(lower)
+		r.Read(upper)
+		if i%2 == 0 {
+			lower = []byte(fmt.Sprintf("/data/%d", r.Intn(30)))
+			upper = []byte(fmt.Sprintf("/data/%d/%d", r.Intn(30), r.Intn(100)))
+		}
+		assert.Equal(t, countRange(lower, upper), art.CountRange(lower, upper))
+	}
+}
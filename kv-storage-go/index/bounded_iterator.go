@@ -0,0 +1,97 @@
+package index
+
+import (
+	"bytes"
+
+	"github.com/tClown11/kv-storage/structure"
+)
+
+// BoundedIterator 限定了遍历范围的迭代器，范围为 [lowerBound, upperBound)
+// 离开范围之后迭代器立即失效，不会继续遍历剩余的索引
+type BoundedIterator struct {
+	iter       Iterator
+	lowerBound []byte // 下界( 包含 )，为 nil 表示没有下界
+	upperBound []byte // 上界( 不包含 )，为 nil 表示没有上界
+	reverse    bool
+	cmp        Comparator
+}
+
+// NewBoundedIterator 在 iter 之上限定遍历的范围，reverse 和 cmp 需要与 iter 的遍历方向和排序方式一致
+func NewBoundedIterator(iter Iterator, lowerBound, upperBound []byte, reverse bool, cmp Comparator) *BoundedIterator {
+	bi := &BoundedIterator{
+		iter:       iter,
+		lowerBound: lowerBound,
+		upperBound: upperBound,
+		reverse:    reverse,
+		cmp:        OrDefault(cmp),
+	}
+	bi.Rewind()
+	return bi
+}
+
+// Rewind 重新回到迭代器的起点，即范围内的第一个数据
+func (bi *BoundedIterator) Rewind() {
+	switch {
+	case bi.reverse && bi.upperBound != nil:
+		bi.seekBeforeUpper(bi.upperBound)
+	case !bi.reverse && bi.lowerBound != nil:
+		bi.iter.Seek(bi.lowerBound)
+	default:
+		bi.iter.Rewind()
+	}
+}
+
+// Seek 根据传入的 key 查找到第一个大于(或小于) 等于的目标 key，超出范围的 key 会被限定到边界上
+func (bi *BoundedIterator) Seek(key []byte) {
+	switch {
+	case bi.reverse && bi.upperBound != nil && bi.cmp.Compare(key, bi.upperBound) >= 0:
+		bi.seekBeforeUpper(bi.upperBound)
+	case !bi.reverse && bi.lowerBound != nil && bi.cmp.Compare(key, bi.lowerBound) < 0:
+		bi.iter.Seek(bi.lowerBound)
+	default:
+		bi.iter.Seek(key)
+	}
+}
+
+// Next 跳转到下一个 key
+func (bi *BoundedIterator) Next() {
+	bi.iter.Next()
+}
+
+// Valid 是否有效，遍历完所有的 key 或者离开范围之后无效
+func (bi *BoundedIterator) Valid() bool {
+	if !bi.iter.Valid() {
+		return false
+	}
+	key := bi.iter.Key()
+	if bi.lowerBound != nil && bi.cmp.Compare(key, bi.lowerBound) < 0 {
+		return false
+	}
+	if bi.upperBound != nil && bi.cmp.Compare(key, bi.upperBound) >= 0 {
+		return false
+	}
+	return true
+}
+
+// Key 当前遍历位置的 key 数据
+func (bi *BoundedIterator) Key() []byte {
+	return bi.iter.Key()
+}
+
+// Value 当前遍历位置的 Value 数据
+func (bi *BoundedIterator) Value() *structure.LogRecordPos {
+	return bi.iter.Value()
+}
+
+// Close 关闭迭代器，释放相应的资源
+func (bi *BoundedIterator) Close() {
+	bi.iter.Close()
+}
+
+// seekBeforeUpper 反向遍历时定位到第一个小于 upper 的 key
+func (bi *BoundedIterator) seekBeforeUpper(upper []byte) {
+	bi.iter.Seek(upper)
+	if bi.iter.Valid() && bytes.Equal(bi.iter.Key(), upper) {
+		bi.iter.Next()
+	}
+}
@@ -0,0 +1,58 @@
+package index
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tClown11/kv-storage/structure"
+)
+
+func TestBoundedIterator(t *testing.T) {
+	bt := NewBtree(32, nil)
+	for _, key := range []string{"a", "b", "ba", "bb", "c", "d"} {
+		bt.Put([]byte(key), &structure.LogRecordPos{Fid: 1})
+	}
+
+	collect := func(iter Iterator) []string {
+		var keys []string
+		for ; iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		return keys
+	}
+
+	tests := []struct {
+		lower   []byte
+		upper   []byte
+		reverse bool
+		keys    []string
+	}{
+		{lower: nil, upper: nil, reverse: false, keys: []string{"a", "b", "ba", "bb", "c", "d"}},
+		{lower: []byte("b"), upper: []byte("c"), reverse: false, keys: []string{"b", "ba", "bb"}},
+		{lower: []byte("b"), upper: []byte("c"), reverse: true, keys: []string{"bb", "ba", "b"}},
+		{lower: []byte("ab"), upper: []byte("bab"), reverse: true, keys: []string{"ba", "b"}},
+		{lower: nil, upper: []byte("b"), reverse: false, keys: []string{"a"}},
+		{lower: []byte("c"), upper: nil, reverse: true, keys: []string{"d", "c"}},
+		{lower: []byte("x"), upper: nil, reverse: false, keys: nil},
+	}
+	for _, tt := range tests {
+		iter := NewBoundedIterator(bt.Iterator(tt.reverse), tt.lower, tt.upper, tt.reverse, nil)
+		assert.Equal(t, tt.keys, collect(iter))
+		iter.Close()
+	}
+
+	// Seek 超出范围时限定到边界上
+	iter1 := NewBoundedIterator(bt.Iterator(false), []byte("b"), []byte("c"), false, nil)
+	iter1.Seek([]byte("a"))
+	assert.Equal(t, []byte("b"), iter1.Key())
+	iter1.Seek([]byte("bab"))
+	assert.Equal(t, []byte("bb"), iter1.Key())
+	iter1.Seek([]byte("bc"))
+	assert.False(t, iter1.Valid())
+
+	iter2 := NewBoundedIterator(bt.Iterator(true), []byte("b"), []byte("c"), true, nil)
+	iter2.Seek([]byte("z"))
+	assert.Equal(t, []byte("bb"), iter2.Key())
+	iter2.Seek([]byte("a"))
+	assert.False(t, iter2.Valid())
+}
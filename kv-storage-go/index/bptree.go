@@ -0,0 +1,724 @@
+package index
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/tClown11/kv-storage/errs"
+	"github.com/tClown11/kv-storage/structure"
+)
+
+const (
+	// BPTreeIndexFileName B+ 树索引文件的名称
+	BPTreeIndexFileName = "bptree-index"
+
+	// BPTreeMaxKeySize B+ 树索引支持的最大 key 长度，保证一个页中至少可以存放 3 条数据
+	BPTreeMaxKeySize = 1024
+)
+
+const (
+	bptPageSize   = 4096 // 页大小
+	bptCachePages = 2048 // 内存中最多缓存的页数量
+	bptMetaPageID = 0    // 元数据页
+	bptMagic      = "KVBPTREE"
+	bptVersion    = 1
+
+	bptLeafPage   = 1
+	bptBranchPage = 2
+
+	// 页头部：crc(4) + 类型(1) + 数据条数(2)
+	bptPageHeaderSize = 7
+)
+
+var (
+	errBPTreeCorrupted = errors.New("the bptree index file is corrupted")
+	errBPTreeClosed    = errors.New("the bptree index is closed")
+)
+
+// bptMeta B+ 树元数据，存储在第 0 页
+type bptMeta struct {
+	root       uint32                  // 根节点所在的页
+	pageCount  uint32                  // 文件中页的数量
+	keyCount   uint64                  // key 的数量
+	clean      bool                    // 磁盘上的数据是否完整，修改前会先置为 false
+	checkpoint *structure.LogRecordPos // 索引已经覆盖到的数据位置
+}
+
+// bptNode 内存中的 B+ 树节点，对应磁盘上的一个页
+type bptNode struct {
+	id       uint32
+	leaf     bool
+	keys     [][]byte
+	values   []structure.LogRecordPos // 叶子节点存储的位置信息
+	children []uint32                 // 内部节点的子节点页，比 keys 多一个
+	dirty    bool
+	elem     *list.Element
+}
+
+// BPlusTree 基于磁盘页的 B+ 树索引
+// 索引数据存储在数据目录中，只在内存中缓存有限数量的页，可以支持超出内存大小的 key 数量
+type BPlusTree struct {
+	fd         *os.File
+	sync       bool
+	meta       bptMeta
+	nodes      map[uint32]*bptNode // 页缓存
+	lru        *list.List
+	cachePages int // 最多缓存的页数量
+	cmp        Comparator
+	lock       *sync.Mutex
+}
+
+// NewBPlusTree 打开或创建 B+ 树索引文件，cmp 为 nil 时按照字节序排序
+// 如果索引文件上一次没有正常落盘，会清空索引，由调用方重新构建
+func NewBPlusTree(dirPath string, syncWrites bool, cmp Comparator) (*BPlusTree, error) {
+	fd, err := os.OpenFile(filepath.Join(dirPath, BPTreeIndexFileName), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	bpt := &BPlusTree{
+		fd:         fd,
+		sync:       syncWrites,
+		nodes:      make(map[uint32]*bptNode),
+		lru:        list.New(),
+		cachePages: bptCachePages,
+		cmp:        OrDefault(cmp),
+		lock:       new(sync.Mutex),
+	}
+
+	stat, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	if stat.Size() > 0 {
+		err = bpt.readMeta()
+		if err == nil && bpt.meta.clean {
+			return bpt, nil
+		}
+		if err != nil && err != errBPTreeCorrupted {
+			_ = fd.Close()
+			return nil, err
+		}
+	}
+
+	// 新建的或者不完整的索引文件，重新初始化
+	if err := bpt.reset(); err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	return bpt, nil
+}
+
+// Put 出错时忽略错误，需要获取错误时使用 PutWithError
+func (bpt *BPlusTree) Put(key []byte, pos *structure.LogRecordPos) *structure.LogRecordPos {
+	oldPos, _ := bpt.PutWithError(key, pos)
+	return oldPos
+}
+
+// PutWithError key 超过最大长度时返回 errs.ErrKeyTooLarge，读写页失败时返回对应的错误
+func (bpt *BPlusTree) PutWithError(key []byte, pos *structure.LogRecordPos) (*structure.LogRecordPos, error) {
+	if len(key) > BPTreeMaxKeySize {
+		return nil, errs.ErrKeyTooLarge
+	}
+
+	bpt.lock.Lock()
+	defer bpt.lock.Unlock()
+
+	if bpt.fd == nil {
+		return nil, errBPTreeClosed
+	}
+	if err := bpt.beginWrite(); err != nil {
+		return nil, err
+	}
+	// 读取页只发生在修改节点之前，出错时索引没有被修改
+	oldPos, splitKey, right, err := bpt.insert(bpt.meta.root, key, pos)
+	if err != nil {
+		return nil, err
+	}
+	if right != nil {
+		// 根节点分裂，树的高度加一
+		root := bpt.allocNode(false)
+		root.keys = [][]byte{splitKey}
+		root.children = []uint32{bpt.meta.root, right.id}
+		bpt.meta.root = root.id
+	}
+	if oldPos == nil {
+		bpt.meta.keyCount++
+	}
+	return oldPos, bpt.endWrite()
+}
+
+// Get 读取页失败时返回 nil，需要获取错误时使用 GetWithError
+func (bpt *BPlusTree) Get(key []byte) *structure.LogRecordPos {
+	pos, _ := bpt.GetWithError(key)
+	return pos
+}
+
+// GetWithError 读取页失败时返回对应的错误
+func (bpt *BPlusTree) GetWithError(key []byte) (*structure.LogRecordPos, error) {
+	bpt.lock.Lock()
+	defer bpt.lock.Unlock()
+
+	if bpt.fd == nil {
+		return nil, errBPTreeClosed
+	}
+	n, err := bpt.findLeaf(key)
+	if err != nil {
+		return nil, err
+	}
+	i, found := n.search(bpt.cmp, key)
+	if !found {
+		return nil, bpt.evict()
+	}
+	pos := n.values[i]
+	return &pos, bpt.evict()
+}
+
+// Delete 出错时忽略错误，需要获取错误时使用 DeleteWithError
+func (bpt *BPlusTree) Delete(key []byte) (*structure.LogRecordPos, bool) {
+	oldPos, ok, _ := bpt.DeleteWithError(key)
+	return oldPos, ok
+}
+
+// DeleteWithError 读写页失败时返回对应的错误
+func (bpt *BPlusTree) DeleteWithError(key []byte) (*structure.LogRecordPos, bool, error) {
+	bpt.lock.Lock()
+	defer bpt.lock.Unlock()
+
+	if bpt.fd == nil {
+		return nil, false, errBPTreeClosed
+	}
+	n, err := bpt.findLeaf(key)
+	if err != nil {
+		return nil, false, err
+	}
+	i, found := n.search(bpt.cmp, key)
+	if !found {
+		return nil, false, bpt.evict()
+	}
+
+	// 只从叶子节点中移除数据，不做节点合并
+	if err := bpt.beginWrite(); err != nil {
+		return nil, false, err
+	}
+	oldPos := n.values[i]
+	n.keys = append(n.keys[:i], n.keys[i+1:]...)
+	n.values = append(n.values[:i], n.values[i+1:]...)
+	n.dirty = true
+	bpt.meta.keyCount--
+	return &oldPos, true, bpt.endWrite()
+}
+
+// MaxKeySize 支持的最大 key 长度
+func (bpt *BPlusTree) MaxKeySize() int {
+	return BPTreeMaxKeySize
+}
+
+func (bpt *BPlusTree) Iterator(reverse bool) Iterator {
+	return newBPTreeIterator(bpt, reverse)
+}
+
+func (bpt *BPlusTree) Size() int {
+	bpt.lock.Lock()
+	defer bpt.lock.Unlock()
+	return int(bpt.meta.keyCount)
+}
+
+// MemSize 页缓存占用的内存大小，索引数据本身存放在磁盘上
+func (bpt *BPlusTree) MemSize() int64 {
+	bpt.lock.Lock()
+	defer bpt.lock.Unlock()
+	return int64(len(bpt.nodes)) * bptPageSize
+}
+
+func (bpt *BPlusTree) Close() error {
+	bpt.lock.Lock()
+	defer bpt.lock.Unlock()
+
+	if bpt.fd == nil {
+		return nil
+	}
+	if err := bpt.flush(); err != nil {
+		return err
+	}
+	if err := bpt.fd.Sync(); err != nil {
+		return err
+	}
+	err := bpt.fd.Close()
+	bpt.fd = nil
+	return err
+}
+
+// CheckpointPos 返回最近一次落盘时记录的数据位置
+func (bpt *BPlusTree) CheckpointPos() *structure.LogRecordPos {
+	bpt.lock.Lock()
+	defer bpt.lock.Unlock()
+
+	if !bpt.meta.clean || bpt.meta.checkpoint == nil {
+		return nil
+	}
+	pos := *bpt.meta.checkpoint
+	return &pos
+}
+
+// Checkpoint 将缓存中的页全部落盘，并记录索引已经覆盖到的数据位置
+func (bpt *BPlusTree) Checkpoint(pos *structure.LogRecordPos) error {
+	bpt.lock.Lock()
+	defer bpt.lock.Unlock()
+
+	if bpt.fd == nil {
+		return errBPTreeClosed
+	}
+	if err := bpt.flush(); err != nil {
+		return err
+	}
+	if err := bpt.fd.Sync(); err != nil {
+		return err
+	}
+
+	checkpoint := *pos
+	bpt.meta.clean = true
+	bpt.meta.checkpoint = &checkpoint
+	if err := bpt.writeMeta(); err != nil {
+		return err
+	}
+	return bpt.fd.Sync()
+}
+
+// Reset 清空索引
+func (bpt *BPlusTree) Reset() error {
+	bpt.lock.Lock()
+	defer bpt.lock.Unlock()
+
+	if bpt.fd == nil {
+		return errBPTreeClosed
+	}
+	return bpt.reset()
+}
+
+func (bpt *BPlusTree) reset() error {
+	if err := bpt.fd.Truncate(0); err != nil {
+		return err
+	}
+	bpt.nodes = make(map[uint32]*bptNode)
+	bpt.lru.Init()
+	bpt.meta = bptMeta{pageCount: 1, clean: true}
+
+	root := bpt.allocNode(true)
+	bpt.meta.root = root.id
+	if err := bpt.flush(); err != nil {
+		return err
+	}
+	return bpt.fd.Sync()
+}
+
+// beginWrite 第一次修改前先将元数据标记为不完整，保证崩溃后不会使用写了一半的索引
+func (bpt *BPlusTree) beginWrite() error {
+	if !bpt.meta.clean {
+		return nil
+	}
+	bpt.meta.clean = false
+	bpt.meta.checkpoint = nil
+	if err := bpt.writeMeta(); err != nil {
+		return err
+	}
+	return bpt.fd.Sync()
+}
+
+// endWrite 修改完成，根据配置决定是否持久化
+// 出错时内存中的修改已经生效，元数据仍然标记为不完整，重启后会重新构建索引
+func (bpt *BPlusTree) endWrite() error {
+	if bpt.sync {
+		if err := bpt.flush(); err != nil {
+			return err
+		}
+		if err := bpt.fd.Sync(); err != nil {
+			return err
+		}
+	}
+	return bpt.evict()
+}
+
+// insert 插入数据，如果节点发生分裂，返回分裂出的右节点及其最小的 key
+func (bpt *BPlusTree) insert(id uint32, key []byte, pos *structure.LogRecordPos) (*structure.LogRecordPos, []byte, *bptNode, error) {
+	n, err := bpt.loadNode(id)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var oldPos *structure.LogRecordPos
+
+	if n.leaf {
+		i, found := n.search(bpt.cmp, key)
+		if found {
+			// 位置信息使用变长编码，替换后节点也可能超出页大小
+			old := n.values[i]
+			oldPos = &old
+			n.values[i] = *pos
+		} else {
+			n.keys = append(n.keys, nil)
+			n.values = append(n.values, structure.LogRecordPos{})
+			copy(n.keys[i+1:], n.keys[i:])
+			copy(n.values[i+1:], n.values[i:])
+			n.keys[i] = append([]byte(nil), key...)
+			n.values[i] = *pos
+		}
+	} else {
+		i := n.childIndex(bpt.cmp, key)
+		var (
+			splitKey []byte
+			right    *bptNode
+		)
+		oldPos, splitKey, right, err = bpt.insert(n.children[i], key, pos)
+		if err != nil || right == nil {
+			return oldPos, nil, nil, err
+		}
+		n.keys = append(n.keys, nil)
+		n.children = append(n.children, 0)
+		copy(n.keys[i+1:], n.keys[i:])
+		copy(n.children[i+2:], n.children[i+1:])
+		n.keys[i] = splitKey
+		n.children[i+1] = right.id
+	}
+	n.dirty = true
+
+	if n.encodedSize() <= bptPageSize {
+		return oldPos, nil, nil, nil
+	}
+	splitKey, right := bpt.split(n)
+	return oldPos, splitKey, right, nil
+}
+
+// split 将超出页大小的节点按照数据量平均拆分为两个节点
+func (bpt *BPlusTree) split(n *bptNode) ([]byte, *bptNode) {
+	total := n.encodedSize()
+	var m, size int
+	for m = 0; m < len(n.keys)-1; m++ {
+		size += n.entrySize(m)
+		if size >= total/2 {
+			break
+		}
+	}
+
+	right := bpt.allocNode(n.leaf)
+	if n.leaf {
+		// 叶子节点的右半部分从 m+1 开始，保证两边都不为空
+		m++
+		right.keys = append([][]byte(nil), n.keys[m:]...)
+		right.values = append([]structure.LogRecordPos(nil), n.values[m:]...)
+		n.keys = append([][]byte(nil), n.keys[:m]...)
+		n.values = append([]structure.LogRecordPos(nil), n.values[:m]...)
+		return right.keys[0], right
+	}
+
+	// 内部节点的中间 key 提升到父节点中
+	splitKey := n.keys[m]
+	right.keys = append([][]byte(nil), n.keys[m+1:]...)
+	right.children = append([]uint32(nil), n.children[m+1:]...)
+	n.keys = append([][]byte(nil), n.keys[:m]...)
+	n.children = append([]uint32(nil), n.children[:m+1]...)
+	return splitKey, right
+}
+
+// findLeaf 查找 key 所在的叶子节点
+func (bpt *BPlusTree) findLeaf(key []byte) (*bptNode, error) {
+	n, err := bpt.loadNode(bpt.meta.root)
+	for err == nil && !n.leaf {
+		n, err = bpt.loadNode(n.children[n.childIndex(bpt.cmp, key)])
+	}
+	return n, err
+}
+
+// collect 从 id 对应的子树中找到第一个包含满足条件数据的叶子节点，返回其中满足条件的数据
+// 正向遍历时返回大于(或等于) key 的数据，反向遍历时返回小于(或等于) key 的数据，key 为 nil 时表示从头( 或尾 )开始
+func (bpt *BPlusTree) collect(id uint32, key []byte, reverse, inclusive bool) ([]*bptItem, error) {
+	n, err := bpt.loadNode(id)
+	if err != nil {
+		return nil, err
+	}
+	if n.leaf {
+		var items []*bptItem
+		if !reverse {
+			for i := range n.keys {
+				if c := bpt.cmp.Compare(n.keys[i], key); key == nil || c > 0 || (inclusive && c == 0) {
+					items = append(items, newBPTreeItem(n.keys[i], n.values[i]))
+				}
+			}
+		} else {
+			for i := len(n.keys) - 1; i >= 0; i-- {
+				if c := bpt.cmp.Compare(n.keys[i], key); key == nil || c < 0 || (inclusive && c == 0) {
+					items = append(items, newBPTreeItem(n.keys[i], n.values[i]))
+				}
+			}
+		}
+		return items, nil
+	}
+
+	start := 0
+	if reverse {
+		start = len(n.children) - 1
+	}
+	if key != nil {
+		start = n.childIndex(bpt.cmp, key)
+	}
+	if !reverse {
+		for i := start; i < len(n.children); i++ {
+			if items, err := bpt.collect(n.children[i], key, reverse, inclusive); err != nil || len(items) > 0 {
+				return items, err
+			}
+		}
+	} else {
+		for i := start; i >= 0; i-- {
+			if items, err := bpt.collect(n.children[i], key, reverse, inclusive); err != nil || len(items) > 0 {
+				return items, err
+			}
+		}
+	}
+	return nil, nil
+}
+
+// loadNode 从缓存或者磁盘中加载节点
+func (bpt *BPlusTree) loadNode(id uint32) (*bptNode, error) {
+	if n, ok := bpt.nodes[id]; ok {
+		bpt.lru.MoveToFront(n.elem)
+		return n, nil
+	}
+
+	buf := make([]byte, bptPageSize)
+	if _, err := bpt.fd.ReadAt(buf, int64(id)*bptPageSize); err != nil {
+		return nil, fmt.Errorf("bptree index: failed to read page %d, %w", id, err)
+	}
+	n, err := decodeBPTreeNode(id, buf)
+	if err != nil {
+		return nil, fmt.Errorf("bptree index: failed to decode page %d, %w", id, err)
+	}
+	n.elem = bpt.lru.PushFront(n)
+	bpt.nodes[id] = n
+	return n, nil
+}
+
+// allocNode 在文件末尾分配一个新的页
+func (bpt *BPlusTree) allocNode(leaf bool) *bptNode {
+	n := &bptNode{
+		id:    bpt.meta.pageCount,
+		leaf:  leaf,
+		dirty: true,
+	}
+	bpt.meta.pageCount++
+	n.elem = bpt.lru.PushFront(n)
+	bpt.nodes[n.id] = n
+	return n
+}
+
+// evict 淘汰最久未使用的页，脏页先写回磁盘
+// 只在一次操作结束后调用，保证操作过程中用到的节点不会被淘汰
+// 脏页写回失败时保留在缓存中，之后再次尝试写回
+func (bpt *BPlusTree) evict() error {
+	for bpt.lru.Len() > bpt.cachePages {
+		n := bpt.lru.Back().Value.(*bptNode)
+		if n.dirty {
+			if err := bpt.writeNode(n); err != nil {
+				return fmt.Errorf("bptree index: failed to write page %d, %w", n.id, err)
+			}
+		}
+		bpt.lru.Remove(n.elem)
+		delete(bpt.nodes, n.id)
+	}
+	return nil
+}
+
+// flush 将所有的脏页和元数据写回磁盘
+func (bpt *BPlusTree) flush() error {
+	for _, n := range bpt.nodes {
+		if n.dirty {
+			if err := bpt.writeNode(n); err != nil {
+				return err
+			}
+		}
+	}
+	return bpt.writeMeta()
+}
+
+func (bpt *BPlusTree) writeNode(n *bptNode) error {
+	if _, err := bpt.fd.WriteAt(n.encode(), int64(n.id)*bptPageSize); err != nil {
+		return err
+	}
+	n.dirty = false
+	return nil
+}
+
+// 元数据页的格式
+//
+//	+-------+---------+----------+------+-----------+----------+-------+------------+-----------+--------------+-----+
+//	| magic | version | pageSize | root | pageCount | keyCount | clean | checkpoint | ckpt fid  | ckpt offset  | crc |
+//	+-------+---------+----------+------+-----------+----------+-------+------------+-----------+--------------+-----+
+//	   8         4         4         4        4           8        1         1           4            8           4
+func (bpt *BPlusTree) writeMeta() error {
+	buf := make([]byte, bptPageSize)
+	copy(buf, bptMagic)
+	binary.LittleEndian.PutUint32(buf[8:], bptVersion)
+	binary.LittleEndian.PutUint32(buf[12:], bptPageSize)
+	binary.LittleEndian.PutUint32(buf[16:], bpt.meta.root)
+	binary.LittleEndian.PutUint32(buf[20:], bpt.meta.pageCount)
+	binary.LittleEndian.PutUint64(buf[24:], bpt.meta.keyCount)
+	if bpt.meta.clean {
+		buf[32] = 1
+	}
+	if bpt.meta.checkpoint != nil {
+		buf[33] = 1
+		binary.LittleEndian.PutUint32(buf[34:], bpt.meta.checkpoint.Fid)
+		binary.LittleEndian.PutUint64(buf[38:], uint64(bpt.meta.checkpoint.Offset))
+	}
+	binary.LittleEndian.PutUint32(buf[46:], crc32.ChecksumIEEE(buf[:46]))
+	_, err := bpt.fd.WriteAt(buf, bptMetaPageID)
+	return err
+}
+
+func (bpt *BPlusTree) readMeta() error {
+	buf := make([]byte, bptPageSize)
+	if _, err := bpt.fd.ReadAt(buf, bptMetaPageID); err != nil {
+		return errBPTreeCorrupted
+	}
+	if string(buf[:8]) != bptMagic ||
+		binary.LittleEndian.Uint32(buf[46:]) != crc32.ChecksumIEEE(buf[:46]) {
+		return errBPTreeCorrupted
+	}
+	if binary.LittleEndian.Uint32(buf[8:]) != bptVersion ||
+		binary.LittleEndian.Uint32(buf[12:]) != bptPageSize {
+		return fmt.Errorf("unsupported bptree index version or page size")
+	}
+
+	bpt.meta = bptMeta{
+		root:      binary.LittleEndian.Uint32(buf[16:]),
+		pageCount: binary.LittleEndian.Uint32(buf[20:]),
+		keyCount:  binary.LittleEndian.Uint64(buf[24:]),
+		clean:     buf[32] == 1,
+	}
+	if buf[33] == 1 {
+		bpt.meta.checkpoint = &structure.LogRecordPos{
+			Fid:    binary.LittleEndian.Uint32(buf[34:]),
+			Offset: int64(binary.LittleEndian.Uint64(buf[38:])),
+		}
+	}
+	return nil
+}
+
+// search 在节点中二分查找 key，返回其下标( 或应该插入的位置 )，以及是否找到
+func (n *bptNode) search(cmp Comparator, key []byte) (int, bool) {
+	i := sort.Search(len(n.keys), func(i int) bool {
+		return cmp.Compare(n.keys[i], key) >= 0
+	})
+	return i, i < len(n.keys) && bytes.Equal(n.keys[i], key)
+}
+
+// childIndex 内部节点中 key 所在的子节点下标
+func (n *bptNode) childIndex(cmp Comparator, key []byte) int {
+	return sort.Search(len(n.keys), func(i int) bool {
+		return cmp.Compare(n.keys[i], key) > 0
+	})
+}
+
+// entrySize 第 i 条数据编码后的大小
+func (n *bptNode) entrySize(i int) int {
+	var buf [binary.MaxVarintLen64]byte
+	size := binary.PutUvarint(buf[:], uint64(len(n.keys[i]))) + len(n.keys[i])
+	if !n.leaf {
+		return size + 4
+	}
+	size += binary.PutUvarint(buf[:], uint64(n.values[i].Fid))
+	size += binary.PutVarint(buf[:], n.values[i].Offset)
+	size += binary.PutUvarint(buf[:], uint64(n.values[i].Size))
+	return size
+}
+
+// encodedSize 节点编码后的大小
+func (n *bptNode) encodedSize() int {
+	size := bptPageHeaderSize
+	if !n.leaf {
+		size += 4
+	}
+	for i := range n.keys {
+		size += n.entrySize(i)
+	}
+	return size
+}
+
+// encode 将节点编码为一个页
+//
+//	+-----+------+-------+---------------------------------------------------+
+//	| crc | type | count |  叶子节点: keySize key fid offset size ...         |
+//	|     |      |       |  内部节点: child0 keySize key child ...            |
+//	+-----+------+-------+---------------------------------------------------+
+//	   4      1      2
+func (n *bptNode) encode() []byte {
+	buf := make([]byte, bptPageSize)
+	buf[4] = bptBranchPage
+	if n.leaf {
+		buf[4] = bptLeafPage
+	}
+	binary.LittleEndian.PutUint16(buf[5:], uint16(len(n.keys)))
+
+	var index = bptPageHeaderSize
+	if !n.leaf {
+		binary.LittleEndian.PutUint32(buf[index:], n.children[0])
+		index += 4
+	}
+	for i, key := range n.keys {
+		index += binary.PutUvarint(buf[index:], uint64(len(key)))
+		index += copy(buf[index:], key)
+		if n.leaf {
+			index += binary.PutUvarint(buf[index:], uint64(n.values[i].Fid))
+			index += binary.PutVarint(buf[index:], n.values[i].Offset)
+			index += binary.PutUvarint(buf[index:], uint64(n.values[i].Size))
+		} else {
+			binary.LittleEndian.PutUint32(buf[index:], n.children[i+1])
+			index += 4
+		}
+	}
+	binary.LittleEndian.PutUint32(buf[:4], crc32.ChecksumIEEE(buf[4:]))
+	return buf
+}
+
+func decodeBPTreeNode(id uint32, buf []byte) (*bptNode, error) {
+	if binary.LittleEndian.Uint32(buf[:4]) != crc32.ChecksumIEEE(buf[4:]) {
+		return nil, errBPTreeCorrupted
+	}
+	n := &bptNode{id: id, leaf: buf[4] == bptLeafPage}
+	count := int(binary.LittleEndian.Uint16(buf[5:]))
+	n.keys = make([][]byte, count)
+
+	var index = bptPageHeaderSize
+	if n.leaf {
+		n.values = make([]structure.LogRecordPos, count)
+	} else {
+		n.children = make([]uint32, count+1)
+		n.children[0] = binary.LittleEndian.Uint32(buf[index:])
+		index += 4
+	}
+	for i := 0; i < count; i++ {
+		keySize, m := binary.Uvarint(buf[index:])
+		index += m
+		n.keys[i] = append([]byte(nil), buf[index:index+int(keySize)]...)
+		index += int(keySize)
+		if n.leaf {
+			fid, m := binary.Uvarint(buf[index:])
+			index += m
+			offset, m := binary.Varint(buf[index:])
+			index += m
+			size, m := binary.Uvarint(buf[index:])
+			index += m
+			n.values[i] = structure.LogRecordPos{Fid: uint32(fid), Offset: offset, Size: uint32(size)}
+		} else {
+			n.children[i+1] = binary.LittleEndian.Uint32(buf[index:])
+			index += 4
+		}
+	}
+	return n, nil
+}
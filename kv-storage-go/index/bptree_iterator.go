@@ -0,0 +1,95 @@
+package index
+
+import (
+	"github.com/tClown11/kv-storage/structure"
+)
+
+// bptItem 迭代器中的单个数据对象
+type bptItem struct {
+	key []byte
+	pos *structure.LogRecordPos
+}
+
+func newBPTreeItem(key []byte, pos structure.LogRecordPos) *bptItem {
+	return &bptItem{key: append([]byte(nil), key...), pos: &pos}
+}
+
+// B+ 树索引迭代器
+// 每次只加载一个叶子节点中的数据，遍历完之后再从根节点查找下一批数据，
+// 这样即使遍历过程中发生节点分裂，也不会遗漏或者重复
+type bptreeIterator struct {
+	tree    *BPlusTree
+	reverse bool       // 是否是反向遍历
+	items   []*bptItem // 当前批次的数据
+	idx     int        // 当前遍历的下标位置
+	err     error      // 读取页失败的错误，出错后遍历结束
+}
+
+func newBPTreeIterator(tree *BPlusTree, reverse bool) *bptreeIterator {
+	iter := &bptreeIterator{
+		tree:    tree,
+		reverse: reverse,
+	}
+	iter.Rewind()
+	return iter
+}
+
+// Rewind 重新回到迭代器的起点，即第一个数据
+func (bpi *bptreeIterator) Rewind() {
+	bpi.load(nil, true)
+}
+
+// Seek 根据传入的 key 查找到第一个大于(或小于) 等于的目标 key，根据从这个 key 开始遍历
+func (bpi *bptreeIterator) Seek(key []byte) {
+	bpi.load(key, true)
+}
+
+// Next 跳转到下一个 key
+func (bpi *bptreeIterator) Next() {
+	bpi.idx++
+	if bpi.idx < len(bpi.items) {
+		return
+	}
+	// 当前批次遍历完，从最后一个 key 之后继续查找
+	bpi.load(bpi.items[len(bpi.items)-1].key, false)
+}
+
+// Valid 是否有效，即是否已经遍历完了所有的 key，用于退出遍历
+func (bpi *bptreeIterator) Valid() bool {
+	return bpi.idx < len(bpi.items)
+}
+
+// Key 当前遍历位置的 key 数据
+func (bpi *bptreeIterator) Key() []byte {
+	return bpi.items[bpi.idx].key
+}
+
+// Value 当前遍历位置的 Value 数据
+func (bpi *bptreeIterator) Value() *structure.LogRecordPos {
+	return bpi.items[bpi.idx].pos
+}
+
+// Err 遍历过程中读取页失败的错误
+func (bpi *bptreeIterator) Err() error {
+	return bpi.err
+}
+
+// Close 关闭迭代器，释放相应的资源
+func (bpi *bptreeIterator) Close() {
+	bpi.items = nil
+}
+
+func (bpi *bptreeIterator) load(key []byte, inclusive bool) {
+	bpi.tree.lock.Lock()
+	defer bpi.tree.lock.Unlock()
+
+	bpi.idx = 0
+	if bpi.tree.fd == nil {
+		bpi.items, bpi.err = nil, errBPTreeClosed
+		return
+	}
+	bpi.items, bpi.err = bpi.tree.collect(bpi.tree.meta.root, key, bpi.reverse, inclusive)
+	if err := bpi.tree.evict(); err != nil && bpi.err == nil {
+		bpi.err = err
+	}
+}
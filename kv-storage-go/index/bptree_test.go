@@ -0,0 +1,189 @@
+package index
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tClown11/kv-storage/errs"
+	"github.com/tClown11/kv-storage/structure"
+)
+
+func newTestBPlusTree(t *testing.T) (*BPlusTree, string) {
+	dir, _ := os.MkdirTemp("", "bptree")
+	bpt, err := NewBPlusTree(dir, false, nil)
+	assert.Nil(t, err)
+	assert.NotNil(t, bpt)
+	return bpt, dir
+}
+
+func TestBPlusTree_Put(t *testing.T) {
+	bpt, dir := newTestBPlusTree(t)
+	defer os.RemoveAll(dir)
+	defer bpt.Close()
+
+	tests := []struct {
+		key    []byte
+		pos    *structure.LogRecordPos
+		result *structure.LogRecordPos
+	}{
+		{
+			key:    []byte("a"),
+			pos:    &structure.LogRecordPos{Fid: 1, Offset: 2},
+			result: nil,
+		},
+		{
+			key:    []byte("a"),
+			pos:    &structure.LogRecordPos{Fid: 11, Offset: 12},
+			result: &structure.LogRecordPos{Fid: 1, Offset: 2},
+		},
+		{
+			key:    []byte("b"),
+			pos:    &structure.LogRecordPos{Fid: 1, Offset: 3},
+			result: nil,
+		},
+	}
+
+	for i := range tests {
+		res := bpt.Put(tests[i].key, tests[i].pos)
+		assert.Equal(t, tests[i].result, res)
+	}
+	assert.Equal(t, 2, bpt.Size())
+}
+
+func TestBPlusTree_GetAndDelete(t *testing.T) {
+	bpt, dir := newTestBPlusTree(t)
+	defer os.RemoveAll(dir)
+	defer bpt.Close()
+
+	bpt.Put([]byte("a"), &structure.LogRecordPos{Fid: 1, Offset: 10, Size: 5})
+	assert.Equal(t, &structure.LogRecordPos{Fid: 1, Offset: 10, Size: 5}, bpt.Get([]byte("a")))
+	assert.Nil(t, bpt.Get([]byte("b")))
+
+	res, ok := bpt.Delete([]byte("b"))
+	assert.False(t, ok)
+	assert.Nil(t, res)
+
+	res, ok = bpt.Delete([]byte("a"))
+	assert.True(t, ok)
+	assert.Equal(t, &structure.LogRecordPos{Fid: 1, Offset: 10, Size: 5}, res)
+	assert.Nil(t, bpt.Get([]byte("a")))
+	assert.Equal(t, 0, bpt.Size())
+}
+
+// 数据量超过页缓存，覆盖节点分裂和页的换入换出
+func TestBPlusTree_ManyKeys(t *testing.T) {
+	bpt, dir := newTestBPlusTree(t)
+	defer os.RemoveAll(dir)
+	defer bpt.Close()
+	bpt.cachePages = 16
+
+	for i := 0; i < 20000; i++ {
+		bpt.Put([]byte(fmt.Sprintf("key-%09d", i)), &structure.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	for i := 0; i < 20000; i += 2 {
+		_, ok := bpt.Delete([]byte(fmt.Sprintf("key-%09d", i)))
+		assert.True(t, ok)
+	}
+	assert.Equal(t, 10000, bpt.Size())
+	assert.LessOrEqual(t, len(bpt.nodes), 16)
+
+	for i := 0; i < 20000; i++ {
+		pos := bpt.Get([]byte(fmt.Sprintf("key-%09d", i)))
+		if i%2 == 0 {
+			assert.Nil(t, pos)
+		} else {
+			assert.Equal(t, int64(i), pos.Offset)
+		}
+	}
+
+	// 正向遍历
+	var count int
+	iter := bpt.Iterator(false)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%09d", count*2+1)), iter.Key())
+		count++
+	}
+	assert.Equal(t, 10000, count)
+
+	// 反向遍历
+	count = 0
+	iter = bpt.Iterator(true)
+	for iter.Seek([]byte("key-000010000")); iter.Valid(); iter.Next() {
+		count++
+	}
+	assert.Equal(t, 5000, count)
+}
+
+func TestBPlusTree_Checkpoint(t *testing.T) {
+	bpt, dir := newTestBPlusTree(t)
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < 1000; i++ {
+		bpt.Put([]byte(fmt.Sprintf("key-%09d", i)), &structure.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	assert.Nil(t, bpt.CheckpointPos())
+
+	// 正常落盘后重新打开，数据仍然存在
+	err := bpt.Checkpoint(&structure.LogRecordPos{Fid: 3, Offset: 100})
+	assert.Nil(t, err)
+	err = bpt.Close()
+	assert.Nil(t, err)
+
+	bpt2, err := NewBPlusTree(dir, false, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, &structure.LogRecordPos{Fid: 3, Offset: 100}, bpt2.CheckpointPos())
+	assert.Equal(t, 1000, bpt2.Size())
+	assert.Equal(t, int64(10), bpt2.Get([]byte("key-000000010")).Offset)
+
+	// 修改之后没有 Checkpoint，重新打开时索引会被清空
+	bpt2.Put([]byte("new-key"), &structure.LogRecordPos{Fid: 4})
+	assert.Nil(t, bpt2.CheckpointPos())
+	err = bpt2.Close()
+	assert.Nil(t, err)
+
+	bpt3, err := NewBPlusTree(dir, false, nil)
+	assert.Nil(t, err)
+	defer bpt3.Close()
+	assert.Nil(t, bpt3.CheckpointPos())
+	assert.Equal(t, 0, bpt3.Size())
+
+	_, err = os.Stat(filepath.Join(dir, BPTreeIndexFileName))
+	assert.Nil(t, err)
+}
+
+func TestBPlusTree_Errors(t *testing.T) {
+	bpt, dir := newTestBPlusTree(t)
+	defer os.RemoveAll(dir)
+
+	// key 超过最大长度时返回错误，不写入索引
+	_, err := bpt.PutWithError(make([]byte, BPTreeMaxKeySize+1), &structure.LogRecordPos{Fid: 1})
+	assert.Equal(t, errs.ErrKeyTooLarge, err)
+	assert.Nil(t, bpt.Put(make([]byte, BPTreeMaxKeySize+1), &structure.LogRecordPos{Fid: 1}))
+	assert.Equal(t, 0, bpt.Size())
+
+	bpt.cachePages = 4
+	for i := 0; i < 10000; i++ {
+		bpt.Put([]byte(fmt.Sprintf("bptree-key-%09d", i)), &structure.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	assert.Nil(t, bpt.Checkpoint(&structure.LogRecordPos{Fid: 1}))
+
+	// 读取不在缓存中的页失败时返回错误
+	fd := bpt.fd
+	assert.Nil(t, fd.Close())
+	_, err = bpt.GetWithError([]byte("bptree-key-000000001"))
+	assert.NotNil(t, err)
+	assert.Nil(t, bpt.Get([]byte("bptree-key-000000001")))
+	_, _, err = bpt.DeleteWithError([]byte("bptree-key-000000001"))
+	assert.NotNil(t, err)
+	_, err = bpt.PutWithError([]byte("bptree-key-000000001"), &structure.LogRecordPos{Fid: 2})
+	assert.NotNil(t, err)
+
+	iter := bpt.Iterator(false).(*bptreeIterator)
+	assert.False(t, iter.Valid())
+	assert.NotNil(t, iter.Err())
+	iter.Close()
+	bpt.fd = nil
+}
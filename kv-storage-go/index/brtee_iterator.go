@@ -1,63 +1,48 @@
 package index
 
 import (
-	"bytes"
-	"sort"
-
-	"github.com/google/btree"
 	"github.com/tClown11/kv-storage/structure"
 )
 
+// 迭代器每次从快照中读取的数据条数
+const btreeIteratorBatchSize = 64
+
 // BTree 索引迭代器
+// 基于 B 树的 copy-on-write 快照，每次只读取一小批数据，不会拷贝整棵树
 type btreeIterator struct {
-	currIndex int      // 当前遍历的下标位置
-	reverse   bool     // 是否是方向遍历
-	values    []*BItem // key+位置索引信息
+	tree      *countedBTree // 创建迭代器时的索引快照
+	reverse   bool          // 是否是反向遍历
+	currIndex int           // 当前批次中遍历的下标位置
+	values    []*BItem      // 当前批次的 key+位置索引信息
 }
 
-func newBTreeIterator(tree *btree.BTree, reverse bool) *btreeIterator {
-	var idx int
-	values := make([]*BItem, tree.Len())
-
-	// 将所有的数据存放到数组中
-	saveValues := func(item btree.Item) bool {
-		values[idx] = item.(*BItem)
-		idx++
-		return true
-	}
-	if reverse {
-		tree.Descend(saveValues)
-	} else {
-		tree.Ascend(saveValues)
-	}
-	return &btreeIterator{
-		currIndex: 0,
-		reverse:   reverse,
-		values:    values,
+func newBTreeIterator(tree *countedBTree, reverse bool) *btreeIterator {
+	bti := &btreeIterator{
+		tree:    tree,
+		reverse: reverse,
 	}
+	bti.Rewind()
+	return bti
 }
 
 // Rewind 重新回到迭代器的起点，即第一个数据
 func (bti *btreeIterator) Rewind() {
-	bti.currIndex = 0
+	bti.load(nil, true)
 }
 
 // Seek 根据传入的 key 查找到第一个大于(或小于) 等于的目标 key，根据从这个 key 开始遍历
 func (bti *btreeIterator) Seek(key []byte) {
-	if bti.reverse {
-		bti.currIndex = sort.Search(len(bti.values), func(i int) bool {
-			return bytes.Compare(bti.values[i].key, key) <= 0
-		})
-	} else {
-		bti.currIndex = sort.Search(len(bti.values), func(i int) bool {
-			return bytes.Compare(bti.values[i].key, key) >= 0
-		})
-	}
+	bti.load(&BItem{key: key}, true)
 }
 
 // Next 跳转到下一个 key
 func (bti *btreeIterator) Next() {
 	bti.currIndex += 1
+	if bti.currIndex < len(bti.values) || len(bti.values) < btreeIteratorBatchSize {
+		return
+	}
+	// 当前批次已经遍历完，从最后一个 key 之后继续读取
+	bti.load(bti.values[len(bti.values)-1], false)
 }
 
 // Valid 是否有效，即是否已经遍历完了所有的 key，用于退出遍历
@@ -77,5 +62,33 @@ func (bti *btreeIterator) Value() *structure.LogRecordPos {
 
 // Close 关闭迭代器，释放相应的资源
 func (bti *btreeIterator) Close() {
+	bti.tree = nil
 	bti.values = nil
 }
+
+// load 从 pivot 开始读取一批数据，pivot 为 nil 时从头( 或尾 )开始，inclusive 表示是否包含 pivot 本身
+func (bti *btreeIterator) load(pivot *BItem, inclusive bool) {
+	values := make([]*BItem, 0, btreeIteratorBatchSize)
+	saveValues := func(it *BItem) bool {
+		if !inclusive && it == pivot {
+			return true
+		}
+		values = append(values, it)
+		return len(values) < btreeIteratorBatchSize
+	}
+
+	if bti.tree != nil {
+		switch {
+		case pivot == nil && bti.reverse:
+			bti.tree.Descend(saveValues)
+		case pivot == nil:
+			bti.tree.Ascend(saveValues)
+		case bti.reverse:
+			bti.tree.DescendLessOrEqual(pivot, saveValues)
+		default:
+			bti.tree.AscendGreaterOrEqual(pivot, saveValues)
+		}
+	}
+	bti.currIndex = 0
+	bti.values = values
+}
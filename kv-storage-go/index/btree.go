@@ -1,28 +1,34 @@
 package index
 
 import (
-	"bytes"
 	"sync"
 
-	"github.com/google/btree"
 	"github.com/tClown11/kv-storage/structure"
 )
 
 const defaultDegree = 32
 
-// Btree 索引，主要封装 google 的 btree 的实现
+// btreeItemOverhead 每条数据除 key 之外的内存占用：BItem、LogRecordPos 以及节点中的指针
+const btreeItemOverhead = 32 + 24 + 8
+
+// Btree 索引，基于记录子树数据量的 B 树，支持快速的范围计数
 type Btree struct {
-	tree *btree.BTree
-	lock *sync.RWMutex
+	tree    *countedBTree
+	keySize int64 // 所有 key 的总大小
+	lock    *sync.RWMutex
 }
 
-func NewBtree(degree int) *Btree {
+// NewBtree 初始化 BTree 索引，cmp 为 nil 时按照字节序排序
+func NewBtree(degree int, cmp Comparator) *Btree {
 	if degree == 0 {
 		degree = defaultDegree
 	}
+	cmp = OrDefault(cmp)
 
 	return &Btree{
-		tree: btree.New(degree),
+		tree: newCountedBTree(degree, func(a, b *BItem) bool {
+			return cmp.Compare(a.key, b.key) < 0
+		}),
 		lock: &sync.RWMutex{},
 	}
 }
@@ -32,11 +38,12 @@ func (bt *Btree) Put(key []byte, pos *structure.LogRecordPos) *structure.LogReco
 
 	bt.lock.Lock()
 	defer bt.lock.Unlock()
-	oldItem := bt.tree.ReplaceOrInsert(it)
-	if oldItem == nil {
+	oldItem, found := bt.tree.ReplaceOrInsert(it)
+	if !found {
+		bt.keySize += int64(len(key))
 		return nil
 	}
-	return oldItem.(*BItem).pos
+	return oldItem.pos
 }
 
 func (bt *Btree) Get(key []byte) *structure.LogRecordPos {
@@ -44,11 +51,11 @@ func (bt *Btree) Get(key []byte) *structure.LogRecordPos {
 	bt.lock.RLock()
 	defer bt.lock.RUnlock()
 
-	res := bt.tree.Get(it)
-	if res == nil {
+	res, found := bt.tree.Get(it)
+	if !found {
 		return nil
 	}
-	return res.(*BItem).pos
+	return res.pos
 }
 
 func (bt *Btree) Delete(key []byte) (*structure.LogRecordPos, bool) {
@@ -57,12 +64,13 @@ func (bt *Btree) Delete(key []byte) (*structure.LogRecordPos, bool) {
 	bt.lock.Lock()
 	defer bt.lock.Unlock()
 
-	oldItem := bt.tree.Delete(it)
+	oldItem, found := bt.tree.Delete(it)
 	// 无效删除
-	if oldItem == nil {
+	if !found {
 		return nil, false
 	}
-	return oldItem.(*BItem).pos, true
+	bt.keySize -= int64(len(key))
+	return oldItem.pos, true
 }
 
 func (bt *Btree) Iterator(reverse bool) Iterator {
@@ -70,9 +78,10 @@ func (bt *Btree) Iterator(reverse bool) Iterator {
 		return nil
 	}
 
-	bt.lock.RLock()
-	defer bt.lock.RUnlock()
-	return newBTreeIterator(bt.tree, reverse)
+	// Clone 会修改原有树的 copy-on-write 标记，需要加写锁
+	bt.lock.Lock()
+	defer bt.lock.Unlock()
+	return newBTreeIterator(bt.tree.Clone(), reverse)
 }
 
 func (bt *Btree) Close() error {
@@ -83,12 +92,32 @@ func (bt *Btree) Size() int {
 	return bt.tree.Len()
 }
 
+// CountRange 统计 [lowerBound, upperBound) 范围内 key 的数量，边界为 nil 表示不限制
+// 通过子树的数据量计算两个边界的排名，不需要遍历范围内的数据
+func (bt *Btree) CountRange(lowerBound, upperBound []byte) int {
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
+
+	upper := bt.tree.Len()
+	if upperBound != nil {
+		upper = bt.tree.CountLess(&BItem{key: upperBound})
+	}
+	var lower int
+	if lowerBound != nil {
+		lower = bt.tree.CountLess(&BItem{key: lowerBound})
+	}
+	return max(upper-lower, 0)
+}
+
+// MemSize 索引占用的内存大小
+func (bt *Btree) MemSize() int64 {
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
+	return int64(bt.tree.Len())*btreeItemOverhead + bt.keySize
+}
+
 // BItem btree 中的单个数据对象
 type BItem struct {
 	key []byte
 	pos *structure.LogRecordPos
 }
-
-func (bi *BItem) Less(item btree.Item) bool {
-	return bytes.Compare(bi.key, item.(*BItem).key) == -1
-}
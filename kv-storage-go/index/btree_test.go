@@ -1,13 +1,16 @@
 package index
 
 import (
+	"fmt"
+	"math/rand"
+	"sort"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/tClown11/kv-storage/structure"
 )
 
-var testBtree = NewBtree(32)
+var testBtree = NewBtree(32, nil)
 
 func TestBtree_PUT(t *testing.T) {
 
@@ -107,18 +110,18 @@ func TestBtree_Iterator(t *testing.T) {
 	}{
 		{
 			// Btree 为空
-			testTree: NewBtree(32),
+			testTree: NewBtree(32, nil),
 		},
 		{
 			// Btree 有数据的情况
-			testTree: NewBtree(32),
+			testTree: NewBtree(32, nil),
 			testData: []*BItem{
 				{[]byte("ccde"), &structure.LogRecordPos{Fid: 1, Offset: 10}},
 			},
 		},
 		{
 			// 有多条数据
-			testTree: NewBtree(32),
+			testTree: NewBtree(32, nil),
 			testData: []*BItem{
 				{[]byte("ccde"), &structure.LogRecordPos{Fid: 1, Offset: 10}},
 				{[]byte("acee"), &structure.LogRecordPos{Fid: 1, Offset: 20}},
@@ -149,3 +152,106 @@ func TestBtree_Iterator(t *testing.T) {
 		}
 	}
 }
+
+func TestBtree_IteratorSnapshot(t *testing.T) {
+	bt := NewBtree(32, nil)
+	for i := 0; i < 1000; i++ {
+		bt.Put([]byte(fmt.Sprintf("key-%04d", i)), &structure.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	iter := bt.Iterator(false)
+	defer iter.Close()
+
+	// 迭代器创建之后的修改对迭代器不可见
+	for i := 0; i < 1000; i += 2 {
+		bt.Delete([]byte(fmt.Sprintf("key-%04d", i)))
+	}
+	bt.Put([]byte("key-9999"), &structure.LogRecordPos{Fid: 1})
+
+	var count int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%04d", count)), iter.Key())
+		assert.Equal(t, int64(count), iter.Value().Offset)
+		count++
+	}
+	assert.Equal(t, 1000, count)
+
+	// 跨越多个批次的反向遍历
+	reverseIter := bt.Iterator(true)
+	defer reverseIter.Close()
+	count = 0
+	for reverseIter.Seek([]byte("key-0500")); reverseIter.Valid(); reverseIter.Next() {
+		count++
+	}
+	assert.Equal(t, 250, count)
+}
+
+func TestBtree_CountRange(t *testing.T) {
+	// 使用较小的阶数，覆盖节点的分裂、借用和合并
+	bt := NewBtree(2, nil)
+	assert.Equal(t, 0, bt.CountRange(nil, nil))
+
+	r := rand.New(rand.NewSource(1))
+	expected := make(map[string]int64)
+	var snapshot Iterator
+	var snapshotKeys []string
+	for i := 0; i < 20000; i++ {
+		key := []byte(fmt.Sprintf("/data/%d/%d", r.Intn(30), r.Intn(100)))
+		if r.Intn(3) == 0 {
+			_, ok := bt.Delete(key)
+			_, exists := expected[string(key)]
+			assert.Equal(t, exists, ok)
+			delete(expected, string(key))
+		} else {
+			bt.Put(key, &structure.LogRecordPos{Fid: 1, Offset: int64(i)})
+			expected[string(key)] = int64(i)
+		}
+		if i == 10000 {
+			snapshot = bt.Iterator(false)
+			for key := range expected {
+				snapshotKeys = append(snapshotKeys, key)
+			}
+			sort.Strings(snapshotKeys)
+		}
+	}
+	assert.Equal(t, len(expected), bt.Size())
+
+	// 快照不受之后的修改影响
+	var idx int
+	for snapshot.Rewind(); snapshot.Valid(); snapshot.Next() {
+		assert.Equal(t, snapshotKeys[idx], string(snapshot.Key()))
+		idx++
+	}
+	assert.Equal(t, len(snapshotKeys), idx)
+	snapshot.Close()
+
+	for key, offset := range expected {
+		assert.Equal(t, offset, bt.Get([]byte(key)).Offset)
+	}
+
+	countRange := func(lower, upper []byte) int {
+		var count int
+		for key := range expected {
+			if (lower == nil || key >= string(lower)) && (upper == nil || key < string(upper)) {
+				count++
+			}
+		}
+		return count
+	}
+	tests := [][2][]byte{
+		{nil, nil},
+		{[]byte("/data/1"), []byte("/data/2")},
+		{[]byte("/data/1/"), nil},
+		{nil, []byte("/data/15/50")},
+		{[]byte("/data/15/50"), []byte("/data/15/50")},
+		{[]byte("/data/2"), []byte("/data/1")},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, countRange(tt[0], tt[1]), bt.CountRange(tt[0], tt[1]))
+	}
+	for i := 0; i < 200; i++ {
+		lower := []byte(fmt.Sprintf("/data/%d", r.Intn(30)))
+		upper := []byte(fmt.Sprintf("/data/%d/%d", r.Intn(30), r.Intn(100)))
+		assert.Equal(t, countRange(lower, upper), bt.CountRange(lower, upper))
+	}
+}
@@ -0,0 +1,196 @@
+package index
+
+import (
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/google/btree"
+	"github.com/tClown11/kv-storage/structure"
+)
+
+const (
+	// arena 的初始大小，之后每次翻倍，直到最大值
+	compactMinArenaSize = 4 * 1024
+	compactMaxArenaSize = 1024 * 1024
+)
+
+// compactItem 索引中的单个数据对象
+// key 存放在 arena 中，位置信息直接内联存储，不需要为每条数据单独分配内存
+type compactItem struct {
+	arena  uint32 // key 所在的 arena 编号
+	off    uint32 // key 在 arena 中的偏移
+	klen   uint32 // key 的长度
+	fid    uint32
+	size   uint32
+	offset int64
+	probe  *[]byte // 查找时使用的临时 key，树中保存的数据始终为 nil
+}
+
+var compactItemSize = int64(unsafe.Sizeof(compactItem{}))
+
+// compactProbeItem 查找 key 使用的临时数据对象
+func compactProbeItem(key []byte) compactItem {
+	return compactItem{probe: &key}
+}
+
+func (it compactItem) pos() *structure.LogRecordPos {
+	return &structure.LogRecordPos{Fid: it.fid, Offset: it.offset, Size: it.size}
+}
+
+// compactArenas 存放 key 的内存块
+// 已经写入的 arena 不会再被修改，新增 arena 时整体替换 bufs，树的快照可以并发读取
+type compactArenas struct {
+	bufs  atomic.Pointer[[][]byte]
+	used  int   // 最后一个 arena 已经使用的大小
+	total int64 // 所有 arena 的总大小
+	cmp   Comparator
+}
+
+func newCompactArenas(cmp Comparator) *compactArenas {
+	ca := &compactArenas{cmp: cmp}
+	ca.bufs.Store(&[][]byte{})
+	return ca
+}
+
+func (ca *compactArenas) key(it compactItem) []byte {
+	if it.probe != nil {
+		return *it.probe
+	}
+	end := it.off + it.klen
+	return (*ca.bufs.Load())[it.arena][it.off:end:end]
+}
+
+func (ca *compactArenas) less(a, b compactItem) bool {
+	return ca.cmp.Compare(ca.key(a), ca.key(b)) < 0
+}
+
+// add 将 key 拷贝到 arena 中，返回存放的位置
+func (ca *compactArenas) add(key []byte) (uint32, uint32) {
+	bufs := *ca.bufs.Load()
+	n := len(bufs)
+	if n == 0 || ca.used+len(key) > len(bufs[n-1]) {
+		size := compactMinArenaSize
+		if n > 0 {
+			size = min(len(bufs[n-1])*2, compactMaxArenaSize)
+		}
+		size = max(size, len(key))
+
+		newBufs := make([][]byte, n+1)
+		copy(newBufs, bufs)
+		newBufs[n] = make([]byte, size)
+		ca.bufs.Store(&newBufs)
+		bufs, n = newBufs, n+1
+		ca.used = 0
+		ca.total += int64(size)
+	}
+
+	off := ca.used
+	copy(bufs[n-1][off:], key)
+	ca.used += len(key)
+	return uint32(n - 1), uint32(off)
+}
+
+// CompactIndex 紧凑型内存索引
+// key 集中存放在大块的 arena 中，BTree 中只保存定长的数据，
+// 适合存放大量的小 key，可以显著降低内存占用和 GC 的开销
+type CompactIndex struct {
+	tree    *btree.BTreeG[compactItem]
+	arenas  *compactArenas
+	garbage int64 // 已删除的 key 在 arena 中占用的空间
+	cmp     Comparator
+	lock    *sync.RWMutex
+}
+
+// NewCompactIndex 初始化紧凑型内存索引，cmp 为 nil 时按照字节序排序
+func NewCompactIndex(cmp Comparator) *CompactIndex {
+	cmp = OrDefault(cmp)
+	arenas := newCompactArenas(cmp)
+	return &CompactIndex{
+		tree:   btree.NewG(defaultDegree, arenas.less),
+		arenas: arenas,
+		cmp:    cmp,
+		lock:   new(sync.RWMutex),
+	}
+}
+
+func (ci *CompactIndex) Put(key []byte, pos *structure.LogRecordPos) *structure.LogRecordPos {
+	ci.lock.Lock()
+	defer ci.lock.Unlock()
+
+	item := compactItem{klen: uint32(len(key)), fid: pos.Fid, offset: pos.Offset, size: pos.Size}
+	// key 已经存在时复用原来 arena 中的位置
+	if old, ok := ci.tree.Get(compactProbeItem(key)); ok {
+		item.arena, item.off = old.arena, old.off
+		ci.tree.ReplaceOrInsert(item)
+		return old.pos()
+	}
+	item.arena, item.off = ci.arenas.add(key)
+	ci.tree.ReplaceOrInsert(item)
+	return nil
+}
+
+func (ci *CompactIndex) Get(key []byte) *structure.LogRecordPos {
+	ci.lock.RLock()
+	defer ci.lock.RUnlock()
+
+	item, ok := ci.tree.Get(compactProbeItem(key))
+	if !ok {
+		return nil
+	}
+	return item.pos()
+}
+
+func (ci *CompactIndex) Delete(key []byte) (*structure.LogRecordPos, bool) {
+	ci.lock.Lock()
+	defer ci.lock.Unlock()
+
+	old, ok := ci.tree.Delete(compactProbeItem(key))
+	if !ok {
+		return nil, false
+	}
+
+	// 已删除的 key 占用超过一半的空间时回收 arena
+	ci.garbage += int64(old.klen)
+	if ci.garbage > compactMinArenaSize && ci.garbage*2 > ci.arenas.total {
+		ci.compact()
+	}
+	return old.pos(), true
+}
+
+func (ci *CompactIndex) Iterator(reverse bool) Iterator {
+	// Clone 会修改原有树的 copy-on-write 标记，需要加写锁
+	ci.lock.Lock()
+	defer ci.lock.Unlock()
+	return newCompactIterator(ci.tree.Clone(), ci.arenas, reverse)
+}
+
+func (ci *CompactIndex) Size() int {
+	ci.lock.RLock()
+	defer ci.lock.RUnlock()
+	return ci.tree.Len()
+}
+
+func (ci *CompactIndex) Close() error {
+	return nil
+}
+
+// MemSize 索引占用的内存大小
+func (ci *CompactIndex) MemSize() int64 {
+	ci.lock.RLock()
+	defer ci.lock.RUnlock()
+	return ci.arenas.total + int64(ci.tree.Len())*compactItemSize
+}
+
+// compact 已删除的 key 占用过多空间时，在写锁内将存活的 key 拷贝到新的 arena 中，再替换原来的树和 arena
+// 旧的 arena 和树由已经创建的迭代器继续持有，不受影响
+func (ci *CompactIndex) compact() {
+	arenas := newCompactArenas(ci.cmp)
+	tree := btree.NewG(defaultDegree, arenas.less)
+	ci.tree.Ascend(func(item compactItem) bool {
+		item.arena, item.off = arenas.add(ci.arenas.key(item))
+		tree.ReplaceOrInsert(item)
+		return true
+	})
+	ci.tree, ci.arenas, ci.garbage = tree, arenas, 0
+}
@@ -0,0 +1,96 @@
+package index
+
+import (
+	"github.com/google/btree"
+	"github.com/tClown11/kv-storage/structure"
+)
+
+// 紧凑型索引迭代器
+// 与 BTree 索引迭代器相同，基于 copy-on-write 快照每次读取一小批数据
+type compactIterator struct {
+	tree      *btree.BTreeG[compactItem] // 创建迭代器时的索引快照
+	arenas    *compactArenas             // 快照中的 key 所在的 arena
+	reverse   bool                       // 是否是反向遍历
+	currIndex int                        // 当前批次中遍历的下标位置
+	values    []compactItem              // 当前批次的数据
+}
+
+func newCompactIterator(tree *btree.BTreeG[compactItem], arenas *compactArenas, reverse bool) *compactIterator {
+	ci := &compactIterator{
+		tree:    tree,
+		arenas:  arenas,
+		reverse: reverse,
+	}
+	ci.Rewind()
+	return ci
+}
+
+// Rewind 重新回到迭代器的起点，即第一个数据
+func (ci *compactIterator) Rewind() {
+	ci.load(nil, true)
+}
+
+// Seek 根据传入的 key 查找到第一个大于(或小于) 等于的目标 key，根据从这个 key 开始遍历
+func (ci *compactIterator) Seek(key []byte) {
+	probe := compactProbeItem(key)
+	ci.load(&probe, true)
+}
+
+// Next 跳转到下一个 key
+func (ci *compactIterator) Next() {
+	ci.currIndex += 1
+	if ci.currIndex < len(ci.values) || len(ci.values) < btreeIteratorBatchSize {
+		return
+	}
+	// 当前批次已经遍历完，从最后一个 key 之后继续读取
+	last := ci.values[len(ci.values)-1]
+	ci.load(&last, false)
+}
+
+// Valid 是否有效，即是否已经遍历完了所有的 key，用于退出遍历
+func (ci *compactIterator) Valid() bool {
+	return ci.currIndex < len(ci.values)
+}
+
+// Key 当前遍历位置的 key 数据
+func (ci *compactIterator) Key() []byte {
+	return ci.arenas.key(ci.values[ci.currIndex])
+}
+
+// Value 当前遍历位置的 Value 数据
+func (ci *compactIterator) Value() *structure.LogRecordPos {
+	return ci.values[ci.currIndex].pos()
+}
+
+// Close 关闭迭代器，释放相应的资源
+func (ci *compactIterator) Close() {
+	ci.tree = nil
+	ci.values = nil
+}
+
+// load 从 pivot 开始读取一批数据，pivot 为 nil 时从头( 或尾 )开始，inclusive 表示是否包含 pivot 本身
+func (ci *compactIterator) load(pivot *compactItem, inclusive bool) {
+	values := make([]compactItem, 0, btreeIteratorBatchSize)
+	saveValues := func(item compactItem) bool {
+		if !inclusive && item.arena == pivot.arena && item.off == pivot.off {
+			return true
+		}
+		values = append(values, item)
+		return len(values) < btreeIteratorBatchSize
+	}
+
+	if ci.tree != nil {
+		switch {
+		case pivot == nil && ci.reverse:
+			ci.tree.Descend(saveValues)
+		case pivot == nil:
+			ci.tree.Ascend(saveValues)
+		case ci.reverse:
+			ci.tree.DescendLessOrEqual(*pivot, saveValues)
+		default:
+			ci.tree.AscendGreaterOrEqual(*pivot, saveValues)
+		}
+	}
+	ci.currIndex = 0
+	ci.values = values
+}
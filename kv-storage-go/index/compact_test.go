@@ -0,0 +1,170 @@
+package index
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tClown11/kv-storage/structure"
+)
+
+func TestCompactIndex_PutGetDelete(t *testing.T) {
+	ci := NewCompactIndex(nil)
+
+	tests := []struct {
+		key    []byte
+		pos    *structure.LogRecordPos
+		result *structure.LogRecordPos
+	}{
+		{key: nil, pos: &structure.LogRecordPos{Fid: 1, Offset: 100, Size: 10}, result: nil},
+		{key: []byte("a"), pos: &structure.LogRecordPos{Fid: 1, Offset: 2, Size: 10}, result: nil},
+		{key: []byte("a"), pos: &structure.LogRecordPos{Fid: 11, Offset: 12, Size: 20}, result: &structure.LogRecordPos{Fid: 1, Offset: 2, Size: 10}},
+		{key: []byte("ab"), pos: &structure.LogRecordPos{Fid: 1, Offset: 3}, result: nil},
+	}
+	for i := range tests {
+		assert.Equal(t, tests[i].result, ci.Put(tests[i].key, tests[i].pos))
+	}
+	assert.Equal(t, 3, ci.Size())
+
+	assert.Equal(t, &structure.LogRecordPos{Fid: 1, Offset: 100, Size: 10}, ci.Get(nil))
+	assert.Equal(t, &structure.LogRecordPos{Fid: 11, Offset: 12, Size: 20}, ci.Get([]byte("a")))
+	assert.Nil(t, ci.Get([]byte("b")))
+
+	pos, ok := ci.Delete([]byte("a"))
+	assert.True(t, ok)
+	assert.Equal(t, &structure.LogRecordPos{Fid: 11, Offset: 12, Size: 20}, pos)
+	_, ok = ci.Delete([]byte("a"))
+	assert.False(t, ok)
+	assert.Equal(t, 2, ci.Size())
+	assert.True(t, ci.MemSize() > 0)
+}
+
+func TestCompactIndex_Iterator(t *testing.T) {
+	ci := NewCompactIndex(nil)
+
+	iter := ci.Iterator(false)
+	assert.False(t, iter.Valid())
+	iter.Close()
+
+	for i := 0; i < 1000; i++ {
+		ci.Put([]byte(fmt.Sprintf("key-%04d", i)), &structure.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	iter1 := ci.Iterator(false)
+	// 创建迭代器之后的修改对迭代器不可见
+	ci.Delete([]byte("key-0001"))
+	var i int
+	for iter1.Rewind(); iter1.Valid(); iter1.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%04d", i)), iter1.Key())
+		assert.Equal(t, int64(i), iter1.Value().Offset)
+		i++
+	}
+	assert.Equal(t, 1000, i)
+	iter1.Seek([]byte("key-05005"))
+	assert.Equal(t, []byte("key-0501"), iter1.Key())
+
+	iter2 := ci.Iterator(true)
+	i = 999
+	for iter2.Rewind(); iter2.Valid(); iter2.Next() {
+		if i == 1 {
+			i--
+		}
+		assert.Equal(t, []byte(fmt.Sprintf("key-%04d", i)), iter2.Key())
+		i--
+	}
+	assert.Equal(t, -1, i)
+	iter2.Seek([]byte("key-05005"))
+	assert.Equal(t, []byte("key-0500"), iter2.Key())
+}
+
+// 随机数据与 map 对比，覆盖 arena 的回收
+func TestCompactIndex_Random(t *testing.T) {
+	ci := NewCompactIndex(nil)
+	expected := make(map[string]*structure.LogRecordPos)
+	r := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 20000; i++ {
+		key := []byte(fmt.Sprintf("/data/%d/%d", r.Intn(50), r.Intn(300)))
+		if r.Intn(3) == 0 {
+			_, ok := ci.Delete(key)
+			_, exists := expected[string(key)]
+			assert.Equal(t, exists, ok)
+			delete(expected, string(key))
+			continue
+		}
+		pos := &structure.LogRecordPos{Fid: uint32(i), Offset: int64(i)}
+		ci.Put(key, pos)
+		expected[string(key)] = pos
+	}
+	assert.Equal(t, len(expected), ci.Size())
+
+	var sortedKeys []string
+	for key, pos := range expected {
+		assert.Equal(t, pos, ci.Get([]byte(key)))
+		sortedKeys = append(sortedKeys, key)
+	}
+	sort.Strings(sortedKeys)
+
+	iter := ci.Iterator(false)
+	defer iter.Close()
+	// 迭代器持有旧的 arena，删除数据触发回收之后依然可以正常遍历
+	for _, key := range sortedKeys {
+		ci.Delete([]byte(key))
+	}
+	assert.Equal(t, 0, ci.Size())
+
+	var idx int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, sortedKeys[idx], string(iter.Key()))
+		idx++
+	}
+	assert.Equal(t, len(sortedKeys), idx)
+}
+
+// 删除数据时在写锁内回收 arena，与并发的查找互不影响
+func TestCompactIndex_Compact(t *testing.T) {
+	ci := NewCompactIndex(nil)
+	expected := make(map[string]*structure.LogRecordPos)
+	for i := 0; i < 20000; i++ {
+		key := fmt.Sprintf("compact-key-%09d", i)
+		pos := &structure.LogRecordPos{Fid: 1, Offset: int64(i)}
+		ci.Put([]byte(key), pos)
+		expected[key] = pos
+	}
+	total := ci.arenas.total
+
+	var wg sync.WaitGroup
+	for g := 0; g < 128; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			// 同时进行的查找数量不受限制
+			for i := g * 10; i < 20000; i += 1280 {
+				assert.NotNil(t, ci.Get([]byte(fmt.Sprintf("compact-key-%09d", i))))
+			}
+		}(g)
+	}
+	for i := 0; i < 20000; i++ {
+		key := fmt.Sprintf("compact-key-%09d", i)
+		if i%10 == 0 {
+			pos := &structure.LogRecordPos{Fid: 2, Offset: int64(i)}
+			ci.Put([]byte(key), pos)
+			expected[key] = pos
+			continue
+		}
+		_, ok := ci.Delete([]byte(key))
+		assert.True(t, ok)
+		delete(expected, key)
+	}
+	wg.Wait()
+
+	assert.True(t, ci.arenas.total < total)
+	assert.True(t, ci.garbage*2 <= ci.arenas.total)
+	assert.Equal(t, len(expected), ci.Size())
+	for key, pos := range expected {
+		assert.Equal(t, pos, ci.Get([]byte(key)))
+	}
+}
@@ -0,0 +1,41 @@
+package index
+
+import "bytes"
+
+// Comparator 定义 key 的顺序，索引的遍历、Seek 以及范围查询都按照这个顺序进行
+// 两个 key 的字节完全相同时 Compare 必须返回 0，字节不同时不能返回 0，
+// 哈希类索引和 ART 索引通过字节判断 key 是否相同
+type Comparator interface {
+	// Compare a 小于、等于、大于 b 时分别返回负数、0、正数
+	Compare(a, b []byte) int
+
+	// Name 比较器的名称，会记录在数据目录中，重新打开时名称不一致会报错
+	Name() string
+}
+
+// BytewiseComparator 默认的比较器，按照字节序比较
+var BytewiseComparator Comparator = bytewiseComparator{}
+
+type bytewiseComparator struct{}
+
+func (bytewiseComparator) Compare(a, b []byte) int {
+	return bytes.Compare(a, b)
+}
+
+func (bytewiseComparator) Name() string {
+	return "kv-storage.BytewiseComparator"
+}
+
+// IsBytewise 判断比较器是否是按照字节序比较，为 nil 时使用默认的字节序
+func IsBytewise(cmp Comparator) bool {
+	_, ok := cmp.(bytewiseComparator)
+	return cmp == nil || ok
+}
+
+// OrDefault 为 nil 时返回默认的比较器
+func OrDefault(cmp Comparator) Comparator {
+	if cmp == nil {
+		return BytewiseComparator
+	}
+	return cmp
+}
@@ -0,0 +1,94 @@
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tClown11/kv-storage/structure"
+)
+
+// reverseComparator 按照字节序的逆序排序
+type reverseComparator struct{}
+
+func (reverseComparator) Compare(a, b []byte) int { return bytes.Compare(b, a) }
+
+func (reverseComparator) Name() string { return "test.ReverseComparator" }
+
+func TestComparator_AllIndexes(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "comparator-bptree")
+	defer os.RemoveAll(dir)
+
+	cmp := reverseComparator{}
+	records := make(map[structure.LogRecordPos][]byte)
+	recordKey := func(pos *structure.LogRecordPos) ([]byte, error) {
+		return records[*pos], nil
+	}
+	scanRecords := func(fn func(key []byte, pos *structure.LogRecordPos) bool) error {
+		for pos, key := range records {
+			if !fn(key, &pos) {
+				break
+			}
+		}
+		return nil
+	}
+	bpt, err := NewBPlusTree(dir, false, cmp)
+	assert.Nil(t, err)
+	defer bpt.Close()
+
+	indexes := map[string]Indexer{
+		"btree":    NewBtree(32, cmp),
+		"art":      NewART(cmp),
+		"hash":     NewHashIndex(cmp),
+		"skiplist": NewSkiplist(cmp),
+		"compact":  NewCompactIndex(cmp),
+		"keyhash":  NewKeyHashIndex(recordKey, scanRecords, cmp),
+		"bptree":   bpt,
+		"sharded":  NewShardedIndex(4, cmp, func() Indexer { return NewBtree(32, cmp) }),
+	}
+	for name, idx := range indexes {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				key := []byte(fmt.Sprintf("key-%03d", i))
+				pos := &structure.LogRecordPos{Fid: 1, Offset: int64(i)}
+				records[*pos] = key
+				idx.Put(key, pos)
+			}
+			assert.NotNil(t, idx.Get([]byte("key-050")))
+
+			// 正向遍历按照比较器的顺序，即字节序的逆序
+			iter := idx.Iterator(false)
+			assert.Equal(t, []byte("key-099"), iter.Key())
+			iter.Seek([]byte("key-050"))
+			assert.Equal(t, []byte("key-050"), iter.Key())
+			iter.Next()
+			assert.Equal(t, []byte("key-049"), iter.Key())
+			iter.Seek([]byte("key-0505"))
+			assert.Equal(t, []byte("key-050"), iter.Key())
+			iter.Close()
+
+			iter = idx.Iterator(true)
+			assert.Equal(t, []byte("key-000"), iter.Key())
+			iter.Seek([]byte("key-050"))
+			assert.Equal(t, []byte("key-050"), iter.Key())
+			iter.Next()
+			assert.Equal(t, []byte("key-051"), iter.Key())
+			iter.Close()
+
+			// 范围同样按照比较器的顺序
+			bounded := NewBoundedIterator(idx.Iterator(false), []byte("key-020"), []byte("key-010"), false, cmp)
+			var count int
+			for bounded.Rewind(); bounded.Valid(); bounded.Next() {
+				count++
+			}
+			bounded.Close()
+			assert.Equal(t, 10, count)
+
+			if counter, ok := idx.(RangeCounter); ok {
+				assert.Equal(t, 10, counter.CountRange([]byte("key-020"), []byte("key-010")))
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package index_test
+
+import (
+	"testing"
+
+	"github.com/tClown11/kv-storage/index"
+	"github.com/tClown11/kv-storage/index/indextest"
+)
+
+func TestIndexer_Conformance(t *testing.T) {
+	types := map[string]index.IndexType{
+		"btree":    index.BTree,
+		"art":      index.ART,
+		"bptree":   index.BPTree,
+		"hash":     index.Hash,
+		"skiplist": index.SkipList,
+		"compact":  index.Compact,
+		"keyhash":  index.KeyHash,
+	}
+	for name, typ := range types {
+		t.Run(name, func(t *testing.T) {
+			indextest.Run(t, func(opts *index.IndexOpts) (index.Indexer, error) {
+				opts.Type = typ
+				return index.NewIndexer(opts)
+			})
+		})
+		t.Run(name+"-sharded", func(t *testing.T) {
+			indextest.Run(t, func(opts *index.IndexOpts) (index.Indexer, error) {
+				opts.Type = typ
+				opts.Shards = 4
+				return index.NewIndexer(opts)
+			})
+		})
+	}
+}
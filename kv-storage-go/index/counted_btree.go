@@ -0,0 +1,403 @@
+package index
+
+import (
+	"sort"
+)
+
+// countedBTree 记录子树数据量的 B 树，可以在 O(log n) 时间内统计范围内的数据量
+// 与 google/btree 相同，通过 copy-on-write 支持 O(1) 的 Clone，克隆之后只有被修改的节点会被复制
+type countedBTree struct {
+	degree int
+	less   func(a, b *BItem) bool
+	root   *countedNode
+	cow    *countedCow
+}
+
+// countedCow 节点的所有者标记，节点只能被创建它的树修改，其他的树修改之前需要先复制
+type countedCow struct {
+	_ byte // 保证每次分配的地址不同
+}
+
+type countedNode struct {
+	items    []*BItem
+	children []*countedNode
+	count    int // 子树中的数据量，包含节点自身的数据
+	cow      *countedCow
+}
+
+func newCountedBTree(degree int, less func(a, b *BItem) bool) *countedBTree {
+	return &countedBTree{degree: degree, less: less, cow: new(countedCow)}
+}
+
+func (t *countedBTree) maxItems() int {
+	return t.degree*2 - 1
+}
+
+func (t *countedBTree) minItems() int {
+	return t.degree - 1
+}
+
+// Clone 返回树的快照，之后两棵树的修改互不影响
+func (t *countedBTree) Clone() *countedBTree {
+	// 两棵树都使用新的所有者标记，原有的节点对双方都是只读的
+	out := *t
+	t.cow = new(countedCow)
+	out.cow = new(countedCow)
+	return &out
+}
+
+func (t *countedBTree) Len() int {
+	if t.root == nil {
+		return 0
+	}
+	return t.root.count
+}
+
+func (t *countedBTree) Get(key *BItem) (*BItem, bool) {
+	n := t.root
+	for n != nil {
+		i, found := n.find(key, t.less)
+		if found {
+			return n.items[i], true
+		}
+		if len(n.children) == 0 {
+			break
+		}
+		n = n.children[i]
+	}
+	return nil, false
+}
+
+// ReplaceOrInsert 写入数据，已经存在时替换并返回原来的数据
+func (t *countedBTree) ReplaceOrInsert(item *BItem) (*BItem, bool) {
+	if t.root == nil {
+		t.root = t.newNode()
+		t.root.items = append(t.root.items, item)
+		t.root.count = 1
+		return nil, false
+	}
+
+	t.root = t.mutable(t.root)
+	if len(t.root.items) >= t.maxItems() {
+		// 根节点已满时先分裂，树的高度加一
+		mid, second := t.split(t.root, t.maxItems()/2)
+		oldRoot := t.root
+		t.root = t.newNode()
+		t.root.items = append(t.root.items, mid)
+		t.root.children = append(t.root.children, oldRoot, second)
+		t.root.count = oldRoot.count + second.count + 1
+	}
+	return t.insert(t.root, item)
+}
+
+// Delete 删除数据，返回被删除的数据
+func (t *countedBTree) Delete(key *BItem) (*BItem, bool) {
+	if t.root == nil || len(t.root.items) == 0 {
+		return nil, false
+	}
+	t.root = t.mutable(t.root)
+	out, found := t.remove(t.root, key, removeItem)
+	// 根节点的数据被合并到子节点中时，树的高度减一
+	if len(t.root.items) == 0 && len(t.root.children) > 0 {
+		t.root = t.root.children[0]
+	}
+	return out, found
+}
+
+// CountLess 统计小于 key 的数据量
+func (t *countedBTree) CountLess(key *BItem) int {
+	var count int
+	for n := t.root; n != nil; {
+		i := sort.Search(len(n.items), func(i int) bool {
+			return !t.less(n.items[i], key)
+		})
+		count += i
+		if len(n.children) == 0 {
+			break
+		}
+		for _, child := range n.children[:i] {
+			count += child.count
+		}
+		n = n.children[i]
+	}
+	return count
+}
+
+// Ascend 从小到大遍历，iter 返回 false 时停止
+func (t *countedBTree) Ascend(iter func(*BItem) bool) {
+	t.AscendGreaterOrEqual(nil, iter)
+}
+
+// AscendGreaterOrEqual 从大于等于 pivot 的数据开始从小到大遍历，pivot 为 nil 时从头开始
+func (t *countedBTree) AscendGreaterOrEqual(pivot *BItem, iter func(*BItem) bool) {
+	if t.root != nil {
+		t.ascend(t.root, pivot, iter)
+	}
+}
+
+// Descend 从大到小遍历，iter 返回 false 时停止
+func (t *countedBTree) Descend(iter func(*BItem) bool) {
+	t.DescendLessOrEqual(nil, iter)
+}
+
+// DescendLessOrEqual 从小于等于 pivot 的数据开始从大到小遍历，pivot 为 nil 时从尾开始
+func (t *countedBTree) DescendLessOrEqual(pivot *BItem, iter func(*BItem) bool) {
+	if t.root != nil {
+		t.descend(t.root, pivot, iter)
+	}
+}
+
+func (t *countedBTree) ascend(n *countedNode, pivot *BItem, iter func(*BItem) bool) bool {
+	var i int
+	if pivot != nil {
+		i = sort.Search(len(n.items), func(i int) bool {
+			return !t.less(n.items[i], pivot)
+		})
+	}
+	for ; i < len(n.items); i++ {
+		if len(n.children) > 0 && !t.ascend(n.children[i], pivot, iter) {
+			return false
+		}
+		if !iter(n.items[i]) {
+			return false
+		}
+	}
+	if len(n.children) > 0 {
+		return t.ascend(n.children[len(n.children)-1], pivot, iter)
+	}
+	return true
+}
+
+func (t *countedBTree) descend(n *countedNode, pivot *BItem, iter func(*BItem) bool) bool {
+	i := len(n.items)
+	if pivot != nil {
+		i = sort.Search(len(n.items), func(i int) bool {
+			return t.less(pivot, n.items[i])
+		})
+	}
+	if len(n.children) > 0 && !t.descend(n.children[i], pivot, iter) {
+		return false
+	}
+	for i--; i >= 0; i-- {
+		if !iter(n.items[i]) {
+			return false
+		}
+		if len(n.children) > 0 && !t.descend(n.children[i], pivot, iter) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *countedBTree) newNode() *countedNode {
+	return &countedNode{cow: t.cow}
+}
+
+// mutable 返回可以被当前的树修改的节点，节点属于其他的树时先复制
+func (t *countedBTree) mutable(n *countedNode) *countedNode {
+	if n.cow == t.cow {
+		return n
+	}
+	out := &countedNode{count: n.count, cow: t.cow}
+	out.items = append(make([]*BItem, 0, cap(n.items)), n.items...)
+	if len(n.children) > 0 {
+		out.children = append(make([]*countedNode, 0, cap(n.children)), n.children...)
+	}
+	return out
+}
+
+func (t *countedBTree) mutableChild(n *countedNode, i int) *countedNode {
+	child := t.mutable(n.children[i])
+	n.children[i] = child
+	return child
+}
+
+// split 在下标 i 处分裂节点，返回中间的数据以及分裂出的右半部分
+func (t *countedBTree) split(n *countedNode, i int) (*BItem, *countedNode) {
+	item := n.items[i]
+	next := t.newNode()
+	next.items = append(next.items, n.items[i+1:]...)
+	clear(n.items[i:])
+	n.items = n.items[:i]
+	if len(n.children) > 0 {
+		next.children = append(next.children, n.children[i+1:]...)
+		clear(n.children[i+1:])
+		n.children = n.children[:i+1]
+	}
+	next.recount()
+	n.recount()
+	return item, next
+}
+
+func (t *countedBTree) insert(n *countedNode, item *BItem) (*BItem, bool) {
+	i, found := n.find(item, t.less)
+	if found {
+		out := n.items[i]
+		n.items[i] = item
+		return out, true
+	}
+	if len(n.children) == 0 {
+		n.items = insertAt(n.items, i, item)
+		n.count++
+		return nil, false
+	}
+
+	// 子节点已满时先分裂，保证插入时不需要回溯
+	if len(n.children[i].items) >= t.maxItems() {
+		mid, second := t.split(t.mutableChild(n, i), t.maxItems()/2)
+		n.items = insertAt(n.items, i, mid)
+		n.children = insertAt(n.children, i+1, second)
+		switch {
+		case t.less(item, mid):
+		case t.less(mid, item):
+			i++
+		default:
+			n.items[i] = item
+			return mid, true
+		}
+	}
+	out, found := t.insert(t.mutableChild(n, i), item)
+	if !found {
+		n.count++
+	}
+	return out, found
+}
+
+type removeType int
+
+const (
+	removeItem removeType = iota // 删除指定的数据
+	removeMax                    // 删除子树中最大的数据
+)
+
+func (t *countedBTree) remove(n *countedNode, key *BItem, typ removeType) (*BItem, bool) {
+	var (
+		i     int
+		found bool
+	)
+	switch typ {
+	case removeMax:
+		if len(n.children) == 0 {
+			out := n.items[len(n.items)-1]
+			n.items = removeAt(n.items, len(n.items)-1)
+			n.count--
+			return out, true
+		}
+		i = len(n.items)
+	default:
+		i, found = n.find(key, t.less)
+		if len(n.children) == 0 {
+			if !found {
+				return nil, false
+			}
+			out := n.items[i]
+			n.items = removeAt(n.items, i)
+			n.count--
+			return out, true
+		}
+	}
+
+	// 子节点的数据量不足时先从相邻的节点借一个数据或者合并，保证删除之后不会少于最小值
+	if len(n.children[i].items) <= t.minItems() {
+		t.growChild(n, i)
+		return t.remove(n, key, typ)
+	}
+	child := t.mutableChild(n, i)
+	if found {
+		// 用左子树中最大的数据替换被删除的数据
+		out := n.items[i]
+		n.items[i], _ = t.remove(child, nil, removeMax)
+		n.count--
+		return out, true
+	}
+	out, ok := t.remove(child, key, typ)
+	if ok {
+		n.count--
+	}
+	return out, ok
+}
+
+// growChild 使第 i 个子节点的数据量大于最小值，节点自身的 count 不变
+func (t *countedBTree) growChild(n *countedNode, i int) {
+	switch {
+	case i > 0 && len(n.children[i-1].items) > t.minItems():
+		// 从左边的节点借一个数据
+		child := t.mutableChild(n, i)
+		from := t.mutableChild(n, i-1)
+		child.items = insertAt(child.items, 0, n.items[i-1])
+		n.items[i-1] = from.items[len(from.items)-1]
+		from.items = removeAt(from.items, len(from.items)-1)
+		moved := 1
+		if len(from.children) > 0 {
+			last := from.children[len(from.children)-1]
+			from.children = removeAt(from.children, len(from.children)-1)
+			child.children = insertAt(child.children, 0, last)
+			moved += last.count
+		}
+		from.count -= moved
+		child.count += moved
+	case i < len(n.items) && len(n.children[i+1].items) > t.minItems():
+		// 从右边的节点借一个数据
+		child := t.mutableChild(n, i)
+		from := t.mutableChild(n, i+1)
+		child.items = append(child.items, n.items[i])
+		n.items[i] = from.items[0]
+		from.items = removeAt(from.items, 0)
+		moved := 1
+		if len(from.children) > 0 {
+			first := from.children[0]
+			from.children = removeAt(from.children, 0)
+			child.children = append(child.children, first)
+			moved += first.count
+		}
+		from.count -= moved
+		child.count += moved
+	default:
+		// 与右边的节点合并
+		if i >= len(n.items) {
+			i--
+		}
+		child := t.mutableChild(n, i)
+		merged := n.children[i+1]
+		child.items = append(child.items, n.items[i])
+		child.items = append(child.items, merged.items...)
+		child.children = append(child.children, merged.children...)
+		child.count += merged.count + 1
+		n.items = removeAt(n.items, i)
+		n.children = removeAt(n.children, i+1)
+	}
+}
+
+// find 查找 key 在节点中的位置，不存在时返回应该插入的位置
+func (n *countedNode) find(key *BItem, less func(a, b *BItem) bool) (int, bool) {
+	i := sort.Search(len(n.items), func(i int) bool {
+		return less(key, n.items[i])
+	})
+	if i > 0 && !less(n.items[i-1], key) {
+		return i - 1, true
+	}
+	return i, false
+}
+
+// recount 根据节点的数据和子节点重新计算 count
+func (n *countedNode) recount() {
+	n.count = len(n.items)
+	for _, child := range n.children {
+		n.count += child.count
+	}
+}
+
+func insertAt[T any](s []T, i int, v T) []T {
+	var zero T
+	s = append(s, zero)
+	copy(s[i+1:], s[i:])
+	s[i] = v
+	return s
+}
+
+func removeAt[T any](s []T, i int) []T {
+	var zero T
+	copy(s[i:], s[i+1:])
+	s[len(s)-1] = zero
+	return s[:len(s)-1]
+}
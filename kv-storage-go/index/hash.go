@@ -0,0 +1,89 @@
+package index
+
+import (
+	"sync"
+
+	"github.com/tClown11/kv-storage/structure"
+)
+
+// HashIndex 哈希表索引，Put/Get/Delete 的时间复杂度为 O(1)
+// 数据本身是无序的，只有在创建迭代器时才会拷贝一份快照并排序，适合很少进行范围遍历的场景
+type HashIndex struct {
+	table   map[string]*structure.LogRecordPos
+	keySize int64 // 所有 key 的总大小
+	cmp     Comparator
+	lock    *sync.RWMutex
+}
+
+// hashItemOverhead 每条数据除 key 之外的内存占用：map 中的 string 和指针、桶的额外开销以及 LogRecordPos
+const hashItemOverhead = 16 + 8 + 16 + 24
+
+// NewHashIndex 初始化哈希表索引，cmp 为遍历时的排序方式，为 nil 时按照字节序排序
+func NewHashIndex(cmp Comparator) *HashIndex {
+	return &HashIndex{
+		table: make(map[string]*structure.LogRecordPos),
+		cmp:   OrDefault(cmp),
+		lock:  new(sync.RWMutex),
+	}
+}
+
+func (hi *HashIndex) Put(key []byte, pos *structure.LogRecordPos) *structure.LogRecordPos {
+	hi.lock.Lock()
+	defer hi.lock.Unlock()
+
+	oldPos, ok := hi.table[string(key)]
+	if !ok {
+		hi.keySize += int64(len(key))
+	}
+	hi.table[string(key)] = pos
+	return oldPos
+}
+
+func (hi *HashIndex) Get(key []byte) *structure.LogRecordPos {
+	hi.lock.RLock()
+	defer hi.lock.RUnlock()
+	return hi.table[string(key)]
+}
+
+func (hi *HashIndex) Delete(key []byte) (*structure.LogRecordPos, bool) {
+	hi.lock.Lock()
+	defer hi.lock.Unlock()
+
+	oldPos, ok := hi.table[string(key)]
+	if !ok {
+		return nil, false
+	}
+	delete(hi.table, string(key))
+	hi.keySize -= int64(len(key))
+	return oldPos, true
+}
+
+func (hi *HashIndex) Iterator(reverse bool) Iterator {
+	hi.lock.RLock()
+	values := make([]*indexItem, 0, len(hi.table))
+	for key, pos := range hi.table {
+		values = append(values, &indexItem{key: []byte(key), pos: pos})
+	}
+	hi.lock.RUnlock()
+
+	// 排序在锁外进行，不阻塞其他的读写
+	sortIndexItems(values, reverse, hi.cmp)
+	return newSliceIterator(values, reverse, hi.cmp)
+}
+
+func (hi *HashIndex) Size() int {
+	hi.lock.RLock()
+	defer hi.lock.RUnlock()
+	return len(hi.table)
+}
+
+// MemSize 索引占用的内存大小
+func (hi *HashIndex) MemSize() int64 {
+	hi.lock.RLock()
+	defer hi.lock.RUnlock()
+	return int64(len(hi.table))*hashItemOverhead + hi.keySize
+}
+
+func (hi *HashIndex) Close() error {
+	return nil
+}
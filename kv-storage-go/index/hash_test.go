@@ -0,0 +1,68 @@
+package index
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tClown11/kv-storage/structure"
+)
+
+func TestHashIndex_PutGetDelete(t *testing.T) {
+	hi := NewHashIndex(nil)
+
+	tests := []struct {
+		key    []byte
+		pos    *structure.LogRecordPos
+		result *structure.LogRecordPos
+	}{
+		{key: nil, pos: &structure.LogRecordPos{Fid: 1, Offset: 100}, result: nil},
+		{key: []byte("a"), pos: &structure.LogRecordPos{Fid: 1, Offset: 2}, result: nil},
+		{key: []byte("a"), pos: &structure.LogRecordPos{Fid: 11, Offset: 12}, result: &structure.LogRecordPos{Fid: 1, Offset: 2}},
+	}
+	for i := range tests {
+		assert.Equal(t, tests[i].result, hi.Put(tests[i].key, tests[i].pos))
+	}
+	assert.Equal(t, 2, hi.Size())
+
+	assert.Equal(t, &structure.LogRecordPos{Fid: 1, Offset: 100}, hi.Get(nil))
+	assert.Equal(t, &structure.LogRecordPos{Fid: 11, Offset: 12}, hi.Get([]byte("a")))
+	assert.Nil(t, hi.Get([]byte("b")))
+
+	pos, ok := hi.Delete([]byte("a"))
+	assert.True(t, ok)
+	assert.Equal(t, &structure.LogRecordPos{Fid: 11, Offset: 12}, pos)
+	_, ok = hi.Delete([]byte("a"))
+	assert.False(t, ok)
+	assert.Equal(t, 1, hi.Size())
+}
+
+func TestHashIndex_Iterator(t *testing.T) {
+	hi := NewHashIndex(nil)
+
+	iter := hi.Iterator(false)
+	assert.False(t, iter.Valid())
+	iter.Close()
+
+	for i := 0; i < 100; i++ {
+		hi.Put([]byte(fmt.Sprintf("key-%03d", i)), &structure.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	iter1 := hi.Iterator(false)
+	// 创建迭代器之后的修改不可见
+	hi.Put([]byte("key-100"), &structure.LogRecordPos{Fid: 1, Offset: 100})
+	var i int
+	for iter1.Rewind(); iter1.Valid(); iter1.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%03d", i)), iter1.Key())
+		i++
+	}
+	assert.Equal(t, 100, i)
+	iter1.Seek([]byte("key-0505"))
+	assert.Equal(t, []byte("key-051"), iter1.Key())
+
+	iter2 := hi.Iterator(true)
+	iter2.Rewind()
+	assert.Equal(t, []byte("key-100"), iter2.Key())
+	iter2.Seek([]byte("key-0505"))
+	assert.Equal(t, []byte("key-050"), iter2.Key())
+}
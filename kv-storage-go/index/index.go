@@ -1,7 +1,13 @@
 package index
 
-import "github.com/tClown11/kv-storage/structure"
+import (
+	"fmt"
 
+	"github.com/tClown11/kv-storage/errs"
+	"github.com/tClown11/kv-storage/structure"
+)
+
+// Indexer 索引接口，实现需要支持并发调用，db.Get 读取索引时不持有 db.mu
 type Indexer interface {
 	// Put 向索引中存储 key 对应的数据位置信息
 	Put(key []byte, pos *structure.LogRecordPos) *structure.LogRecordPos
@@ -22,6 +28,78 @@ type Indexer interface {
 	Close() error
 }
 
+// Persistent 持久化到磁盘上的索引，重启后不需要重新加载全部的数据文件
+type Persistent interface {
+	Indexer
+
+	// CheckpointPos 最近一次 Checkpoint 时记录的数据位置，为 nil 表示索引不完整，需要重新构建
+	CheckpointPos() *structure.LogRecordPos
+
+	// Checkpoint 将索引数据落盘，并记录索引已经覆盖到的数据位置
+	Checkpoint(pos *structure.LogRecordPos) error
+
+	// Reset 清空索引
+	Reset() error
+}
+
+// MemSizer 可以统计自身内存占用的索引
+type MemSizer interface {
+	// MemSize 索引占用的内存大小，字节为单位，为估算值
+	MemSize() int64
+}
+
+// RangeCounter 可以快速统计范围内数据量的索引
+type RangeCounter interface {
+	// CountRange 统计 [lowerBound, upperBound) 范围内 key 的数量，边界为 nil 表示不限制
+	CountRange(lowerBound, upperBound []byte) int
+}
+
+// FallibleIndexer 读写过程中可能出错的索引，例如需要读写磁盘的索引
+// Indexer 中的方法出错时 Get 返回 nil，Put 和 Delete 忽略错误，需要获取错误时使用以下方法
+type FallibleIndexer interface {
+	Indexer
+
+	// PutWithError 与 Put 相同，出错时返回错误
+	PutWithError(key []byte, pos *structure.LogRecordPos) (*structure.LogRecordPos, error)
+
+	// GetWithError 与 Get 相同，出错时返回错误
+	GetWithError(key []byte) (*structure.LogRecordPos, error)
+
+	// DeleteWithError 与 Delete 相同，出错时返回错误
+	DeleteWithError(key []byte) (*structure.LogRecordPos, bool, error)
+}
+
+// PutWithError 向索引中存储数据，索引支持时返回读写过程中的错误
+func PutWithError(indexer Indexer, key []byte, pos *structure.LogRecordPos) (*structure.LogRecordPos, error) {
+	if fallible, ok := indexer.(FallibleIndexer); ok {
+		return fallible.PutWithError(key, pos)
+	}
+	return indexer.Put(key, pos), nil
+}
+
+// GetWithError 从索引中获取数据，索引支持时返回读写过程中的错误
+func GetWithError(indexer Indexer, key []byte) (*structure.LogRecordPos, error) {
+	if fallible, ok := indexer.(FallibleIndexer); ok {
+		return fallible.GetWithError(key)
+	}
+	return indexer.Get(key), nil
+}
+
+// DeleteWithError 从索引中删除数据，索引支持时返回读写过程中的错误
+func DeleteWithError(indexer Indexer, key []byte) (*structure.LogRecordPos, bool, error) {
+	if fallible, ok := indexer.(FallibleIndexer); ok {
+		return fallible.DeleteWithError(key)
+	}
+	oldPos, ok := indexer.Delete(key)
+	return oldPos, ok, nil
+}
+
+// KeySizeLimiter 限制了 key 长度的索引，写入超过长度的 key 之前需要拒绝
+type KeySizeLimiter interface {
+	// MaxKeySize 支持的最大 key 长度
+	MaxKeySize() int
+}
+
 type IndexType = int8
 
 const (
@@ -33,6 +111,19 @@ const (
 
 	// BPTree B+ 树索引
 	BPTree
+
+	// Hash 哈希表索引，只适合点查询，遍历时需要对全部数据排序
+	Hash
+
+	// SkipList 跳表索引，读操作不加锁
+	SkipList
+
+	// Compact 紧凑型内存索引，key 存放在 arena 中，适合大量的小 key
+	Compact
+
+	// KeyHash key 哈希索引，内存中只保存 key 的哈希值，需要通过 IndexOpts.RecordKey 读取实际的 key，
+	// 通过 IndexOpts.ScanRecords 遍历
+	KeyHash
 )
 
 // IndexOpts 用于初始化不同类型的 Index
@@ -41,20 +132,47 @@ type IndexOpts struct {
 	DirPath string
 	Sync    bool
 	Size    int
+	Shards  int // 内存索引的分片数量，大于 1 时使用分片索引，持久化索引和 KeyHash 索引不支持分片
+
+	// Comparator key 的排序方式，为 nil 时按照字节序排序
+	Comparator Comparator
+
+	// RecordKey 读取位置信息指向的数据的 key，KeyHash 索引使用
+	RecordKey RecordKeyFunc
+
+	// ScanRecords 顺序遍历数据文件中的所有数据，KeyHash 索引遍历时使用
+	ScanRecords ScanRecordsFunc
 }
 
-// NewIndexer 根据类型初始化索引
-func NewIndexer(opts *IndexOpts) Indexer {
-	switch opts.Type {
-	case BTree:
-		return NewBtree(opts.Size)
-	// case ART:
-	// 	return NewART()
-	// case BPTree:
-	// 	return NewBPlusTree(opts.DirPath, opts.Sync)
-	default:
-		panic("unsupported index type")
+// NewIndexer 根据类型初始化索引，类型需要通过 Register 注册
+// 分片数量大于 1 时创建分片索引，持久化索引不支持分片
+func NewIndexer(opts *IndexOpts) (Indexer, error) {
+	factory, ok := lookupFactory(opts.Type)
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", errs.ErrIndexTypeUnsupported, opts.Type)
+	}
+
+	first, err := factory(opts)
+	if err != nil {
+		return nil, err
+	}
+	// KeyHash 索引遍历时需要扫描全部的数据文件，分片之后每个分片都要扫描一次
+	if _, ok := first.(Persistent); ok || opts.Shards <= 1 || opts.Type == KeyHash {
+		return first, nil
+	}
+
+	shards := []Indexer{first}
+	for len(shards) < opts.Shards {
+		shard, err := factory(opts)
+		if err != nil {
+			for _, shard := range shards {
+				_ = shard.Close()
+			}
+			return nil, err
+		}
+		shards = append(shards, shard)
 	}
+	return newShardedIndex(shards, opts.Comparator), nil
 }
 
 // Iterator 通用索引迭代器
@@ -0,0 +1,261 @@
+// Package indextest 索引的一致性测试，任何 index.Indexer 的实现都可以使用
+package indextest
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tClown11/kv-storage/index"
+	"github.com/tClown11/kv-storage/structure"
+)
+
+// records 记录每个位置信息对应的 key，提供给需要读取数据文件中 key 的索引
+type records struct {
+	mu     sync.Mutex
+	keys   map[structure.LogRecordPos][]byte
+	offset int64
+}
+
+// newPos 为 key 分配一个唯一的位置信息
+func (r *records) newPos(key []byte) *structure.LogRecordPos {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.offset++
+	pos := &structure.LogRecordPos{Fid: 1, Offset: r.offset, Size: uint32(len(key))}
+	r.keys[*pos] = append([]byte(nil), key...)
+	return pos
+}
+
+func (r *records) recordKey(pos *structure.LogRecordPos) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key, ok := r.keys[*pos]
+	if !ok {
+		return nil, fmt.Errorf("no record at %+v", *pos)
+	}
+	return key, nil
+}
+
+func (r *records) scanRecords(fn func(key []byte, pos *structure.LogRecordPos) bool) error {
+	r.mu.Lock()
+	keys := make(map[structure.LogRecordPos][]byte, len(r.keys))
+	for pos, key := range r.keys {
+		keys[pos] = key
+	}
+	r.mu.Unlock()
+
+	for pos, key := range keys {
+		if !fn(key, &pos) {
+			break
+		}
+	}
+	return nil
+}
+
+// Run 运行一致性测试，每个子测试都会通过 factory 创建一个新的索引
+// 传给 factory 的配置项中 DirPath 为临时目录，RecordKey 和 ScanRecords 可以读取到写入的 key，排序方式为字节序
+func Run(t *testing.T, factory index.Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, idx index.Indexer, r *records)
+	}{
+		{"PutGet", testPutGet},
+		{"Delete", testDelete},
+		{"BinaryKeys", testBinaryKeys},
+		{"Iterator", testIterator},
+		{"IteratorSeek", testIteratorSeek},
+		{"EmptyIterator", testEmptyIterator},
+		{"Concurrent", testConcurrent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &records{keys: make(map[structure.LogRecordPos][]byte)}
+			idx, err := factory(&index.IndexOpts{
+				DirPath:     t.TempDir(),
+				RecordKey:   r.recordKey,
+				ScanRecords: r.scanRecords,
+			})
+			if err != nil {
+				t.Fatalf("failed to create indexer: %v", err)
+			}
+			defer func() {
+				assert.Nil(t, idx.Close())
+			}()
+			tt.fn(t, idx, r)
+		})
+	}
+}
+
+func testPutGet(t *testing.T, idx index.Indexer, r *records) {
+	key := []byte("key-1")
+	pos1 := r.newPos(key)
+	assert.Nil(t, idx.Put(key, pos1))
+	assert.Equal(t, pos1, idx.Get(key))
+	assert.Equal(t, 1, idx.Size())
+
+	// 覆盖写入时返回旧的位置信息
+	pos2 := r.newPos(key)
+	assert.Equal(t, pos1, idx.Put(key, pos2))
+	assert.Equal(t, pos2, idx.Get(key))
+	assert.Equal(t, 1, idx.Size())
+
+	assert.Nil(t, idx.Get([]byte("key-2")))
+}
+
+func testDelete(t *testing.T, idx index.Indexer, r *records) {
+	oldPos, ok := idx.Delete([]byte("not-exist"))
+	assert.Nil(t, oldPos)
+	assert.False(t, ok)
+
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key-%03d", i))
+		idx.Put(key, r.newPos(key))
+	}
+	pos := idx.Get([]byte("key-050"))
+	oldPos, ok = idx.Delete([]byte("key-050"))
+	assert.True(t, ok)
+	assert.Equal(t, pos, oldPos)
+	assert.Nil(t, idx.Get([]byte("key-050")))
+	assert.Equal(t, 99, idx.Size())
+
+	oldPos, ok = idx.Delete([]byte("key-050"))
+	assert.Nil(t, oldPos)
+	assert.False(t, ok)
+
+	// 删除之后其它 key 不受影响，也可以重新写入
+	for i := 0; i < 100; i += 2 {
+		_, ok := idx.Delete([]byte(fmt.Sprintf("key-%03d", i)))
+		assert.Equal(t, i != 50, ok)
+	}
+	for i := 1; i < 100; i += 2 {
+		assert.NotNil(t, idx.Get([]byte(fmt.Sprintf("key-%03d", i))))
+	}
+	assert.Nil(t, idx.Put([]byte("key-050"), r.newPos([]byte("key-050"))))
+	assert.Equal(t, 51, idx.Size())
+}
+
+func testBinaryKeys(t *testing.T, idx index.Indexer, r *records) {
+	keys := [][]byte{{0x00}, {0x00, 0x00}, {0xff}, {0xff, 0x00}, []byte("a"), []byte("a\x00"), []byte("ab"), []byte("abc")}
+	for _, key := range keys {
+		assert.Nil(t, idx.Put(key, r.newPos(key)))
+	}
+	assert.Equal(t, len(keys), idx.Size())
+	for _, key := range keys {
+		pos := idx.Get(key)
+		if assert.NotNil(t, pos) {
+			recordKey, err := r.recordKey(pos)
+			assert.Nil(t, err)
+			assert.Equal(t, key, recordKey)
+		}
+	}
+	assert.Nil(t, idx.Get([]byte("abcd")))
+	assert.Nil(t, idx.Get([]byte{0x00, 0x00, 0x00}))
+}
+
+// putRandomKeys 乱序写入 n 个 key，返回排好序的 key
+func putRandomKeys(idx index.Indexer, r *records, n int) [][]byte {
+	rnd := rand.New(rand.NewSource(1))
+	keys := make([][]byte, 0, n)
+	for _, i := range rnd.Perm(n) {
+		key := []byte(fmt.Sprintf("key-%05d", i*2))
+		idx.Put(key, r.newPos(key))
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+	return keys
+}
+
+func testIterator(t *testing.T, idx index.Indexer, r *records) {
+	keys := putRandomKeys(idx, r, 1000)
+
+	for _, reverse := range []bool{false, true} {
+		iter := idx.Iterator(reverse)
+		var got [][]byte
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			got = append(got, append([]byte(nil), iter.Key()...))
+			assert.Equal(t, idx.Get(iter.Key()), iter.Value())
+		}
+		want := make([][]byte, len(keys))
+		copy(want, keys)
+		if reverse {
+			for i, j := 0, len(want)-1; i < j; i, j = i+1, j-1 {
+				want[i], want[j] = want[j], want[i]
+			}
+		}
+		assert.Equal(t, want, got)
+
+		// Rewind 之后重新从头遍历
+		iter.Rewind()
+		if assert.True(t, iter.Valid()) {
+			assert.Equal(t, want[0], iter.Key())
+		}
+		iter.Close()
+	}
+}
+
+func testIteratorSeek(t *testing.T, idx index.Indexer, r *records) {
+	putRandomKeys(idx, r, 1000)
+
+	iter := idx.Iterator(false)
+	iter.Seek([]byte("key-00100"))
+	assert.Equal(t, []byte("key-00100"), iter.Key())
+	iter.Seek([]byte("key-00101"))
+	assert.Equal(t, []byte("key-00102"), iter.Key())
+	iter.Next()
+	assert.Equal(t, []byte("key-00104"), iter.Key())
+	iter.Seek([]byte("a"))
+	assert.Equal(t, []byte("key-00000"), iter.Key())
+	iter.Seek([]byte("key-01999"))
+	assert.False(t, iter.Valid())
+	iter.Close()
+
+	iter = idx.Iterator(true)
+	iter.Seek([]byte("key-00100"))
+	assert.Equal(t, []byte("key-00100"), iter.Key())
+	iter.Seek([]byte("key-00101"))
+	assert.Equal(t, []byte("key-00100"), iter.Key())
+	iter.Next()
+	assert.Equal(t, []byte("key-00098"), iter.Key())
+	iter.Seek([]byte("z"))
+	assert.Equal(t, []byte("key-01998"), iter.Key())
+	iter.Seek([]byte("a"))
+	assert.False(t, iter.Valid())
+	iter.Close()
+}
+
+func testEmptyIterator(t *testing.T, idx index.Indexer, r *records) {
+	for _, reverse := range []bool{false, true} {
+		iter := idx.Iterator(reverse)
+		assert.False(t, iter.Valid())
+		iter.Rewind()
+		assert.False(t, iter.Valid())
+		iter.Seek([]byte("key"))
+		assert.False(t, iter.Valid())
+		iter.Close()
+	}
+}
+
+func testConcurrent(t *testing.T, idx index.Indexer, r *records) {
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				key := []byte(fmt.Sprintf("key-%d-%03d", g, i))
+				idx.Put(key, r.newPos(key))
+				assert.NotNil(t, idx.Get(key))
+				if i%4 == 0 {
+					idx.Delete(key)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	assert.Equal(t, 8*150, idx.Size())
+}
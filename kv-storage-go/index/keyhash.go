@@ -0,0 +1,297 @@
+package index
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"unsafe"
+
+	"github.com/tClown11/kv-storage/structure"
+)
+
+const (
+	// 哈希表的初始槽位数量，必须是 2 的幂
+	keyHashMinSlots = 1024
+
+	// 负载因子超过 3/4 时扩容
+	keyHashLoadNum = 3
+	keyHashLoadDen = 4
+)
+
+// fnv-1a 64 位哈希参数
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// RecordKeyFunc 读取位置信息指向的数据的实际 key
+type RecordKeyFunc func(pos *structure.LogRecordPos) ([]byte, error)
+
+// ScanRecordsFunc 顺序遍历数据文件中所有数据的 key 和位置信息，fn 返回 false 时停止遍历
+// 传给 fn 的 key 之后不会被修改
+type ScanRecordsFunc func(fn func(key []byte, pos *structure.LogRecordPos) bool) error
+
+// errKeyHashRecordMissing 遍历数据文件之后仍然有索引中的数据没有找到
+var errKeyHashRecordMissing = errors.New("key hash index: indexed records are missing from data files")
+
+// keyHashSlot 哈希表中的槽位，只保存 key 的哈希值和位置信息
+type keyHashSlot struct {
+	hash   uint64 // key 的哈希值，为 0 表示空槽位
+	fid    uint32
+	size   uint32
+	offset int64
+}
+
+var keyHashSlotSize = int64(unsafe.Sizeof(keyHashSlot{}))
+
+func (s *keyHashSlot) pos() *structure.LogRecordPos {
+	return &structure.LogRecordPos{Fid: s.fid, Offset: s.offset, Size: s.size}
+}
+
+// KeyHashIndex key 哈希索引
+// 内存中不保存 key 本身，只保存 64 位的哈希值和位置信息，哈希值相同时读取数据文件中的 key 进行比较，
+// 适合 key 很大或者数量很多、无法全部放在内存中的场景。
+// 遍历时需要顺序扫描全部的数据文件，并将有效数据的 key 放在内存中排序，代价很高
+type KeyHashIndex struct {
+	slots       []keyHashSlot // 开放寻址的哈希表，线性探测
+	size        int
+	recordKey   RecordKeyFunc
+	scanRecords ScanRecordsFunc
+	cmp         Comparator
+	lock        *sync.RWMutex
+}
+
+// NewKeyHashIndex 初始化 key 哈希索引，recordKey 用于读取数据文件中的实际 key，scanRecords 用于遍历，cmp 为遍历时的排序方式
+func NewKeyHashIndex(recordKey RecordKeyFunc, scanRecords ScanRecordsFunc, cmp Comparator) *KeyHashIndex {
+	if recordKey == nil || scanRecords == nil {
+		panic("key hash index requires record key and scan records readers")
+	}
+	return &KeyHashIndex{
+		slots:       make([]keyHashSlot, keyHashMinSlots),
+		recordKey:   recordKey,
+		scanRecords: scanRecords,
+		cmp:         OrDefault(cmp),
+		lock:        new(sync.RWMutex),
+	}
+}
+
+// Put 读取数据文件出错时忽略，需要获取错误时使用 PutWithError
+func (khi *KeyHashIndex) Put(key []byte, pos *structure.LogRecordPos) *structure.LogRecordPos {
+	oldPos, _ := khi.PutWithError(key, pos)
+	return oldPos
+}
+
+// Get 读取数据文件出错时认为 key 不存在，需要获取错误时使用 GetWithError
+func (khi *KeyHashIndex) Get(key []byte) *structure.LogRecordPos {
+	pos, _ := khi.GetWithError(key)
+	return pos
+}
+
+// Delete 读取数据文件出错时不删除，需要获取错误时使用 DeleteWithError
+func (khi *KeyHashIndex) Delete(key []byte) (*structure.LogRecordPos, bool) {
+	oldPos, ok, _ := khi.DeleteWithError(key)
+	return oldPos, ok
+}
+
+// PutWithError 读取数据文件中的 key 出错时返回对应的错误，索引不会被修改
+func (khi *KeyHashIndex) PutWithError(key []byte, pos *structure.LogRecordPos) (*structure.LogRecordPos, error) {
+	khi.lock.Lock()
+	defer khi.lock.Unlock()
+
+	hash := keyHash(key)
+	idx, found, err := khi.find(key, hash)
+	if err != nil {
+		return nil, err
+	}
+	slot := &khi.slots[idx]
+	var oldPos *structure.LogRecordPos
+	if found {
+		oldPos = slot.pos()
+	}
+	*slot = keyHashSlot{hash: hash, fid: pos.Fid, size: pos.Size, offset: pos.Offset}
+	if !found {
+		khi.size++
+		if khi.size*keyHashLoadDen > len(khi.slots)*keyHashLoadNum {
+			khi.resize(len(khi.slots) * 2)
+		}
+	}
+	return oldPos, nil
+}
+
+// GetWithError 读取数据文件中的 key 出错时返回对应的错误
+func (khi *KeyHashIndex) GetWithError(key []byte) (*structure.LogRecordPos, error) {
+	khi.lock.RLock()
+	defer khi.lock.RUnlock()
+
+	idx, found, err := khi.find(key, keyHash(key))
+	if err != nil || !found {
+		return nil, err
+	}
+	return khi.slots[idx].pos(), nil
+}
+
+// DeleteWithError 读取数据文件中的 key 出错时返回对应的错误，索引不会被修改
+func (khi *KeyHashIndex) DeleteWithError(key []byte) (*structure.LogRecordPos, bool, error) {
+	khi.lock.Lock()
+	defer khi.lock.Unlock()
+
+	idx, found, err := khi.find(key, keyHash(key))
+	if err != nil || !found {
+		return nil, false, err
+	}
+	oldPos := khi.slots[idx].pos()
+
+	// 将后面探测链上的槽位向前移动，保证查找时不会提前遇到空槽位
+	mask := uint64(len(khi.slots) - 1)
+	hole := uint64(idx)
+	for i := (hole + 1) & mask; khi.slots[i].hash != 0; i = (i + 1) & mask {
+		home := khi.slots[i].hash & mask
+		// 槽位的初始位置不在 (hole, i] 之间时才能移动到空洞中
+		if (i-home)&mask >= (i-hole)&mask {
+			khi.slots[hole] = khi.slots[i]
+			hole = i
+		}
+	}
+	khi.slots[hole] = keyHashSlot{}
+	khi.size--
+	return oldPos, true, nil
+}
+
+// ReplacePos 将 key 的位置信息从 oldPos 替换为 newPos，通过哈希值和原来的位置信息定位槽位，不需要读取数据文件，
+// 用于数据文件被替换之后原来的位置信息无法读取到 key 的场景。没有找到对应的槽位时返回 false
+func (khi *KeyHashIndex) ReplacePos(key []byte, oldPos, newPos *structure.LogRecordPos) bool {
+	khi.lock.Lock()
+	defer khi.lock.Unlock()
+
+	hash := keyHash(key)
+	mask := uint64(len(khi.slots) - 1)
+	for i := hash & mask; khi.slots[i].hash != 0; i = (i + 1) & mask {
+		slot := &khi.slots[i]
+		if slot.hash == hash && slot.fid == oldPos.Fid && slot.offset == oldPos.Offset {
+			slot.fid, slot.offset, slot.size = newPos.Fid, newPos.Offset, newPos.Size
+			return true
+		}
+	}
+	return false
+}
+
+// Iterator 复制哈希表的快照之后顺序扫描数据文件，位置信息与快照中的槽位一致的数据才是有效的，
+// 不需要逐条随机读取数据文件。扫描出错时迭代器为空，通过 Err 获取错误
+func (khi *KeyHashIndex) Iterator(reverse bool) Iterator {
+	khi.lock.RLock()
+	slots := make([]keyHashSlot, len(khi.slots))
+	copy(slots, khi.slots)
+	size := khi.size
+	khi.lock.RUnlock()
+
+	values := make([]*indexItem, 0, size)
+	var err error
+	if size > 0 {
+		err = khi.scanRecords(func(key []byte, pos *structure.LogRecordPos) bool {
+			if containsSlot(slots, keyHash(key), pos) {
+				values = append(values, &indexItem{key: key, pos: pos})
+			}
+			// 找到快照中全部的数据之后停止扫描
+			return len(values) < size
+		})
+		if err == nil && len(values) < size {
+			err = errKeyHashRecordMissing
+		}
+		if err != nil {
+			values = values[:0]
+		}
+	}
+	sortIndexItems(values, reverse, khi.cmp)
+	return &keyHashIterator{sliceIterator: newSliceIterator(values, reverse, khi.cmp), err: err}
+}
+
+func (khi *KeyHashIndex) Size() int {
+	khi.lock.RLock()
+	defer khi.lock.RUnlock()
+	return khi.size
+}
+
+// MemSize 索引占用的内存大小
+func (khi *KeyHashIndex) MemSize() int64 {
+	khi.lock.RLock()
+	defer khi.lock.RUnlock()
+	return int64(len(khi.slots)) * keyHashSlotSize
+}
+
+func (khi *KeyHashIndex) Close() error {
+	return nil
+}
+
+// find 查找 key 所在的槽位，不存在时返回探测到的第一个空槽位，读取数据文件中的 key 出错时返回错误
+func (khi *KeyHashIndex) find(key []byte, hash uint64) (int, bool, error) {
+	mask := uint64(len(khi.slots) - 1)
+	for i := hash & mask; ; i = (i + 1) & mask {
+		slot := &khi.slots[i]
+		if slot.hash == 0 {
+			return int(i), false, nil
+		}
+		if slot.hash != hash {
+			continue
+		}
+		// 哈希值相同时需要比较实际的 key，处理哈希冲突
+		recordKey, err := khi.recordKey(slot.pos())
+		if err != nil {
+			return 0, false, err
+		}
+		if bytes.Equal(recordKey, key) {
+			return int(i), true, nil
+		}
+	}
+}
+
+// containsSlot 哈希表中是否有哈希值和位置信息都相同的槽位，不需要读取数据文件
+func containsSlot(slots []keyHashSlot, hash uint64, pos *structure.LogRecordPos) bool {
+	mask := uint64(len(slots) - 1)
+	for i := hash & mask; slots[i].hash != 0; i = (i + 1) & mask {
+		slot := &slots[i]
+		if slot.hash == hash && slot.fid == pos.Fid && slot.offset == pos.Offset {
+			return true
+		}
+	}
+	return false
+}
+
+func (khi *KeyHashIndex) resize(n int) {
+	oldSlots := khi.slots
+	khi.slots = make([]keyHashSlot, n)
+	mask := uint64(n - 1)
+	for _, slot := range oldSlots {
+		if slot.hash == 0 {
+			continue
+		}
+		i := slot.hash & mask
+		for khi.slots[i].hash != 0 {
+			i = (i + 1) & mask
+		}
+		khi.slots[i] = slot
+	}
+}
+
+// keyHash 计算 key 的 fnv-1a 哈希值，0 用于表示空槽位
+func keyHash(key []byte) uint64 {
+	var h uint64 = fnvOffset64
+	for _, b := range key {
+		h ^= uint64(b)
+		h *= fnvPrime64
+	}
+	if h == 0 {
+		h = 1
+	}
+	return h
+}
+
+// keyHashIterator 在有序数组迭代器的基础上记录扫描数据文件时的错误
+type keyHashIterator struct {
+	*sliceIterator
+	err error
+}
+
+// Err 扫描数据文件时的错误
+func (khi *keyHashIterator) Err() error {
+	return khi.err
+}
@@ -0,0 +1,176 @@
+package index
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tClown11/kv-storage/structure"
+)
+
+// 模拟数据文件，以 offset 作为下标保存每条数据的 key
+type keyHashRecords struct {
+	keys [][]byte
+}
+
+func (r *keyHashRecords) pos(key []byte) *structure.LogRecordPos {
+	r.keys = append(r.keys, key)
+	return &structure.LogRecordPos{Fid: 1, Offset: int64(len(r.keys) - 1)}
+}
+
+func (r *keyHashRecords) recordKey(pos *structure.LogRecordPos) ([]byte, error) {
+	return r.keys[pos.Offset], nil
+}
+
+func (r *keyHashRecords) scanRecords(fn func(key []byte, pos *structure.LogRecordPos) bool) error {
+	for i, key := range r.keys {
+		if !fn(key, &structure.LogRecordPos{Fid: 1, Offset: int64(i)}) {
+			break
+		}
+	}
+	return nil
+}
+
+func TestKeyHashIndex_PutGetDelete(t *testing.T) {
+	records := &keyHashRecords{}
+	khi := NewKeyHashIndex(records.recordKey, records.scanRecords, nil)
+
+	pos1 := records.pos([]byte("a"))
+	assert.Nil(t, khi.Put([]byte("a"), pos1))
+	pos2 := records.pos([]byte("a"))
+	assert.Equal(t, pos1, khi.Put([]byte("a"), pos2))
+	pos3 := records.pos([]byte("b"))
+	assert.Nil(t, khi.Put([]byte("b"), pos3))
+	assert.Equal(t, 2, khi.Size())
+
+	assert.Equal(t, pos2, khi.Get([]byte("a")))
+	assert.Equal(t, pos3, khi.Get([]byte("b")))
+	assert.Nil(t, khi.Get([]byte("c")))
+
+	res, ok := khi.Delete([]byte("a"))
+	assert.True(t, ok)
+	assert.Equal(t, pos2, res)
+	_, ok = khi.Delete([]byte("a"))
+	assert.False(t, ok)
+	assert.Nil(t, khi.Get([]byte("a")))
+	assert.Equal(t, 1, khi.Size())
+
+	assert.Panics(t, func() { NewKeyHashIndex(nil, records.scanRecords, nil) })
+	assert.Panics(t, func() { NewKeyHashIndex(records.recordKey, nil, nil) })
+}
+
+// 随机数据与 map 对比，覆盖扩容和删除时槽位的移动
+func TestKeyHashIndex_Random(t *testing.T) {
+	records := &keyHashRecords{}
+	khi := NewKeyHashIndex(records.recordKey, records.scanRecords, nil)
+	expected := make(map[string]*structure.LogRecordPos)
+	r := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 20000; i++ {
+		key := []byte(fmt.Sprintf("key-%d", r.Intn(5000)))
+		if r.Intn(3) == 0 {
+			_, ok := khi.Delete(key)
+			_, exists := expected[string(key)]
+			assert.Equal(t, exists, ok)
+			delete(expected, string(key))
+			continue
+		}
+		pos := records.pos(key)
+		khi.Put(key, pos)
+		expected[string(key)] = pos
+	}
+	assert.Equal(t, len(expected), khi.Size())
+	for key, pos := range expected {
+		assert.Equal(t, pos, khi.Get([]byte(key)))
+	}
+
+	iter := khi.Iterator(false)
+	defer iter.Close()
+	var count int
+	var prev []byte
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, expected[string(iter.Key())], iter.Value())
+		assert.True(t, prev == nil || string(prev) < string(iter.Key()))
+		prev = iter.Key()
+		count++
+	}
+	assert.Equal(t, len(expected), count)
+}
+
+// 替换位置信息时不读取数据文件
+func TestKeyHashIndex_ReplacePos(t *testing.T) {
+	records := &keyHashRecords{}
+	khi := NewKeyHashIndex(records.recordKey, records.scanRecords, nil)
+
+	pos := records.pos([]byte("a"))
+	assert.Nil(t, khi.Put([]byte("a"), pos))
+	newPos := &structure.LogRecordPos{Fid: 2, Offset: 10, Size: 20}
+	// 原来的位置信息不一致时不替换
+	assert.False(t, khi.ReplacePos([]byte("a"), newPos, newPos))
+	assert.False(t, khi.ReplacePos([]byte("b"), pos, newPos))
+
+	// 原来的数据已经无法读取
+	records.keys[pos.Offset] = nil
+	assert.True(t, khi.ReplacePos([]byte("a"), pos, newPos))
+	slot := khi.slots[keyHash([]byte("a"))&uint64(len(khi.slots)-1)]
+	assert.Equal(t, newPos, slot.pos())
+	assert.Equal(t, 1, khi.Size())
+}
+
+// 读取数据文件出错时返回错误，不会修改索引
+func TestKeyHashIndex_Errors(t *testing.T) {
+	records := &keyHashRecords{}
+	var readErr, scanErr error
+	recordKey := func(pos *structure.LogRecordPos) ([]byte, error) {
+		if readErr != nil {
+			return nil, readErr
+		}
+		return records.recordKey(pos)
+	}
+	scanRecords := func(fn func(key []byte, pos *structure.LogRecordPos) bool) error {
+		if scanErr != nil {
+			return scanErr
+		}
+		return records.scanRecords(fn)
+	}
+	khi := NewKeyHashIndex(recordKey, scanRecords, nil)
+
+	pos := records.pos([]byte("a"))
+	assert.Nil(t, khi.Put([]byte("a"), pos))
+
+	readErr = errors.New("read failed")
+	_, err := khi.GetWithError([]byte("a"))
+	assert.Equal(t, readErr, err)
+	assert.Nil(t, khi.Get([]byte("a")))
+	_, err = khi.PutWithError([]byte("a"), records.pos([]byte("a")))
+	assert.Equal(t, readErr, err)
+	_, ok, err := khi.DeleteWithError([]byte("a"))
+	assert.False(t, ok)
+	assert.Equal(t, readErr, err)
+	assert.Equal(t, 1, khi.Size())
+
+	// 遍历时顺序扫描数据文件，不需要读取单条数据的 key
+	iter := khi.Iterator(false).(*keyHashIterator)
+	assert.Nil(t, iter.Err())
+	iter.Rewind()
+	assert.True(t, iter.Valid())
+	assert.Equal(t, []byte("a"), iter.Key())
+	assert.Equal(t, pos, iter.Value())
+
+	readErr = nil
+	assert.Equal(t, pos, khi.Get([]byte("a")))
+
+	scanErr = errors.New("scan failed")
+	iter = khi.Iterator(false).(*keyHashIterator)
+	assert.Equal(t, scanErr, iter.Err())
+	iter.Rewind()
+	assert.False(t, iter.Valid())
+
+	// 数据文件中找不到索引中的数据
+	scanErr = nil
+	records.keys[pos.Offset] = []byte("b")
+	iter = khi.Iterator(false).(*keyHashIterator)
+	assert.Equal(t, errKeyHashRecordMissing, iter.Err())
+}
@@ -0,0 +1,111 @@
+package index
+
+import (
+	"container/heap"
+
+	"github.com/tClown11/kv-storage/structure"
+)
+
+// mergeIterator 将多个有序且 key 互不重叠的迭代器归并为一个有序迭代器
+type mergeIterator struct {
+	iters []Iterator
+	heap  *iterHeap
+}
+
+func newMergeIterator(iters []Iterator, reverse bool, cmp Comparator) *mergeIterator {
+	mi := &mergeIterator{
+		iters: iters,
+		heap:  &iterHeap{reverse: reverse, cmp: cmp},
+	}
+	mi.rebuild()
+	return mi
+}
+
+// Rewind 重新回到迭代器的起点，即第一个数据
+func (mi *mergeIterator) Rewind() {
+	for _, iter := range mi.iters {
+		iter.Rewind()
+	}
+	mi.rebuild()
+}
+
+// Seek 根据传入的 key 查找到第一个大于(或小于) 等于的目标 key，根据从这个 key 开始遍历
+func (mi *mergeIterator) Seek(key []byte) {
+	for _, iter := range mi.iters {
+		iter.Seek(key)
+	}
+	mi.rebuild()
+}
+
+// Next 跳转到下一个 key
+func (mi *mergeIterator) Next() {
+	top := mi.heap.iters[0]
+	top.Next()
+	if top.Valid() {
+		heap.Fix(mi.heap, 0)
+	} else {
+		heap.Pop(mi.heap)
+	}
+}
+
+// Valid 是否有效，即是否已经遍历完了所有的 key，用于退出遍历
+func (mi *mergeIterator) Valid() bool {
+	return len(mi.heap.iters) > 0
+}
+
+// Key 当前遍历位置的 key 数据
+func (mi *mergeIterator) Key() []byte {
+	return mi.heap.iters[0].Key()
+}
+
+// Value 当前遍历位置的 Value 数据
+func (mi *mergeIterator) Value() *structure.LogRecordPos {
+	return mi.heap.iters[0].Value()
+}
+
+// Close 关闭迭代器，释放相应的资源
+func (mi *mergeIterator) Close() {
+	for _, iter := range mi.iters {
+		iter.Close()
+	}
+	mi.heap.iters = nil
+}
+
+// rebuild 将所有有效的迭代器重新放入堆中
+func (mi *mergeIterator) rebuild() {
+	mi.heap.iters = mi.heap.iters[:0]
+	for _, iter := range mi.iters {
+		if iter.Valid() {
+			mi.heap.iters = append(mi.heap.iters, iter)
+		}
+	}
+	heap.Init(mi.heap)
+}
+
+// iterHeap 按照迭代器当前 key 排序的小顶堆，反向遍历时为大顶堆
+type iterHeap struct {
+	iters   []Iterator
+	reverse bool
+	cmp     Comparator
+}
+
+func (h *iterHeap) Len() int { return len(h.iters) }
+
+func (h *iterHeap) Less(i, j int) bool {
+	cmp := h.cmp.Compare(h.iters[i].Key(), h.iters[j].Key())
+	if h.reverse {
+		return cmp > 0
+	}
+	return cmp < 0
+}
+
+func (h *iterHeap) Swap(i, j int) { h.iters[i], h.iters[j] = h.iters[j], h.iters[i] }
+
+func (h *iterHeap) Push(x any) { h.iters = append(h.iters, x.(Iterator)) }
+
+func (h *iterHeap) Pop() any {
+	n := len(h.iters)
+	x := h.iters[n-1]
+	h.iters = h.iters[:n-1]
+	return x
+}
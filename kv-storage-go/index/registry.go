@@ -0,0 +1,69 @@
+package index
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Factory 根据配置项创建索引
+type Factory func(opts *IndexOpts) (Indexer, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[IndexType]Factory)
+)
+
+// Register 注册索引类型，之后可以通过 NewIndexer 或者 db.Options.IndexType 使用
+// 一般在 init 中调用，factory 为 nil 或者类型重复注册时会 panic
+func Register(typ IndexType, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if factory == nil {
+		panic("index: register factory is nil")
+	}
+	if _, dup := factories[typ]; dup {
+		panic(fmt.Sprintf("index: register called twice for index type %d", typ))
+	}
+	factories[typ] = factory
+}
+
+func lookupFactory(typ IndexType) (Factory, bool) {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	factory, ok := factories[typ]
+	return factory, ok
+}
+
+// 注册内置的索引类型
+func init() {
+	Register(BTree, func(opts *IndexOpts) (Indexer, error) {
+		return NewBtree(opts.Size, opts.Comparator), nil
+	})
+	Register(ART, func(opts *IndexOpts) (Indexer, error) {
+		return NewART(opts.Comparator), nil
+	})
+	Register(BPTree, func(opts *IndexOpts) (Indexer, error) {
+		bpt, err := NewBPlusTree(opts.DirPath, opts.Sync, opts.Comparator)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open bptree index: %w", err)
+		}
+		return bpt, nil
+	})
+	Register(Hash, func(opts *IndexOpts) (Indexer, error) {
+		return NewHashIndex(opts.Comparator), nil
+	})
+	Register(SkipList, func(opts *IndexOpts) (Indexer, error) {
+		return NewSkiplist(opts.Comparator), nil
+	})
+	Register(Compact, func(opts *IndexOpts) (Indexer, error) {
+		return NewCompactIndex(opts.Comparator), nil
+	})
+	Register(KeyHash, func(opts *IndexOpts) (Indexer, error) {
+		if opts.RecordKey == nil || opts.ScanRecords == nil {
+			return nil, errors.New("key hash index requires record key and scan records readers")
+		}
+		return NewKeyHashIndex(opts.RecordKey, opts.ScanRecords, opts.Comparator), nil
+	})
+}
@@ -0,0 +1,54 @@
+package index
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tClown11/kv-storage/errs"
+	"github.com/tClown11/kv-storage/structure"
+)
+
+func TestRegister(t *testing.T) {
+	const custom IndexType = 100
+	var created int
+	Register(custom, func(opts *IndexOpts) (Indexer, error) {
+		created++
+		return NewHashIndex(opts.Comparator), nil
+	})
+	defer func() {
+		factoriesMu.Lock()
+		delete(factories, custom)
+		factoriesMu.Unlock()
+	}()
+
+	idx, err := NewIndexer(&IndexOpts{Type: custom})
+	assert.Nil(t, err)
+	assert.IsType(t, &HashIndex{}, idx)
+	idx.Put([]byte("a"), &structure.LogRecordPos{Fid: 1, Offset: 1})
+	assert.NotNil(t, idx.Get([]byte("a")))
+
+	// 分片时每个分片都通过 factory 创建
+	idx, err = NewIndexer(&IndexOpts{Type: custom, Shards: 4})
+	assert.Nil(t, err)
+	assert.IsType(t, &ShardedIndex{}, idx)
+	assert.Equal(t, 5, created)
+
+	assert.Panics(t, func() {
+		Register(custom, func(opts *IndexOpts) (Indexer, error) { return nil, nil })
+	})
+	assert.Panics(t, func() { Register(101, nil) })
+}
+
+func TestNewIndexer_Error(t *testing.T) {
+	_, err := NewIndexer(&IndexOpts{Type: 99})
+	assert.ErrorIs(t, err, errs.ErrIndexTypeUnsupported)
+
+	_, err = NewIndexer(&IndexOpts{Type: KeyHash})
+	assert.NotNil(t, err)
+
+	// 持久化索引不会分片
+	idx, err := NewIndexer(&IndexOpts{Type: BPTree, DirPath: t.TempDir(), Shards: 4})
+	assert.Nil(t, err)
+	assert.IsType(t, &BPlusTree{}, idx)
+	assert.Nil(t, idx.Close())
+}
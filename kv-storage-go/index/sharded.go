@@ -0,0 +1,108 @@
+package index
+
+import (
+	"github.com/tClown11/kv-storage/structure"
+)
+
+// fnv-1a 哈希参数
+const (
+	fnvOffset32 = 2166136261
+	fnvPrime32  = 16777619
+)
+
+// ShardedIndex 分片索引，按照 key 的哈希值将数据分散到多个子索引中
+// 单个 key 的读写只会锁住对应的分片，有序遍历时再将各个分片的迭代器归并
+type ShardedIndex struct {
+	shards []Indexer
+	cmp    Comparator
+}
+
+// NewShardedIndex 创建分片索引，newShard 用于创建每个分片的子索引，cmp 需要与子索引的排序方式一致
+func NewShardedIndex(shardNum int, cmp Comparator, newShard func() Indexer) *ShardedIndex {
+	if shardNum <= 0 {
+		shardNum = 1
+	}
+	shards := make([]Indexer, shardNum)
+	for i := range shards {
+		shards[i] = newShard()
+	}
+	return newShardedIndex(shards, cmp)
+}
+
+func newShardedIndex(shards []Indexer, cmp Comparator) *ShardedIndex {
+	return &ShardedIndex{shards: shards, cmp: OrDefault(cmp)}
+}
+
+// shard 获取 key 所在的分片
+func (si *ShardedIndex) shard(key []byte) Indexer {
+	var h uint32 = fnvOffset32
+	for _, b := range key {
+		h ^= uint32(b)
+		h *= fnvPrime32
+	}
+	return si.shards[h%uint32(len(si.shards))]
+}
+
+func (si *ShardedIndex) Put(key []byte, pos *structure.LogRecordPos) *structure.LogRecordPos {
+	return si.shard(key).Put(key, pos)
+}
+
+func (si *ShardedIndex) Get(key []byte) *structure.LogRecordPos {
+	return si.shard(key).Get(key)
+}
+
+func (si *ShardedIndex) Delete(key []byte) (*structure.LogRecordPos, bool) {
+	return si.shard(key).Delete(key)
+}
+
+// PutWithError 分片不会出错时错误为 nil
+func (si *ShardedIndex) PutWithError(key []byte, pos *structure.LogRecordPos) (*structure.LogRecordPos, error) {
+	return PutWithError(si.shard(key), key, pos)
+}
+
+// GetWithError 分片不会出错时错误为 nil
+func (si *ShardedIndex) GetWithError(key []byte) (*structure.LogRecordPos, error) {
+	return GetWithError(si.shard(key), key)
+}
+
+// DeleteWithError 分片不会出错时错误为 nil
+func (si *ShardedIndex) DeleteWithError(key []byte) (*structure.LogRecordPos, bool, error) {
+	return DeleteWithError(si.shard(key), key)
+}
+
+func (si *ShardedIndex) Iterator(reverse bool) Iterator {
+	iters := make([]Iterator, len(si.shards))
+	for i, shard := range si.shards {
+		iters[i] = shard.Iterator(reverse)
+	}
+	return newMergeIterator(iters, reverse, si.cmp)
+}
+
+func (si *ShardedIndex) Size() int {
+	var size int
+	for _, shard := range si.shards {
+		size += shard.Size()
+	}
+	return size
+}
+
+// MemSize 索引占用的内存大小，不支持统计的分片不计算在内
+func (si *ShardedIndex) MemSize() int64 {
+	var size int64
+	for _, shard := range si.shards {
+		if sizer, ok := shard.(MemSizer); ok {
+			size += sizer.MemSize()
+		}
+	}
+	return size
+}
+
+func (si *ShardedIndex) Close() error {
+	var firstErr error
+	for _, shard := range si.shards {
+		if err := shard.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
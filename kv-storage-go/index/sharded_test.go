@@ -0,0 +1,89 @@
+package index
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tClown11/kv-storage/structure"
+)
+
+func TestShardedIndex_PutGetDelete(t *testing.T) {
+	si := NewShardedIndex(4, nil, func() Indexer { return NewBtree(32, nil) })
+
+	res1 := si.Put([]byte("a"), &structure.LogRecordPos{Fid: 1, Offset: 10})
+	assert.Nil(t, res1)
+	res2 := si.Put([]byte("a"), &structure.LogRecordPos{Fid: 1, Offset: 20})
+	assert.Equal(t, &structure.LogRecordPos{Fid: 1, Offset: 10}, res2)
+	si.Put([]byte("b"), &structure.LogRecordPos{Fid: 1, Offset: 30})
+	assert.Equal(t, 2, si.Size())
+
+	assert.Equal(t, &structure.LogRecordPos{Fid: 1, Offset: 20}, si.Get([]byte("a")))
+	assert.Nil(t, si.Get([]byte("c")))
+
+	pos, ok := si.Delete([]byte("a"))
+	assert.True(t, ok)
+	assert.Equal(t, &structure.LogRecordPos{Fid: 1, Offset: 20}, pos)
+	_, ok = si.Delete([]byte("a"))
+	assert.False(t, ok)
+	assert.Equal(t, 1, si.Size())
+	assert.Nil(t, si.Close())
+}
+
+func TestShardedIndex_Iterator(t *testing.T) {
+	si := NewShardedIndex(8, nil, func() Indexer { return NewBtree(32, nil) })
+
+	// 空索引
+	iter := si.Iterator(false)
+	assert.False(t, iter.Valid())
+	iter.Close()
+
+	for i := 0; i < 500; i++ {
+		si.Put([]byte(fmt.Sprintf("key-%03d", i)), &structure.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	iter1 := si.Iterator(false)
+	defer iter1.Close()
+	var i int
+	for iter1.Rewind(); iter1.Valid(); iter1.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%03d", i)), iter1.Key())
+		assert.Equal(t, int64(i), iter1.Value().Offset)
+		i++
+	}
+	assert.Equal(t, 500, i)
+
+	iter1.Seek([]byte("key-2995"))
+	assert.Equal(t, []byte("key-300"), iter1.Key())
+
+	iter2 := si.Iterator(true)
+	defer iter2.Close()
+	i = 499
+	for iter2.Rewind(); iter2.Valid(); iter2.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%03d", i)), iter2.Key())
+		i--
+	}
+	assert.Equal(t, -1, i)
+
+	iter2.Seek([]byte("key-2995"))
+	assert.Equal(t, []byte("key-299"), iter2.Key())
+}
+
+func TestShardedIndex_Concurrent(t *testing.T) {
+	si := NewShardedIndex(16, nil, func() Indexer { return NewART(nil) })
+
+	wg := new(sync.WaitGroup)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := []byte(fmt.Sprintf("g%d-%d", g, i))
+				si.Put(key, &structure.LogRecordPos{Fid: uint32(g), Offset: int64(i)})
+				assert.NotNil(t, si.Get(key))
+			}
+		}(g)
+	}
+	wg.Wait()
+	assert.Equal(t, 8000, si.Size())
+}
@@ -0,0 +1,196 @@
+package index
+
+import (
+	"bytes"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+
+	"github.com/tClown11/kv-storage/structure"
+)
+
+const (
+	// 跳表的最大层数
+	skiplistMaxLevel = 24
+
+	// 节点晋升到上一层的概率为 1/skiplistBranching
+	skiplistBranching = 4
+
+	// 每个节点除 key 之外的内存占用：节点本身、平均 4/3 层的后继指针以及 LogRecordPos
+	skiplistNodeOverhead = 64 + 11 + 24
+)
+
+// slNode 跳表中的节点，所有的指针都通过原子操作读写
+type slNode struct {
+	key     []byte
+	pos     atomic.Pointer[structure.LogRecordPos]
+	deleted atomic.Bool              // 节点是否已经被删除，删除后仍保留 next 指针，正在遍历的迭代器可以继续向后走
+	next    []atomic.Pointer[slNode] // 每一层的后继节点
+}
+
+// Skiplist 并发跳表索引
+// 写操作之间通过互斥锁串行执行，读操作和遍历完全不加锁，不会被写操作阻塞
+type Skiplist struct {
+	head  *slNode
+	level atomic.Int32 // 当前的最大层数
+	size  atomic.Int64
+	keys  atomic.Int64 // 所有 key 的总大小
+	cmp   Comparator
+	lock  *sync.Mutex // 只用于写操作之间的互斥
+	rand  *rand.Rand  // 只在持有写锁时使用
+}
+
+// NewSkiplist 初始化跳表索引，cmp 为 nil 时按照字节序排序
+func NewSkiplist(cmp Comparator) *Skiplist {
+	sl := &Skiplist{
+		head: &slNode{next: make([]atomic.Pointer[slNode], skiplistMaxLevel)},
+		cmp:  OrDefault(cmp),
+		lock: new(sync.Mutex),
+		rand: rand.New(rand.NewSource(rand.Int63())),
+	}
+	sl.level.Store(1)
+	return sl
+}
+
+func (sl *Skiplist) Put(key []byte, pos *structure.LogRecordPos) *structure.LogRecordPos {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	var preds [skiplistMaxLevel]*slNode
+	node := sl.findGreaterOrEqual(key, preds[:])
+	if node != nil && bytes.Equal(node.key, key) {
+		return node.pos.Swap(pos)
+	}
+
+	level := sl.randomLevel()
+	node = &slNode{key: key, next: make([]atomic.Pointer[slNode], level)}
+	node.pos.Store(pos)
+	// 从最底层开始链接，保证上层可以访问到的节点在下层一定可以访问到
+	for i := 0; i < level; i++ {
+		node.next[i].Store(preds[i].next[i].Load())
+		preds[i].next[i].Store(node)
+	}
+	if int32(level) > sl.level.Load() {
+		sl.level.Store(int32(level))
+	}
+	sl.size.Add(1)
+	sl.keys.Add(int64(len(key)))
+	return nil
+}
+
+func (sl *Skiplist) Get(key []byte) *structure.LogRecordPos {
+	node := sl.findGreaterOrEqual(key, nil)
+	if node == nil || !bytes.Equal(node.key, key) {
+		return nil
+	}
+	return node.pos.Load()
+}
+
+func (sl *Skiplist) Delete(key []byte) (*structure.LogRecordPos, bool) {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	var preds [skiplistMaxLevel]*slNode
+	node := sl.findGreaterOrEqual(key, preds[:])
+	if node == nil || !bytes.Equal(node.key, key) {
+		return nil, false
+	}
+
+	node.deleted.Store(true)
+	for i := len(node.next) - 1; i >= 0; i-- {
+		preds[i].next[i].Store(node.next[i].Load())
+	}
+	sl.size.Add(-1)
+	sl.keys.Add(-int64(len(key)))
+	return node.pos.Load(), true
+}
+
+func (sl *Skiplist) Iterator(reverse bool) Iterator {
+	return newSkiplistIterator(sl, reverse)
+}
+
+func (sl *Skiplist) Size() int {
+	return int(sl.size.Load())
+}
+
+// MemSize 索引占用的内存大小
+func (sl *Skiplist) MemSize() int64 {
+	return sl.size.Load()*skiplistNodeOverhead + sl.keys.Load()
+}
+
+func (sl *Skiplist) Close() error {
+	return nil
+}
+
+// findGreaterOrEqual 查找第一个大于等于 key 的节点，preds 不为空时记录每一层的前驱节点
+func (sl *Skiplist) findGreaterOrEqual(key []byte, preds []*slNode) *slNode {
+	x := sl.head
+	for i := skiplistMaxLevel - 1; i >= 0; i-- {
+		if i < int(sl.level.Load()) {
+			for {
+				next := x.next[i].Load()
+				if next == nil || sl.cmp.Compare(next.key, key) >= 0 {
+					break
+				}
+				x = next
+			}
+		}
+		if preds != nil {
+			preds[i] = x
+		}
+	}
+	return x.next[0].Load()
+}
+
+// findGreaterThan 查找第一个大于 key 的节点
+func (sl *Skiplist) findGreaterThan(key []byte) *slNode {
+	node := sl.findGreaterOrEqual(key, nil)
+	if node != nil && bytes.Equal(node.key, key) {
+		return node.next[0].Load()
+	}
+	return node
+}
+
+// findLessThan 查找最后一个小于 key 的节点，不存在时返回 nil
+func (sl *Skiplist) findLessThan(key []byte) *slNode {
+	x := sl.head
+	for i := int(sl.level.Load()) - 1; i >= 0; i-- {
+		for {
+			next := x.next[i].Load()
+			if next == nil || sl.cmp.Compare(next.key, key) >= 0 {
+				break
+			}
+			x = next
+		}
+	}
+	if x == sl.head {
+		return nil
+	}
+	return x
+}
+
+// findLast 查找最后一个节点，跳表为空时返回 nil
+func (sl *Skiplist) findLast() *slNode {
+	x := sl.head
+	for i := int(sl.level.Load()) - 1; i >= 0; i-- {
+		for {
+			next := x.next[i].Load()
+			if next == nil {
+				break
+			}
+			x = next
+		}
+	}
+	if x == sl.head {
+		return nil
+	}
+	return x
+}
+
+func (sl *Skiplist) randomLevel() int {
+	level := 1
+	for level < skiplistMaxLevel && sl.rand.Intn(skiplistBranching) == 0 {
+		level++
+	}
+	return level
+}
@@ -0,0 +1,90 @@
+package index
+
+import (
+	"bytes"
+
+	"github.com/tClown11/kv-storage/structure"
+)
+
+// 跳表索引迭代器
+// 直接在跳表上遍历，不拷贝数据，遍历过程中跳表可以被并发修改，
+// 已经被删除的节点会被跳过，新写入的数据是否可见取决于其位置是否已经被遍历过
+type skiplistIterator struct {
+	sl      *Skiplist
+	reverse bool    // 是否是反向遍历
+	curr    *slNode // 当前遍历的节点
+}
+
+func newSkiplistIterator(sl *Skiplist, reverse bool) *skiplistIterator {
+	iter := &skiplistIterator{
+		sl:      sl,
+		reverse: reverse,
+	}
+	iter.Rewind()
+	return iter
+}
+
+// Rewind 重新回到迭代器的起点，即第一个数据
+func (sli *skiplistIterator) Rewind() {
+	if sli.reverse {
+		sli.curr = sli.sl.findLast()
+	} else {
+		sli.curr = sli.sl.head.next[0].Load()
+	}
+	sli.skipDeleted()
+}
+
+// Seek 根据传入的 key 查找到第一个大于(或小于) 等于的目标 key，根据从这个 key 开始遍历
+func (sli *skiplistIterator) Seek(key []byte) {
+	node := sli.sl.findGreaterOrEqual(key, nil)
+	if sli.reverse && (node == nil || !bytes.Equal(node.key, key)) {
+		node = sli.sl.findLessThan(key)
+	}
+	sli.curr = node
+	sli.skipDeleted()
+}
+
+// Next 跳转到下一个 key
+func (sli *skiplistIterator) Next() {
+	switch {
+	case sli.reverse:
+		sli.curr = sli.sl.findLessThan(sli.curr.key)
+	case sli.curr.deleted.Load():
+		// 当前节点已经从跳表中移除，它的后继指针可能已经过期，重新查找避免遗漏新写入的数据
+		sli.curr = sli.sl.findGreaterThan(sli.curr.key)
+	default:
+		sli.curr = sli.curr.next[0].Load()
+	}
+	sli.skipDeleted()
+}
+
+// Valid 是否有效，即是否已经遍历完了所有的 key，用于退出遍历
+func (sli *skiplistIterator) Valid() bool {
+	return sli.curr != nil
+}
+
+// Key 当前遍历位置的 key 数据
+func (sli *skiplistIterator) Key() []byte {
+	return sli.curr.key
+}
+
+// Value 当前遍历位置的 Value 数据
+func (sli *skiplistIterator) Value() *structure.LogRecordPos {
+	return sli.curr.pos.Load()
+}
+
+// Close 关闭迭代器，释放相应的资源
+func (sli *skiplistIterator) Close() {
+	sli.curr = nil
+}
+
+// skipDeleted 跳过已经被删除的节点
+func (sli *skiplistIterator) skipDeleted() {
+	for sli.curr != nil && sli.curr.deleted.Load() {
+		if sli.reverse {
+			sli.curr = sli.sl.findLessThan(sli.curr.key)
+		} else {
+			sli.curr = sli.sl.findGreaterThan(sli.curr.key)
+		}
+	}
+}
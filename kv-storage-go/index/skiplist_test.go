@@ -0,0 +1,151 @@
+package index
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tClown11/kv-storage/structure"
+)
+
+func TestSkiplist_PutGetDelete(t *testing.T) {
+	sl := NewSkiplist(nil)
+
+	tests := []struct {
+		key    []byte
+		pos    *structure.LogRecordPos
+		result *structure.LogRecordPos
+	}{
+		{key: nil, pos: &structure.LogRecordPos{Fid: 1, Offset: 100}, result: nil},
+		{key: []byte("a"), pos: &structure.LogRecordPos{Fid: 1, Offset: 2}, result: nil},
+		{key: []byte("a"), pos: &structure.LogRecordPos{Fid: 11, Offset: 12}, result: &structure.LogRecordPos{Fid: 1, Offset: 2}},
+		{key: []byte("b"), pos: &structure.LogRecordPos{Fid: 1, Offset: 3}, result: nil},
+	}
+	for i := range tests {
+		assert.Equal(t, tests[i].result, sl.Put(tests[i].key, tests[i].pos))
+	}
+	assert.Equal(t, 3, sl.Size())
+
+	assert.Equal(t, &structure.LogRecordPos{Fid: 1, Offset: 100}, sl.Get(nil))
+	assert.Equal(t, &structure.LogRecordPos{Fid: 11, Offset: 12}, sl.Get([]byte("a")))
+	assert.Nil(t, sl.Get([]byte("c")))
+
+	pos, ok := sl.Delete([]byte("a"))
+	assert.True(t, ok)
+	assert.Equal(t, &structure.LogRecordPos{Fid: 11, Offset: 12}, pos)
+	_, ok = sl.Delete([]byte("a"))
+	assert.False(t, ok)
+	assert.Nil(t, sl.Get([]byte("a")))
+	assert.Equal(t, 2, sl.Size())
+}
+
+func TestSkiplist_Iterator(t *testing.T) {
+	sl := NewSkiplist(nil)
+
+	iter := sl.Iterator(false)
+	assert.False(t, iter.Valid())
+	iter.Close()
+
+	for i := 0; i < 100; i++ {
+		sl.Put([]byte(fmt.Sprintf("key-%03d", i)), &structure.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	iter1 := sl.Iterator(false)
+	var i int
+	for iter1.Rewind(); iter1.Valid(); iter1.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%03d", i)), iter1.Key())
+		assert.Equal(t, int64(i), iter1.Value().Offset)
+		i++
+	}
+	assert.Equal(t, 100, i)
+	iter1.Seek([]byte("key-0505"))
+	assert.Equal(t, []byte("key-051"), iter1.Key())
+
+	iter2 := sl.Iterator(true)
+	i = 99
+	for iter2.Rewind(); iter2.Valid(); iter2.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%03d", i)), iter2.Key())
+		i--
+	}
+	assert.Equal(t, -1, i)
+	iter2.Seek([]byte("key-0505"))
+	assert.Equal(t, []byte("key-050"), iter2.Key())
+	iter2.Seek([]byte("key-050"))
+	assert.Equal(t, []byte("key-050"), iter2.Key())
+
+	// 遍历过程中修改跳表，迭代器依然有效，已删除的数据会被跳过
+	iter3 := sl.Iterator(false)
+	iter3.Seek([]byte("key-010"))
+	sl.Delete([]byte("key-010"))
+	sl.Delete([]byte("key-011"))
+	sl.Put([]byte("key-0115"), &structure.LogRecordPos{Fid: 1})
+	assert.Equal(t, []byte("key-010"), iter3.Key())
+	iter3.Next()
+	assert.Equal(t, []byte("key-0115"), iter3.Key())
+	iter3.Next()
+	assert.Equal(t, []byte("key-012"), iter3.Key())
+}
+
+func TestSkiplist_Concurrent(t *testing.T) {
+	sl := NewSkiplist(nil)
+	for i := 0; i < 1000; i += 2 {
+		sl.Put([]byte(fmt.Sprintf("key-%04d", i)), &structure.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	wg := new(sync.WaitGroup)
+	stop := make(chan struct{})
+	// 写操作
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(stop)
+		r := rand.New(rand.NewSource(1))
+		for i := 0; i < 20000; i++ {
+			key := []byte(fmt.Sprintf("key-%04d", r.Intn(1000)))
+			if r.Intn(2) == 0 {
+				sl.Put(key, &structure.LogRecordPos{Fid: 1, Offset: int64(i)})
+			} else {
+				sl.Delete(key)
+			}
+		}
+	}()
+	// 读操作和遍历与写操作同时进行，遍历结果始终有序
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(reverse bool) {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				iter := sl.Iterator(reverse)
+				var prev []byte
+				for iter.Rewind(); iter.Valid(); iter.Next() {
+					if prev != nil {
+						if reverse {
+							assert.Less(t, string(iter.Key()), string(prev))
+						} else {
+							assert.Greater(t, string(iter.Key()), string(prev))
+						}
+					}
+					prev = iter.Key()
+					sl.Get(prev)
+				}
+				iter.Close()
+			}
+		}(g%2 == 0)
+	}
+	wg.Wait()
+
+	var count int
+	iter := sl.Iterator(false)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.NotNil(t, sl.Get(iter.Key()))
+		count++
+	}
+	assert.Equal(t, sl.Size(), count)
+}
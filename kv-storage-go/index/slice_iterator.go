@@ -0,0 +1,83 @@
+package index
+
+import (
+	"sort"
+
+	"github.com/tClown11/kv-storage/structure"
+)
+
+// indexItem 迭代器快照中的单个数据对象
+type indexItem struct {
+	key []byte
+	pos *structure.LogRecordPos
+}
+
+// sortIndexItems 按照比较器和遍历方向对快照中的数据排序
+func sortIndexItems(values []*indexItem, reverse bool, cmp Comparator) {
+	sort.Slice(values, func(i, j int) bool {
+		if reverse {
+			return cmp.Compare(values[i].key, values[j].key) > 0
+		}
+		return cmp.Compare(values[i].key, values[j].key) < 0
+	})
+}
+
+// 有序数组索引迭代器，遍历创建时的数据快照，values 需要已经按照遍历方向排好序
+type sliceIterator struct {
+	currIndex int  // 当前遍历的下标位置
+	reverse   bool // 是否是反向遍历
+	cmp       Comparator
+	values    []*indexItem // key+位置索引信息
+}
+
+func newSliceIterator(values []*indexItem, reverse bool, cmp Comparator) *sliceIterator {
+	return &sliceIterator{
+		currIndex: 0,
+		reverse:   reverse,
+		cmp:       cmp,
+		values:    values,
+	}
+}
+
+// Rewind 重新回到迭代器的起点，即第一个数据
+func (si *sliceIterator) Rewind() {
+	si.currIndex = 0
+}
+
+// Seek 根据传入的 key 查找到第一个大于(或小于) 等于的目标 key，根据从这个 key 开始遍历
+func (si *sliceIterator) Seek(key []byte) {
+	if si.reverse {
+		si.currIndex = sort.Search(len(si.values), func(i int) bool {
+			return si.cmp.Compare(si.values[i].key, key) <= 0
+		})
+	} else {
+		si.currIndex = sort.Search(len(si.values), func(i int) bool {
+			return si.cmp.Compare(si.values[i].key, key) >= 0
+		})
+	}
+}
+
+// Next 跳转到下一个 key
+func (si *sliceIterator) Next() {
+	si.currIndex += 1
+}
+
+// Valid 是否有效，即是否已经遍历完了所有的 key，用于退出遍历
+func (si *sliceIterator) Valid() bool {
+	return si.currIndex < len(si.values)
+}
+
+// Key 当前遍历位置的 key 数据
+func (si *sliceIterator) Key() []byte {
+	return si.values[si.currIndex].key
+}
+
+// Value 当前遍历位置的 Value 数据
+func (si *sliceIterator) Value() *structure.LogRecordPos {
+	return si.values[si.currIndex].pos
+}
+
+// Close 关闭迭代器，释放相应的资源
+func (si *sliceIterator) Close() {
+	si.values = nil
+}
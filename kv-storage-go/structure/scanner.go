@@ -0,0 +1,150 @@
+package structure
+
+import (
+	"io"
+
+	"github.com/tClown11/kv-storage/errs"
+)
+
+// DefaultScanBufferSize Scanner 每次从文件中读取的数据大小
+const DefaultScanBufferSize = 256 * 1024
+
+// Scanner 从头到尾顺序读取文件中的记录，每次从文件中读取一大块数据，再从缓冲区中依次解码
+// 文件大小只在创建时获取一次，适用于启动时加载索引、merge 等不会同时写入的文件。
+// 与 ReadLogRecord 一样，读取到全为 0 的 header 或者不完整的记录时认为到达了文件末尾
+//
+//	scanner := file.NewScanner(0)
+//	for scanner.Next() {
+//		record, offset, size := scanner.Record(), scanner.Offset(), scanner.Size()
+//	}
+//	if err := scanner.Err(); err != nil {
+//	}
+type Scanner struct {
+	file     *StorageFile
+	fileSize int64
+	buf      []byte
+	data     []byte // 缓冲区中有效的数据
+	dataOff  int64  // data 在文件中的位置
+	offset   int64  // 当前记录的位置，结束之后为最后一条有效记录的末尾
+	size     int64  // 当前记录的大小
+	record   *LogRecord
+	done     bool
+	err      error
+}
+
+// NewScanner 创建从 offset 开始顺序读取记录的 Scanner
+func (sf *StorageFile) NewScanner(offset int64) *Scanner {
+	return sf.NewScannerSize(offset, DefaultScanBufferSize)
+}
+
+// NewScannerSize 创建缓冲区大小为 bufSize 的 Scanner，单条记录大于缓冲区时缓冲区会扩大
+func (sf *StorageFile) NewScannerSize(offset int64, bufSize int) *Scanner {
+	s := &Scanner{file: sf, offset: offset, buf: make([]byte, bufSize)}
+	s.fileSize, s.err = sf.IoManager.Size()
+	return s
+}
+
+// Next 读取下一条记录，到达文件末尾或者出错时返回 false
+func (s *Scanner) Next() bool {
+	if s.done || s.err != nil {
+		return false
+	}
+	s.offset += s.size
+	s.size, s.record = 0, nil
+
+	headerBytes := min(int64(maxLogRecordHeaderSize), s.fileSize-s.offset)
+	if headerBytes <= 0 {
+		s.done = true
+		return false
+	}
+	headerBuf, err := s.fill(headerBytes)
+	if err != nil {
+		s.setErr(err)
+		return false
+	}
+	header := &logRecordHeader{}
+	headerSize := header.DecodeLogRecordHeader(headerBuf)
+	if header.crc == 0 && header.keySize == 0 && header.valueSize == 0 {
+		s.done = true
+		return false
+	}
+
+	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
+	recordSize := headerSize + keySize + valueSize
+	if s.offset+recordSize > s.fileSize {
+		s.done = true
+		return false
+	}
+	recordBuf, err := s.fill(recordSize)
+	if err != nil {
+		s.setErr(err)
+		return false
+	}
+
+	// key 和 value 拷贝出来，缓冲区之后会被覆盖
+	record := &LogRecord{Type: header.recordType}
+	if keySize > 0 || valueSize > 0 {
+		kvBuf := make([]byte, keySize+valueSize)
+		copy(kvBuf, recordBuf[headerSize:])
+		record.Key = kvBuf[:keySize]
+		record.Value = kvBuf[keySize:]
+	}
+	if record.EncodeCRCFromBytes(recordBuf[crcLength:headerSize]) != header.crc {
+		s.err = errs.ErrInvalidCRC
+		return false
+	}
+	s.record, s.size = record, recordSize
+	return true
+}
+
+// setErr 记录读取过程中的错误，读取到文件末尾时正常结束
+func (s *Scanner) setErr(err error) {
+	if err == io.EOF {
+		s.done = true
+		return
+	}
+	s.err = err
+}
+
+// fill 返回从当前记录开始的 n 个字节，缓冲区中的数据不足时从当前记录的位置开始重新读取
+func (s *Scanner) fill(n int64) ([]byte, error) {
+	start := s.offset - s.dataOff
+	if s.data != nil && start >= 0 && start+n <= int64(len(s.data)) {
+		return s.data[start : start+n], nil
+	}
+
+	if int64(len(s.buf)) < n {
+		s.buf = make([]byte, n)
+	}
+	readSize := min(int64(len(s.buf)), s.fileSize-s.offset)
+	read, err := s.file.IoManager.Read(s.buf[:readSize], s.offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	s.data, s.dataOff = s.buf[:read], s.offset
+	// 文件在获取大小之后被截断，认为到达了文件末尾
+	if int64(read) < n {
+		return nil, io.EOF
+	}
+	return s.data[:n], nil
+}
+
+// Record 当前的记录
+func (s *Scanner) Record() *LogRecord {
+	return s.record
+}
+
+// Offset 当前记录在文件中的位置，Next 返回 false 之后为最后一条有效记录的末尾
+func (s *Scanner) Offset() int64 {
+	return s.offset
+}
+
+// Size 当前记录的大小
+func (s *Scanner) Size() int64 {
+	return s.size
+}
+
+// Err 读取过程中的错误，正常到达文件末尾时为 nil
+func (s *Scanner) Err() error {
+	return s.err
+}
@@ -0,0 +1,140 @@
+package structure
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tClown11/kv-storage/errs"
+	"github.com/tClown11/kv-storage/fio"
+)
+
+// countingIOManager 统计读取文件和获取文件大小的次数
+type countingIOManager struct {
+	fio.IOManager
+	reads int
+	sizes int
+}
+
+func (cio *countingIOManager) Read(buf []byte, offset int64) (int, error) {
+	cio.reads++
+	return cio.IOManager.Read(buf, offset)
+}
+
+func (cio *countingIOManager) Size() (int64, error) {
+	cio.sizes++
+	return cio.IOManager.Size()
+}
+
+func (cio *countingIOManager) Truncate(size int64) error {
+	return cio.IOManager.(fio.Truncater).Truncate(size)
+}
+
+func newTestScanFile(t *testing.T, records []*LogRecord) (*StorageFile, *countingIOManager) {
+	mfs := fio.NewMemFileSystem()
+	assert.Nil(t, mfs.MkdirAll("/scan"))
+	file, err := newStorageFile(mfs, GetStorageFileName("/scan", 0), 0, fio.StandardFIO)
+	assert.Nil(t, err)
+	for _, record := range records {
+		encRecord, _ := record.EncodeLogRecord()
+		assert.Nil(t, file.Write(encRecord))
+	}
+	counting := &countingIOManager{IOManager: file.IoManager}
+	file.IoManager = counting
+	return file, counting
+}
+
+func TestScanner(t *testing.T) {
+	var records []*LogRecord
+	for i := 0; i < 10000; i++ {
+		records = append(records, &LogRecord{
+			Key:   []byte(fmt.Sprintf("scanner-key-%09d", i)),
+			Value: []byte(fmt.Sprintf("scanner-value-%d", i)),
+			Type:  LogRecordType(i % 2),
+		})
+	}
+	file, counting := newTestScanFile(t, records)
+
+	// 记录、位置和大小与 ReadLogRecord 读取的结果一致
+	scanner := file.NewScannerSize(0, 64*1024)
+	var i int
+	var offset int64
+	for scanner.Next() {
+		assert.Equal(t, records[i], scanner.Record())
+		assert.Equal(t, offset, scanner.Offset())
+		_, size := records[i].EncodeLogRecord()
+		assert.Equal(t, size, scanner.Size())
+		offset += size
+		i++
+	}
+	assert.Nil(t, scanner.Err())
+	assert.Equal(t, len(records), i)
+	assert.Equal(t, file.WriteOff, scanner.Offset())
+	assert.Equal(t, 1, counting.sizes)
+	assert.True(t, counting.reads < 20, "reads: %d", counting.reads)
+
+	// 从中间开始读取
+	scanner = file.NewScanner(offset - scanner.Size())
+	assert.False(t, scanner.Next())
+	assert.Nil(t, scanner.Err())
+	_, size := records[len(records)-1].EncodeLogRecord()
+	scanner = file.NewScanner(offset - size)
+	assert.True(t, scanner.Next())
+	assert.Equal(t, records[len(records)-1], scanner.Record())
+	assert.False(t, scanner.Next())
+}
+
+func TestScanner_LargeRecord(t *testing.T) {
+	records := []*LogRecord{
+		{Key: []byte("small"), Value: []byte("value")},
+		{Key: []byte("large"), Value: bytes.Repeat([]byte("v"), 10000)},
+		{Key: []byte("deleted"), Value: []byte{}, Type: LogRecordDeleted},
+	}
+	file, _ := newTestScanFile(t, records)
+
+	// 记录大于缓冲区时扩大缓冲区
+	scanner := file.NewScannerSize(0, 16)
+	var scanned []*LogRecord
+	for scanner.Next() {
+		scanned = append(scanned, scanner.Record())
+	}
+	assert.Nil(t, scanner.Err())
+	assert.Equal(t, records, scanned)
+}
+
+func TestScanner_Tail(t *testing.T) {
+	records := []*LogRecord{
+		{Key: []byte("key-1"), Value: []byte("value-1")},
+		{Key: []byte("key-2"), Value: []byte("value-2")},
+	}
+	file, _ := newTestScanFile(t, records)
+	end := file.WriteOff
+
+	// 预分配的 0 和不完整的记录都认为是文件末尾
+	assert.Nil(t, file.Write(make([]byte, 64)))
+	scanner := file.NewScanner(0)
+	for scanner.Next() {
+	}
+	assert.Nil(t, scanner.Err())
+	assert.Equal(t, end, scanner.Offset())
+
+	assert.Nil(t, file.Truncate(end))
+	encRecord, _ := (&LogRecord{Key: []byte("key-3"), Value: []byte("value-3")}).EncodeLogRecord()
+	assert.Nil(t, file.Write(encRecord[:len(encRecord)-1]))
+	scanner = file.NewScanner(0)
+	for scanner.Next() {
+	}
+	assert.Nil(t, scanner.Err())
+	assert.Equal(t, end, scanner.Offset())
+
+	// 数据损坏时返回错误，位置为损坏的记录
+	assert.Nil(t, file.Truncate(end))
+	encRecord[len(encRecord)-1] ^= 0xff
+	assert.Nil(t, file.Write(encRecord))
+	scanner = file.NewScanner(0)
+	for scanner.Next() {
+	}
+	assert.Equal(t, errs.ErrInvalidCRC, scanner.Err())
+	assert.Equal(t, end, scanner.Offset())
+}
@@ -14,6 +14,10 @@ const (
 	HintFileName          = "hint-index"
 	MergeFinishedfileName = "merge-finished"
 	SeqNoFileName         = "seq-no"
+	ComparatorFileName    = "comparator"
+
+	IndexCheckpointFileName     = "index-checkpoint"
+	IndexCheckpointTempFileName = "index-checkpoint.tmp"
 )
 
 type StorageFile struct {
@@ -23,21 +27,39 @@ type StorageFile struct {
 }
 
 // OpenHintFile 打开 Hint 索引文件
-func OpenHintFile(dirPath string) (*StorageFile, error) {
+func OpenHintFile(fs fio.FileSystem, dirPath string) (*StorageFile, error) {
 	fileName := filepath.Join(dirPath, HintFileName)
-	return newStorageFile(fileName, 0, fio.StandardFIO)
+	return newStorageFile(fs, fileName, 0, fio.StandardFIO)
 }
 
 // OpenMergeFinishedFile 打开标识 merge 完成的文件
-func OpenMergeFinishedFile(dirPath string) (*StorageFile, error) {
+func OpenMergeFinishedFile(fs fio.FileSystem, dirPath string) (*StorageFile, error) {
 	fileName := filepath.Join(dirPath, MergeFinishedfileName)
-	return newStorageFile(fileName, 0, fio.StandardFIO)
+	return newStorageFile(fs, fileName, 0, fio.StandardFIO)
 }
 
 // OpenSeqNoFile 存储事务序列号的文件
-func OpenSeqNoFile(dirPath string) (*StorageFile, error) {
+func OpenSeqNoFile(fs fio.FileSystem, dirPath string) (*StorageFile, error) {
 	fileName := filepath.Join(dirPath, SeqNoFileName)
-	return newStorageFile(fileName, 0, fio.StandardFIO)
+	return newStorageFile(fs, fileName, 0, fio.StandardFIO)
+}
+
+// OpenComparatorFile 打开记录比较器名称的文件
+func OpenComparatorFile(fs fio.FileSystem, dirPath string) (*StorageFile, error) {
+	fileName := filepath.Join(dirPath, ComparatorFileName)
+	return newStorageFile(fs, fileName, 0, fio.StandardFIO)
+}
+
+// OpenIndexCheckpointFile 打开索引检查点文件
+func OpenIndexCheckpointFile(fs fio.FileSystem, dirPath string) (*StorageFile, error) {
+	fileName := filepath.Join(dirPath, IndexCheckpointFileName)
+	return newStorageFile(fs, fileName, 0, fio.StandardFIO)
+}
+
+// OpenIndexCheckpointTempFile 打开写入中的索引检查点临时文件，写完之后重命名为正式的检查点文件
+func OpenIndexCheckpointTempFile(fs fio.FileSystem, dirPath string) (*StorageFile, error) {
+	fileName := filepath.Join(dirPath, IndexCheckpointTempFileName)
+	return newStorageFile(fs, fileName, 0, fio.StandardFIO)
 }
 
 func GetDataFileName(dirPath string, fileId uint32) string {
@@ -57,12 +79,30 @@ func (sf *StorageFile) WriteHintRecord(key []byte, pos *LogRecordPos) error {
 func (sf *StorageFile) Write(buf []byte) error {
 	n, err := sf.IoManager.Write(buf)
 	if err != nil {
+		// 只写入了部分数据时截断到写入之前的位置，避免文件中残留不完整的记录
+		// 无法截断时跳过这部分数据，保证之后写入的位置信息是正确的
+		if n > 0 && sf.Truncate(sf.WriteOff) != nil {
+			sf.WriteOff += int64(n)
+		}
 		return err
 	}
 	sf.WriteOff += int64(n)
 	return nil
 }
 
+// Truncate 将文件截断到 size 大小，之后从截断的位置继续写入
+func (sf *StorageFile) Truncate(size int64) error {
+	truncater, ok := sf.IoManager.(fio.Truncater)
+	if !ok {
+		return errs.ErrTruncateUnsupported
+	}
+	if err := truncater.Truncate(size); err != nil {
+		return err
+	}
+	sf.WriteOff = size
+	return nil
+}
+
 func (sf *StorageFile) Sync() error {
 	return sf.IoManager.Sync()
 }
@@ -79,6 +119,11 @@ func (sf *StorageFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 		return nil, 0, err
 	}
 
+	// 偏移已经超过了文件的末尾，例如文件被截断
+	if offset >= fileSize {
+		return nil, 0, io.EOF
+	}
+
 	// 如果读取的最大 header 长度已经超过了文件的长度，则只需要读取到文件的末尾即可
 	var headerBytes int64 = maxLogRecordHeaderSize
 	if offset+maxLogRecordHeaderSize > fileSize {
@@ -102,6 +147,10 @@ func (sf *StorageFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	// 取出对应的 key 和 value 的长度
 	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
 	var recordSize = headerSize + keySize + valueSize
+	// 记录超出了文件末尾，说明没有完整写入
+	if offset+recordSize > fileSize {
+		return nil, 0, io.EOF
+	}
 
 	logRecord := &LogRecord{Type: header.recordType}
 
@@ -133,16 +182,34 @@ func (sf *StorageFile) fillBufWithOffset(buf []byte, offset int64) error {
 
 func OpenStorageFile(dirPath string, fileID uint32, ioType fio.FileIOType) (*StorageFile, error) {
 	fileName := GetStorageFileName(dirPath, fileID)
-	return newStorageFile(fileName, fileID, ioType)
+	return newStorageFile(fio.OS, fileName, fileID, ioType)
+}
+
+// NewStorageFile 使用已经打开的 IO 管理器创建数据文件
+func NewStorageFile(fileID uint32, ioManager fio.IOManager) *StorageFile {
+	return &StorageFile{
+		FileID:    fileID,
+		WriteOff:  0,
+		IoManager: ioManager,
+	}
+}
+
+// SetIOManager 关闭当前的 IO 管理器，替换为新的 IO 管理器
+func (sf *StorageFile) SetIOManager(ioManager fio.IOManager) error {
+	if err := sf.IoManager.Close(); err != nil {
+		return err
+	}
+	sf.IoManager = ioManager
+	return nil
 }
 
 func GetStorageFileName(dirPath string, fileID uint32) string {
 	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileID)+StorageFileNameSuffix)
 }
 
-func newStorageFile(fileName string, fileId uint32, ioType fio.FileIOType) (*StorageFile, error) {
+func newStorageFile(fs fio.FileSystem, fileName string, fileId uint32, ioType fio.FileIOType) (*StorageFile, error) {
 	// 初始化 IOManager 管理器接口
-	ioManager, err := fio.NewIOManager(fileName, ioType)
+	ioManager, err := fs.OpenFile(fileName, ioType)
 	if err != nil {
 		return nil, err
 	}